@@ -0,0 +1,91 @@
+/*
+Command hamt-server shares one in-memory hamt64 Hamt between processes over
+the Redis protocol, RESP2, so any Redis client library can use it.
+
+Usage:
+
+	hamt-server [-network tcp|unix] [-addr address] [-tables option]
+
+It implements the commands GET, SET, DEL, EXISTS, DBSIZE, SCAN, MULTI, EXEC,
+DISCARD, PING and QUIT. SET takes no options. The cursor of SCAN is a
+position in the trie, so a scan is never restarted by writes: every key
+present from the first SCAN call to the last is returned exactly once.
+
+Reads are served from the latest HamtFunctional snapshot without locks;
+writes and MULTI/EXEC transactions are applied one at a time and replace the
+snapshot atomically. Nothing is persisted.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+func main() {
+	var network = flag.String("network", "tcp", "tcp or unix")
+	var addr = flag.String("addr", "localhost:6380",
+		"the address, or socket path, to listen on")
+	var tables = flag.String("tables", "HybridTables",
+		"the table option: HybridTables, FixedTables or SparseTables")
+	flag.Parse()
+
+	var tblOpt = -1
+	for opt, name := range hamt64.TableOptionName {
+		if name == *tables {
+			tblOpt = opt
+		}
+	}
+	if tblOpt < 0 {
+		fmt.Fprintf(os.Stderr, "hamt-server: unknown table option %q\n",
+			*tables)
+		os.Exit(2)
+	}
+
+	if *network == "unix" {
+		if err := removeStaleSocket(*addr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var ln, err = net.Listen(*network, *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var s = NewServer(tblOpt)
+	var sigs = make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		s.Close()
+	}()
+
+	log.Printf("hamt-server listening on %s %s", *network, ln.Addr())
+	if err = s.Serve(ln); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// removeStaleSocket removes the unix socket a previous run left at path, so
+// Listen can bind it again. Anything else at path is left alone, and is an
+// error.
+func removeStaleSocket(path string) error {
+	var fi, err = os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The RESP2 values are represented by these Go types:
+//
+//	simpleString  +OK
+//	respError     -ERR message
+//	int64         :42
+//	[]byte        $3 foo
+//	nil           $-1, the null bulk string
+//	[]interface{} *2 followed by the elements
+
+type simpleString string
+
+type respError string
+
+// maxBulkLen is the longest bulk string accepted, as in Redis.
+const maxBulkLen = 512 << 20
+
+// maxArrayLen bounds the number of elements of an array, so a corrupt length
+// can not make readValue allocate an arbitrary amount of memory.
+const maxArrayLen = 1 << 20
+
+// protocolError is the error for a malformed request; the connection is
+// closed after one.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// readLine reads a line ending in \r\n and returns it without the \r\n.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	var line, err = r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, protocolError("line too long")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, protocolError("line not ended by CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+// readValue reads one RESP2 value.
+func readValue(r *bufio.Reader) (interface{}, error) {
+	var line, err = readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, protocolError("empty line")
+	}
+
+	var body = string(line[1:])
+	switch line[0] {
+	case '+':
+		return simpleString(body), nil
+	case '-':
+		return respError(body), nil
+	case ':':
+		var n, err = strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, protocolError("bad integer")
+		}
+		return n, nil
+	case '$':
+		var n, err = strconv.Atoi(body)
+		if err != nil || n < -1 || n > maxBulkLen {
+			return nil, protocolError("bad bulk length")
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		var bs = make([]byte, n+2)
+		if _, err = io.ReadFull(r, bs); err != nil {
+			return nil, noEOF(err)
+		}
+		if bs[n] != '\r' || bs[n+1] != '\n' {
+			return nil, protocolError("bulk not ended by CRLF")
+		}
+		return bs[:n], nil
+	case '*':
+		var n, err = strconv.Atoi(body)
+		if err != nil || n < -1 || n > maxArrayLen {
+			return nil, protocolError("bad array length")
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		var vals = make([]interface{}, n)
+		for i := range vals {
+			if vals[i], err = readValue(r); err != nil {
+				return nil, noEOF(err)
+			}
+		}
+		return vals, nil
+	}
+	return nil, protocolError(fmt.Sprintf("unknown type %q", line[0]))
+}
+
+// readCommand reads a command: an array of bulk strings, or an inline
+// command of words separated by spaces, as typed into telnet. It returns no
+// args for an empty inline command.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	var first, err = r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] != '*' {
+		var line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		var args = bytes.Fields(line)
+		for i, arg := range args {
+			args[i] = append([]byte(nil), arg...)
+		}
+		return args, nil
+	}
+
+	v, err := readValue(r)
+	if err != nil {
+		return nil, err
+	}
+	var vals, _ = v.([]interface{})
+	var args = make([][]byte, len(vals))
+	for i, val := range vals {
+		var arg, isBulk = val.([]byte)
+		if !isBulk {
+			return nil, protocolError(
+				"command is not an array of bulk strings")
+		}
+		args[i] = arg
+	}
+	return args, nil
+}
+
+// writeValue writes v as a RESP2 value.
+func writeValue(w *bufio.Writer, v interface{}) {
+	switch x := v.(type) {
+	case simpleString:
+		w.WriteByte('+')
+		w.WriteString(string(x))
+	case respError:
+		w.WriteByte('-')
+		w.WriteString(string(x))
+	case int64:
+		w.WriteByte(':')
+		w.WriteString(strconv.FormatInt(x, 10))
+	case []byte:
+		w.WriteByte('$')
+		w.WriteString(strconv.Itoa(len(x)))
+		w.WriteString("\r\n")
+		w.Write(x)
+	case nil:
+		w.WriteString("$-1")
+	case []interface{}:
+		w.WriteByte('*')
+		w.WriteString(strconv.Itoa(len(x)))
+		w.WriteString("\r\n")
+		for _, elt := range x {
+			writeValue(w, elt)
+		}
+		return
+	default:
+		panic("writeValue: unknown type")
+	}
+	w.WriteString("\r\n")
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for reads inside a value.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
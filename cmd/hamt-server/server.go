@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+// Server serves one Hamt to RESP2 clients. Keys are stored as
+// hamt64.StringKeys and values as strings; both may hold any bytes.
+//
+// The current state is a HamtFunctional root. Commands that only read run on
+// the root they load, without locks, so they never wait for writers. Commands
+// that write, one at a time, modify a HamtTransient made from the root, and
+// replace the root with its Persistent HamtFunctional when done. A MULTI/EXEC
+// transaction is applied the same way, as one unit, so no reader sees part of
+// it.
+type Server struct {
+	root atomic.Pointer[hamt64.HamtFunctional]
+	mu   sync.Mutex // serializes writes
+
+	connMu sync.Mutex // guards the fields below
+	lns    map[net.Listener]struct{}
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewServer constructs a Server with an empty Hamt with the table option
+// tblOpt.
+func NewServer(tblOpt int) *Server {
+	var s = new(Server)
+	s.root.Store(hamt64.NewFunctional(tblOpt))
+	s.lns = make(map[net.Listener]struct{})
+	s.conns = make(map[net.Conn]struct{})
+	return s
+}
+
+// Snapshot returns the current root.
+func (s *Server) Snapshot() *hamt64.HamtFunctional {
+	return s.root.Load()
+}
+
+// Serve accepts connections on ln, and serves each on its own goroutine,
+// until ln fails or the Server is closed. It returns nil if the Server was
+// closed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.lns[ln] = struct{}{}
+	s.connMu.Unlock()
+
+	for {
+		var c, err = ln.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			defer s.connMu.Unlock()
+			delete(s.lns, ln)
+			if s.closed {
+				return nil
+			}
+			return errors.Wrap(err, "Serve")
+		}
+
+		s.connMu.Lock()
+		if s.closed {
+			s.connMu.Unlock()
+			c.Close()
+			continue
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.connMu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(c)
+			s.connMu.Lock()
+			delete(s.conns, c)
+			s.connMu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+// Close closes the listeners and connections of every Serve call, and waits
+// for the connections to be done.
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	s.closed = true
+	for ln := range s.lns {
+		ln.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.connMu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// serveConn reads commands from c and writes their replies until the client
+// quits or the connection fails. Replies are flushed once there are no more
+// pipelined commands to read.
+func (s *Server) serveConn(c net.Conn) {
+	var r = bufio.NewReader(c)
+	var w = bufio.NewWriter(c)
+	var tx = new(multi)
+
+	for {
+		var args, err = readCommand(r)
+		if err != nil {
+			if pe, isProto := err.(protocolError); isProto {
+				writeValue(w, respError("ERR "+pe.Error()))
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		var name = strings.ToUpper(string(args[0]))
+		if name == "QUIT" {
+			writeValue(w, simpleString("OK"))
+			w.Flush()
+			return
+		}
+
+		writeValue(w, s.dispatch(tx, name, args))
+		if r.Buffered() == 0 {
+			if w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// multi is the MULTI state of a connection.
+type multi struct {
+	active bool
+	failed bool // a command failed to queue; EXEC will abort
+	queue  [][][]byte
+}
+
+// dispatch runs, or queues, the command name with its args and returns the
+// reply.
+func (s *Server) dispatch(tx *multi, name string, args [][]byte) interface{} {
+	switch name {
+	case "MULTI":
+		if tx.active {
+			return respError("ERR MULTI calls can not be nested")
+		}
+		*tx = multi{active: true}
+		return simpleString("OK")
+	case "EXEC":
+		if !tx.active {
+			return respError("ERR EXEC without MULTI")
+		}
+		var failed, queue = tx.failed, tx.queue
+		*tx = multi{}
+		if failed {
+			return respError("EXECABORT Transaction discarded because of " +
+				"previous errors.")
+		}
+		return s.exec(queue)
+	case "DISCARD":
+		if !tx.active {
+			return respError("ERR DISCARD without MULTI")
+		}
+		*tx = multi{}
+		return simpleString("OK")
+	}
+
+	var cmd, found = commands[name]
+	var reply respError
+	switch {
+	case !found:
+		reply = respError("ERR unknown command '" + string(args[0]) + "'")
+	case cmd.arity > 0 && len(args) != cmd.arity,
+		cmd.arity < 0 && len(args) < -cmd.arity:
+		reply = respError("ERR wrong number of arguments for '" +
+			strings.ToLower(name) + "' command")
+	}
+	if reply != "" {
+		tx.failed = tx.failed || tx.active
+		return reply
+	}
+
+	if tx.active {
+		tx.queue = append(tx.queue, args)
+		return simpleString("QUEUED")
+	}
+	return s.exec([][][]byte{args})[0]
+}
+
+// exec runs the commands, which are known and have the right number of args,
+// as one unit and returns their replies. If none of them writes they run on
+// the current root, otherwise on a HamtTransient made from it.
+func (s *Server) exec(cmds [][][]byte) []interface{} {
+	var replies = make([]interface{}, len(cmds))
+
+	var write bool
+	for _, args := range cmds {
+		write = write || commands[strings.ToUpper(string(args[0]))].write
+	}
+
+	var h hamt64.Hamt
+	if write {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		h = s.root.Load().ToTransient()
+	} else {
+		h = s.root.Load()
+	}
+
+	for i, args := range cmds {
+		var cmd = commands[strings.ToUpper(string(args[0]))]
+		replies[i] = cmd.run(h, args)
+	}
+
+	if write {
+		s.root.Store(h.(*hamt64.HamtTransient).Persistent())
+	}
+	return replies
+}
+
+// command describes a command other than MULTI, EXEC, DISCARD and QUIT. The
+// arity counts the command name; a negative arity -n means at least n. run
+// modifies h in place, so a command that does must be marked write.
+type command struct {
+	arity int
+	write bool
+	run   func(h hamt64.Hamt, args [][]byte) interface{}
+}
+
+var commands = map[string]command{
+	"PING":   {-1, false, cmdPing},
+	"GET":    {2, false, cmdGet},
+	"SET":    {3, true, cmdSet},
+	"DEL":    {-2, true, cmdDel},
+	"EXISTS": {-2, false, cmdExists},
+	"DBSIZE": {1, false, cmdDBSize},
+	"SCAN":   {-2, false, cmdScan},
+}
+
+func cmdPing(h hamt64.Hamt, args [][]byte) interface{} {
+	if len(args) > 1 {
+		return args[1]
+	}
+	return simpleString("PONG")
+}
+
+func cmdGet(h hamt64.Hamt, args [][]byte) interface{} {
+	if val, found := h.Get(hamt64.StringKey(args[1])); found {
+		return []byte(val.(string))
+	}
+	return nil
+}
+
+func cmdSet(h hamt64.Hamt, args [][]byte) interface{} {
+	h.Put(hamt64.StringKey(args[1]), string(args[2]))
+	return simpleString("OK")
+}
+
+func cmdDel(h hamt64.Hamt, args [][]byte) interface{} {
+	var n int64
+	for _, arg := range args[1:] {
+		if _, _, deleted := h.Del(hamt64.StringKey(arg)); deleted {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdExists(h hamt64.Hamt, args [][]byte) interface{} {
+	var n int64
+	for _, arg := range args[1:] {
+		if _, found := h.Get(hamt64.StringKey(arg)); found {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdDBSize(h hamt64.Hamt, args [][]byte) interface{} {
+	return int64(h.Nentries())
+}
+
+// defaultScanCount is the COUNT of a SCAN without one, as in Redis.
+const defaultScanCount = 10
+
+// cmdScan implements SCAN cursor [MATCH pattern] [COUNT count] with
+// hamt64.Scan. The cursor is a position in trie order, so it stays valid
+// across writes. MATCH uses the syntax of path.Match, and filters the keys
+// after they were scanned, so a call may return fewer than count keys, or
+// none, before the end.
+func cmdScan(h hamt64.Hamt, args [][]byte) interface{} {
+	var cursor, err = strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return respError("ERR invalid cursor")
+	}
+
+	var pattern string
+	var count = defaultScanCount
+	for opts := args[2:]; len(opts) > 0; opts = opts[2:] {
+		if len(opts) < 2 {
+			return respError("ERR syntax error")
+		}
+		switch strings.ToUpper(string(opts[0])) {
+		case "MATCH":
+			pattern = string(opts[1])
+			if _, err = path.Match(pattern, ""); err != nil {
+				return respError("ERR invalid pattern")
+			}
+		case "COUNT":
+			count, err = strconv.Atoi(string(opts[1]))
+			if err != nil || count < 1 {
+				return respError("ERR value is not an integer or out of range")
+			}
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+
+	var keys = make([]interface{}, 0, count)
+	cursor = hamt64.Scan(h, cursor, count, func(k hamt64.KeyI, _ interface{}) {
+		var key = string(k.(hamt64.StringKey))
+		if pattern != "" {
+			if matched, _ := path.Match(pattern, key); !matched {
+				return
+			}
+		}
+		keys = append(keys, []byte(key))
+	})
+
+	return []interface{}{[]byte(strconv.FormatUint(cursor, 10)), keys}
+}
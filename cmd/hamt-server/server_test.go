@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+// client is a minimal RESP2 client.
+type client struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// startServer serves a new Server on a local listener of the network, and
+// closes both at the end of the test.
+func startServer(t *testing.T, network string) (*Server, string) {
+	var addr = "127.0.0.1:0"
+	if network == "unix" {
+		addr = filepath.Join(t.TempDir(), "hamt.sock")
+	}
+	var ln, err = net.Listen(network, addr)
+	if err != nil {
+		t.Fatalf("failed net.Listen() => %s", err)
+	}
+
+	var s = NewServer(hamt64.HybridTables)
+	var done = make(chan error)
+	go func() { done <- s.Serve(ln) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() => %s", err)
+		}
+	})
+	return s, ln.Addr().String()
+}
+
+func dial(t *testing.T, network, addr string) *client {
+	var c, err = net.Dial(network, addr)
+	if err != nil {
+		t.Fatalf("failed net.Dial() => %s", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return &client{t, c, bufio.NewReader(c), bufio.NewWriter(c)}
+}
+
+// send writes a command without flushing it.
+func (cl *client) send(args ...string) {
+	var vals = make([]interface{}, len(args))
+	for i, arg := range args {
+		vals[i] = []byte(arg)
+	}
+	writeValue(cl.w, vals)
+}
+
+func (cl *client) recv() interface{} {
+	if err := cl.w.Flush(); err != nil {
+		cl.t.Fatalf("failed Flush() => %s", err)
+	}
+	var v, err = readValue(cl.r)
+	if err != nil {
+		cl.t.Fatalf("failed readValue() => %s", err)
+	}
+	return v
+}
+
+// do sends the command and returns its reply.
+func (cl *client) do(args ...string) interface{} {
+	cl.send(args...)
+	return cl.recv()
+}
+
+// expect sends the command and checks its reply.
+func (cl *client) expect(expected interface{}, args ...string) {
+	cl.t.Helper()
+	if v := cl.do(args...); !reflect.DeepEqual(v, expected) {
+		cl.t.Fatalf("%q => %#v; expected %#v", args, v, expected)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		var _, addr = startServer(t, network)
+		var cl = dial(t, network, addr)
+
+		cl.expect(simpleString("PONG"), "PING")
+		cl.expect(nil, "GET", "foo")
+		cl.expect(simpleString("OK"), "SET", "foo", "bar")
+		cl.expect([]byte("bar"), "get", "foo")
+		cl.expect(simpleString("OK"), "SET", "bin", "\x00\r\n\xff")
+		cl.expect([]byte("\x00\r\n\xff"), "GET", "bin")
+		cl.expect(int64(2), "EXISTS", "foo", "bin", "baz")
+		cl.expect(int64(2), "DBSIZE")
+		cl.expect(int64(1), "DEL", "foo", "baz")
+		cl.expect(int64(0), "EXISTS", "foo")
+		cl.expect(int64(1), "DBSIZE")
+
+		cl.expect(respError("ERR unknown command 'FOO'"), "FOO")
+		cl.expect(respError("ERR wrong number of arguments for 'get' command"),
+			"GET")
+		cl.expect(respError("ERR invalid cursor"), "SCAN", "x")
+		cl.expect(respError("ERR syntax error"), "SCAN", "0", "COUNT")
+
+		// inline commands, and pipelining
+		cl.w.WriteString("SET a 1\r\nGET a\r\n")
+		cl.send("PING", "x")
+		for _, expected := range []interface{}{
+			simpleString("OK"), []byte("1"), []byte("x"),
+		} {
+			if v := cl.recv(); !reflect.DeepEqual(v, expected) {
+				t.Fatalf("pipelined reply %#v; expected %#v", v, expected)
+			}
+		}
+
+		cl.expect(simpleString("OK"), "QUIT")
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	var _, addr = startServer(t, "tcp")
+	var cl = dial(t, "tcp", addr)
+
+	cl.w.WriteString("*1\r\n:1\r\n")
+	if v := cl.recv(); v != respError(
+		"ERR Protocol error: command is not an array of bulk strings") {
+		t.Fatalf("malformed command => %#v", v)
+	}
+	if _, err := cl.r.ReadByte(); err == nil {
+		t.Fatalf("connection open after a protocol error")
+	}
+}
+
+func TestMultiExec(t *testing.T) {
+	var s, addr = startServer(t, "tcp")
+	var cl = dial(t, "tcp", addr)
+
+	cl.expect(respError("ERR EXEC without MULTI"), "EXEC")
+	cl.expect(simpleString("OK"), "SET", "n", "0")
+
+	cl.expect(simpleString("OK"), "MULTI")
+	cl.expect(respError("ERR MULTI calls can not be nested"), "MULTI")
+	cl.expect(simpleString("QUEUED"), "SET", "a", "1")
+	cl.expect(simpleString("QUEUED"), "GET", "a")
+	cl.expect(simpleString("QUEUED"), "DEL", "n")
+	cl.expect(simpleString("QUEUED"), "DBSIZE")
+
+	// nothing is applied before EXEC
+	var before = s.Snapshot()
+	var other = dial(t, "tcp", addr)
+	other.expect(nil, "GET", "a")
+
+	cl.expect([]interface{}{simpleString("OK"), []byte("1"), int64(1),
+		int64(1)}, "EXEC")
+	other.expect([]byte("1"), "GET", "a")
+	if _, found := before.Get(hamt64.StringKey("n")); !found {
+		t.Fatalf("EXEC modified an old snapshot")
+	}
+
+	// an error while queueing aborts the transaction
+	cl.expect(simpleString("OK"), "MULTI")
+	cl.expect(simpleString("QUEUED"), "SET", "b", "1")
+	cl.expect(respError("ERR wrong number of arguments for 'set' command"),
+		"SET", "c")
+	cl.expect(respError("EXECABORT Transaction discarded because of "+
+		"previous errors."), "EXEC")
+	cl.expect(nil, "GET", "b")
+
+	cl.expect(simpleString("OK"), "MULTI")
+	cl.expect(simpleString("QUEUED"), "SET", "b", "1")
+	cl.expect(simpleString("OK"), "DISCARD")
+	cl.expect(nil, "GET", "b")
+}
+
+// TestMultiExecAtomic checks that concurrent readers never see part of a
+// transaction: every EXEC sets all the keys to the same value.
+func TestMultiExecAtomic(t *testing.T) {
+	var _, addr = startServer(t, "tcp")
+	var nkeys = 20
+
+	var writer = dial(t, "tcp", addr)
+	var wg sync.WaitGroup
+	var stop = make(chan struct{})
+	for r := 0; r < 4; r++ {
+		var reader = dial(t, "tcp", addr)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				reader.send("MULTI")
+				for i := 0; i < nkeys; i++ {
+					reader.send("GET", fmt.Sprintf("k%d", i))
+				}
+				reader.send("EXEC")
+				for i := 0; i < nkeys+1; i++ {
+					reader.recv()
+				}
+				var vals = reader.recv().([]interface{})
+				for _, v := range vals[1:] {
+					if !reflect.DeepEqual(v, vals[0]) {
+						t.Errorf("EXEC of GETs => %q", vals)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for n := 0; n < 100; n++ {
+		writer.send("MULTI")
+		for i := 0; i < nkeys; i++ {
+			writer.send("SET", fmt.Sprintf("k%d", i), fmt.Sprint(n))
+		}
+		writer.send("EXEC")
+		for i := 0; i < nkeys+2; i++ {
+			writer.recv()
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestScan(t *testing.T) {
+	var _, addr = startServer(t, "tcp")
+	var cl = dial(t, "tcp", addr)
+	var nkeys = 1000
+
+	for i := 0; i < nkeys; i++ {
+		cl.send("SET", fmt.Sprintf("key%d", i), "v")
+	}
+	for i := 0; i < nkeys; i++ {
+		cl.recv()
+	}
+
+	// scan while deleting the odd keys and adding new ones
+	var seen = make(map[string]int)
+	var cursor = "0"
+	for n := 0; ; n++ {
+		var v = cl.do("SCAN", cursor, "COUNT", "25").([]interface{})
+		for _, k := range v[1].([]interface{}) {
+			seen[string(k.([]byte))]++
+		}
+		cursor = string(v[0].([]byte))
+		if cursor == "0" {
+			break
+		}
+		cl.do("DEL", fmt.Sprintf("key%d", 2*n+1))
+		cl.do("SET", fmt.Sprintf("new%d", n), "v")
+	}
+	for i := 0; i < nkeys; i += 2 {
+		if n := seen[fmt.Sprintf("key%d", i)]; n != 1 {
+			t.Fatalf("SCAN returned key%d %d times", i, n)
+		}
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("SCAN returned %s %d times", k, n)
+		}
+	}
+
+	var v = cl.do("SCAN", "0", "MATCH", "key1?", "COUNT", "10000")
+	var keys = v.([]interface{})[1].([]interface{})
+	for _, k := range keys {
+		var key = string(k.([]byte))
+		if len(key) != 5 || key[:4] != "key1" {
+			t.Fatalf("SCAN MATCH key1? returned %s", key)
+		}
+	}
+	if len(keys) != 5 {
+		t.Fatalf("SCAN MATCH key1? returned %d keys; expected 5", len(keys))
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	var dir = t.TempDir()
+
+	if err := removeStaleSocket(filepath.Join(dir, "none")); err != nil {
+		t.Fatalf("removeStaleSocket() of a missing path => %s", err)
+	}
+
+	var sock = filepath.Join(dir, "hamt.sock")
+	var ln, err = net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	if err = removeStaleSocket(sock); err != nil {
+		t.Fatalf("removeStaleSocket() of a socket => %s", err)
+	}
+	if _, err = os.Lstat(sock); !os.IsNotExist(err) {
+		t.Fatalf("removeStaleSocket() left the socket; Lstat => %v", err)
+	}
+
+	var file = filepath.Join(dir, "data")
+	os.WriteFile(file, []byte("keep me"), 0644)
+	var link = filepath.Join(dir, "link")
+	os.Symlink(sock, link)
+	for _, path := range []string{file, link, dir} {
+		if err = removeStaleSocket(path); err == nil {
+			t.Fatalf("removeStaleSocket(%s) of a non-socket succeeded", path)
+		}
+		if _, err = os.Lstat(path); err != nil {
+			t.Fatalf("removeStaleSocket(%s) removed it", path)
+		}
+	}
+}
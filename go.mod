@@ -1,5 +1,5 @@
 module github.com/pstuifzand/go-hamt
 
-go 1.14
+go 1.23
 
 require github.com/pkg/errors v0.9.1
@@ -0,0 +1,143 @@
+package hamt32_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt32"
+)
+
+var numCondKvs = 10 * 1024
+
+func TestHamt32PutIfAbsent(t *testing.T) {
+	var name = "TestHamt32PutIfAbsent:" + hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numCondKvs]
+
+	var h, err = buildHamt32(name, kvs[:len(kvs)/2], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	for i, kv := range kvs {
+		var nh, cur, added = h.PutIfAbsent(kv.Key, -1)
+		if i < len(kvs)/2 {
+			if added || cur != kv.Val {
+				t.Fatalf("%s: PutIfAbsent(kvs[%d]) => %v, %t",
+					name, i, cur, added)
+			}
+			if nh != h {
+				t.Fatalf("%s: PutIfAbsent(kvs[%d]) returned a new Hamt",
+					name, i)
+			}
+		} else if !added || cur != nil {
+			t.Fatalf("%s: PutIfAbsent(kvs[%d]) => %v, %t",
+				name, i, cur, added)
+		}
+		h = nh
+	}
+
+	if h.Nentries() != uint(len(kvs)) {
+		t.Fatalf("%s: h.Nentries(),%d != %d", name, h.Nentries(), len(kvs))
+	}
+
+	for i, kv := range kvs {
+		var want = kv.Val
+		if i >= len(kvs)/2 {
+			want = -1
+		}
+		if val, _ := h.Get(kv.Key); val != want {
+			t.Fatalf("%s: h.Get(kvs[%d]) => %v; want %v", name, i, val, want)
+		}
+	}
+}
+
+func TestHamt32ReplaceIfPresent(t *testing.T) {
+	var name = "TestHamt32ReplaceIfPresent:" +
+		hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numCondKvs]
+
+	var h, err = buildHamt32(name, kvs[:len(kvs)/2], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	for i, kv := range kvs {
+		var nh, old, replaced = h.ReplaceIfPresent(kv.Key, -1)
+		if i < len(kvs)/2 {
+			if !replaced || old != kv.Val {
+				t.Fatalf("%s: ReplaceIfPresent(kvs[%d]) => %v, %t",
+					name, i, old, replaced)
+			}
+		} else {
+			if replaced || old != nil {
+				t.Fatalf("%s: ReplaceIfPresent(kvs[%d]) => %v, %t",
+					name, i, old, replaced)
+			}
+			if nh != h {
+				t.Fatalf("%s: ReplaceIfPresent(kvs[%d]) returned a new Hamt",
+					name, i)
+			}
+		}
+		h = nh
+	}
+
+	if h.Nentries() != uint(len(kvs)/2) {
+		t.Fatalf("%s: h.Nentries(),%d != %d", name, h.Nentries(), len(kvs)/2)
+	}
+
+	for i, kv := range kvs[:len(kvs)/2] {
+		if val, _ := h.Get(kv.Key); val != -1 {
+			t.Fatalf("%s: h.Get(kvs[%d]) => %v; want -1", name, i, val)
+		}
+	}
+}
+
+func TestHamt32CompareAndSwap(t *testing.T) {
+	var name = "TestHamt32CompareAndSwap:" +
+		hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numCondKvs]
+
+	var h, err = buildHamt32(name, kvs[:len(kvs)/2], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	// wrong old value and missing keys never swap
+	for i, kv := range kvs {
+		var nh, swapped = h.CompareAndSwap(kv.Key, -2, -1, nil)
+		if swapped || nh != h {
+			t.Fatalf("%s: CompareAndSwap(kvs[%d], -2, -1) swapped", name, i)
+		}
+	}
+
+	for i, kv := range kvs[:len(kvs)/2] {
+		var swapped bool
+		h, swapped = h.CompareAndSwap(kv.Key, kv.Val, -1, nil)
+		if !swapped {
+			t.Fatalf("%s: CompareAndSwap(kvs[%d]) not swapped", name, i)
+		}
+	}
+
+	// a custom equality function comparing only the sign
+	var sameSign = func(a, b interface{}) bool {
+		return (a.(int) < 0) == (b.(int) < 0)
+	}
+	for i, kv := range kvs[:len(kvs)/2] {
+		var swapped bool
+		h, swapped = h.CompareAndSwap(kv.Key, -5, kv.Val, sameSign)
+		if !swapped {
+			t.Fatalf("%s: CompareAndSwap(kvs[%d], sameSign) not swapped",
+				name, i)
+		}
+	}
+
+	if h.Nentries() != uint(len(kvs)/2) {
+		t.Fatalf("%s: h.Nentries(),%d != %d", name, h.Nentries(), len(kvs)/2)
+	}
+
+	for i, kv := range kvs[:len(kvs)/2] {
+		if val, _ := h.Get(kv.Key); val != kv.Val {
+			t.Fatalf("%s: h.Get(kvs[%d]) => %v; want %v",
+				name, i, val, kv.Val)
+		}
+	}
+}
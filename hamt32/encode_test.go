@@ -0,0 +1,232 @@
+package hamt32_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt32"
+)
+
+var numEncodeKvs = 20 * 1024
+
+func TestHamt32EncodeDecode(t *testing.T) {
+	var name = "TestHamt32EncodeDecode:" + hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numEncodeKvs]
+
+	var h, err = buildHamt32(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err = hamt32.Encode(&buf, h, nil); err != nil {
+		t.Fatalf("%s: failed Encode() => %s", name, err)
+	}
+
+	for _, functional := range []bool{true, false} {
+		var nh, err = hamt32.Decode(bytes.NewReader(buf.Bytes()), functional,
+			nil)
+		if err != nil {
+			t.Fatalf("%s: failed Decode() => %s", name, err)
+		}
+		if _, isFunctional := nh.(*hamt32.HamtFunctional); isFunctional !=
+			functional {
+			t.Fatalf("%s: Decode(functional=%t) returned a %T",
+				name, functional, nh)
+		}
+		if !hamt32.Equal(h, nh, nil) {
+			t.Fatalf("%s: !Equal(h, Decode(Encode(h)))", name)
+		}
+	}
+}
+
+func TestHamt32MarshalBinary(t *testing.T) {
+	var name = "TestHamt32MarshalBinary:" + hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numEncodeKvs]
+
+	var h, err = buildHamt32(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	var data []byte
+	var nh hamt32.Hamt
+	switch x := h.(type) {
+	case *hamt32.HamtFunctional:
+		data, err = x.MarshalBinary()
+		var y hamt32.HamtFunctional
+		if err == nil {
+			err = y.UnmarshalBinary(data)
+		}
+		nh = &y
+	case *hamt32.HamtTransient:
+		data, err = x.MarshalBinary()
+		var y hamt32.HamtTransient
+		if err == nil {
+			err = y.UnmarshalBinary(data)
+		}
+		nh = &y
+	}
+	if err != nil {
+		t.Fatalf("%s: failed MarshalBinary()/UnmarshalBinary() => %s",
+			name, err)
+	}
+
+	if !hamt32.Equal(h, nh, nil) {
+		t.Fatalf("%s: !Equal(h, UnmarshalBinary(MarshalBinary(h)))", name)
+	}
+
+	// the result is usable, not just comparable
+	nh, _ = nh.Put(kvs[0].Key, -1)
+	if val, found := nh.Get(kvs[0].Key); !found || val != -1 {
+		t.Fatalf("%s: Get() after Put() => %v, %t", name, val, found)
+	}
+}
+
+type point struct{ X, Y int }
+
+func TestHamt32EncodeTypes(t *testing.T) {
+	var name = "TestHamt32EncodeTypes:" + hamt32.TableOptionName[TableOption]
+
+	var reg = hamt32.NewRegistry()
+	err := reg.Register("point", point{},
+		func(v interface{}) ([]byte, error) {
+			var p = v.(point)
+			return []byte(fmt.Sprintf("%d,%d", p.X, p.Y)), nil
+		},
+		func(bs []byte) (interface{}, error) {
+			var p point
+			_, err := fmt.Sscanf(string(bs), "%d,%d", &p.X, &p.Y)
+			return p, err
+		})
+	if err != nil {
+		t.Fatalf("%s: failed Register() => %s", name, err)
+	}
+
+	var kvs = []hamt32.KeyVal{
+		{hamt32.StringKey("string"), "a string"},
+		{hamt32.StringKey("nil"), nil},
+		{hamt32.StringKey("bytes"), []byte{0, 1, 2}},
+		{hamt32.StringKey("true"), true},
+		{hamt32.StringKey("int"), -42},
+		{hamt32.StringKey("int8"), int8(-8)},
+		{hamt32.StringKey("uint16"), uint16(65535)},
+		{hamt32.StringKey("uint64"), uint64(1 << 63)},
+		{hamt32.StringKey("float32"), float32(1.5)},
+		{hamt32.StringKey("float64"), -0.25},
+		{hamt32.StringKey("point"), point{3, -4}},
+		{hamt32.ByteSliceKey("byteslice"), "key"},
+		{hamt32.Int32Key(-32), "key"},
+		{hamt32.Int64Key(-64), "key"},
+		{hamt32.Uint32Key(32), "key"},
+		{hamt32.Uint64Key(64), "key"},
+	}
+
+	var h = hamt32.New(Functional, TableOption)
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var buf bytes.Buffer
+	if err = hamt32.Encode(&buf, h, reg); err != nil {
+		t.Fatalf("%s: failed Encode() => %s", name, err)
+	}
+
+	nh, err := hamt32.Decode(&buf, Functional, reg)
+	if err != nil {
+		t.Fatalf("%s: failed Decode() => %s", name, err)
+	}
+	// []byte values are not comparable
+	if !hamt32.Equal(h, nh, func(a, b interface{}) bool {
+		return reflect.DeepEqual(a, b)
+	}) {
+		t.Fatalf("%s: !Equal(h, Decode(Encode(h)))", name)
+	}
+
+	// point is not in DefaultRegistry
+	if err = hamt32.Encode(&buf, h, nil); err == nil {
+		t.Fatalf("%s: Encode() of an unregistered type succeeded", name)
+	}
+}
+
+func TestHamt32DecodeErrors(t *testing.T) {
+	var name = "TestHamt32DecodeErrors:" + hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:1024]
+
+	var h, err = buildHamt32(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err = hamt32.Encode(&buf, h, nil); err != nil {
+		t.Fatalf("%s: failed Encode() => %s", name, err)
+	}
+	var data = buf.Bytes()
+
+	for _, n := range []int{0, 3, 7, len(data) / 2, len(data) - 1} {
+		_, err = hamt32.Decode(bytes.NewReader(data[:n]), Functional, nil)
+		if err == nil {
+			t.Fatalf("%s: Decode() of %d of %d bytes succeeded",
+				name, n, len(data))
+		}
+	}
+
+	var corrupt = func(i int, b byte) []byte {
+		var bs = append([]byte(nil), data...)
+		bs[i] = b
+		return bs
+	}
+	for _, bs := range [][]byte{
+		corrupt(0, 'X'),           // magic
+		corrupt(4, 99),            // version
+		corrupt(5, 16),            // hash size
+		corrupt(6, 3),             // table option
+		corrupt(len(data)-1, 'Q'), // nentries in the trailer
+	} {
+		_, err = hamt32.Decode(bytes.NewReader(bs), Functional, nil)
+		if err == nil {
+			t.Fatalf("%s: Decode() of corrupt data succeeded", name)
+		}
+	}
+
+	_, err = hamt32.Decode(bytes.NewReader(corruptName(data)), Functional, nil)
+	if err == nil {
+		t.Fatalf("%s: Decode() with an unknown codec name succeeded", name)
+	}
+}
+
+// corruptName renames the first codec of the encoding data to one that is
+// not registered.
+func corruptName(data []byte) []byte {
+	var bs = append([]byte(nil), data...)
+	var i = bytes.Index(bs, []byte("StringKey"))
+	bs[i] = 'X'
+	return bs
+}
+
+func TestHamt32Register(t *testing.T) {
+	var name = "TestHamt32Register"
+
+	var reg = hamt32.NewRegistry()
+	var enc = func(v interface{}) ([]byte, error) { return nil, nil }
+	var dec = func(bs []byte) (interface{}, error) { return point{}, nil }
+
+	if err := reg.Register("point", point{}, enc, dec); err != nil {
+		t.Fatalf("%s: failed Register() => %s", name, err)
+	}
+	if err := reg.Register("point", &point{}, enc, dec); err == nil {
+		t.Fatalf("%s: Register() of a duplicate name succeeded", name)
+	}
+	if err := reg.Register("point2", point{}, enc, dec); err == nil {
+		t.Fatalf("%s: Register() of a duplicate type succeeded", name)
+	}
+	if err := reg.Register("string2", "", enc, dec); err == nil {
+		t.Fatalf("%s: Register() of a built-in type succeeded", name)
+	}
+	if err := reg.Register("nil", nil, enc, dec); err == nil {
+		t.Fatalf("%s: Register() of nil succeeded", name)
+	}
+}
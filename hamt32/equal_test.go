@@ -0,0 +1,103 @@
+package hamt32_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt32"
+)
+
+var numEqualKvs = 20 * 1024
+
+func TestHamt32Equal(t *testing.T) {
+	var name = "TestHamt32Equal:" + hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numEqualKvs]
+
+	var a, err = buildHamt32(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	if !hamt32.Equal(a, a, nil) {
+		t.Fatalf("%s: !Equal(a, a)", name)
+	}
+
+	// the same contents with every other table option, so the layouts differ
+	for _, tblOpt := range []int{
+		hamt32.HybridTables, hamt32.FixedTables, hamt32.SparseTables,
+	} {
+		var b, err = buildHamt32(name, kvs, !Functional, tblOpt)
+		if err != nil {
+			t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+		}
+		if !hamt32.Equal(a, b, nil) || !hamt32.Equal(b, a, nil) {
+			t.Fatalf("%s: !Equal(a, b) for b %s",
+				name, hamt32.TableOptionName[tblOpt])
+		}
+	}
+
+	var b = a.DeepCopy()
+	b, _ = b.Put(kvs[0].Key, -1)
+	if hamt32.Equal(a, b, nil) {
+		t.Fatalf("%s: Equal(a, b) with a changed value", name)
+	}
+
+	var absEq = func(x, y interface{}) bool {
+		var ix, iy = x.(int), y.(int)
+		return ix == iy || ix == -iy || ix < 0 || iy < 0
+	}
+	if !hamt32.Equal(a, b, absEq) {
+		t.Fatalf("%s: !Equal(a, b, absEq)", name)
+	}
+
+	b, _, _ = b.Del(kvs[0].Key)
+	if hamt32.Equal(a, b, nil) {
+		t.Fatalf("%s: Equal(a, b) with a deleted key", name)
+	}
+
+	// same Nentries, different keys
+	b, _ = b.Put(KVS32[numEqualKvs].Key, kvs[0].Val)
+	if hamt32.Equal(a, b, nil) {
+		t.Fatalf("%s: Equal(a, b) with a replaced key", name)
+	}
+}
+
+func TestHamt32IsSubmapOf(t *testing.T) {
+	var name = "TestHamt32IsSubmapOf:" + hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numEqualKvs]
+
+	var a, err = buildHamt32(name, kvs[:len(kvs)/2], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	var tblOpt = (TableOption + 1) % 3
+	b, err := buildHamt32(name, kvs, !Functional, tblOpt)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	if !hamt32.IsSubmapOf(a, b, nil) {
+		t.Fatalf("%s: !IsSubmapOf(a, b)", name)
+	}
+	if hamt32.IsSubmapOf(b, a, nil) {
+		t.Fatalf("%s: IsSubmapOf(b, a)", name)
+	}
+	if !hamt32.IsSubmapOf(a, a, nil) {
+		t.Fatalf("%s: !IsSubmapOf(a, a)", name)
+	}
+
+	b, _ = b.Put(kvs[0].Key, -1)
+	if hamt32.IsSubmapOf(a, b, nil) {
+		t.Fatalf("%s: IsSubmapOf(a, b) with a changed value", name)
+	}
+
+	b, _, _ = b.Del(kvs[0].Key)
+	if hamt32.IsSubmapOf(a, b, nil) {
+		t.Fatalf("%s: IsSubmapOf(a, b) with a deleted key", name)
+	}
+
+	var e = hamt32.New(Functional, TableOption)
+	if !hamt32.IsSubmapOf(e, a, nil) {
+		t.Fatalf("%s: !IsSubmapOf(empty, a)", name)
+	}
+}
@@ -10,11 +10,13 @@ type fixedTable struct {
 	depth    uint
 	nents    uint
 	hashPath HashVal
+	edit     *editToken
 }
 
 func (t *fixedTable) copy() tableI {
 	var nt = new(fixedTable)
 	*nt = *t
+	nt.edit = nil
 	return nt
 }
 
@@ -223,3 +225,11 @@ func (t *fixedTable) iter() tableIterFunc {
 		return nil
 	}
 }
+
+func (t *fixedTable) owner() *editToken {
+	return t.edit
+}
+
+func (t *fixedTable) setOwner(edit *editToken) {
+	t.edit = edit
+}
@@ -1,6 +1,7 @@
 package hamt32
 
 import (
+	"iter"
 	"unsafe"
 )
 
@@ -91,9 +92,17 @@ type Hamt interface {
 	Get(KeyI) (interface{}, bool)
 	Put(KeyI, interface{}) (Hamt, bool)
 	Del(KeyI) (Hamt, interface{}, bool)
+	Update(KeyI, UpdateFunc) (Hamt, bool)
+	PutIfAbsent(KeyI, interface{}) (Hamt, interface{}, bool)
+	ReplaceIfPresent(KeyI, interface{}) (Hamt, interface{}, bool)
+	CompareAndSwap(KeyI, interface{}, interface{}, ValEqFunc) (Hamt, bool)
 	String() string
 	LongString(string) string
 	Range(func(KeyI, interface{}) bool)
+	Iter() *Iterator
+	All() iter.Seq2[KeyI, interface{}]
+	Keys() iter.Seq[KeyI]
+	Values() iter.Seq[interface{}]
 	Stats() *Stats
 	walk(visitFn) bool
 }
@@ -113,6 +122,19 @@ type KeyI interface {
 	Equals(KeyI) bool
 }
 
+// UpdateFunc is the callback used by Hamt.Update(). It is given the current
+// value of the key and whether the key exists. It returns the new value and
+// whether the key should be kept; returning false for keep deletes the key.
+type UpdateFunc func(
+	old interface{},
+	exists bool,
+) (newVal interface{}, keep bool)
+
+// ValEqFunc reports whether two values stored in a Hamt are equal. It is used
+// by Hamt.CompareAndSwap(). A nil ValEqFunc means the two values are equal if
+// they are of the same comparable type and ==.
+type ValEqFunc func(a, b interface{}) bool
+
 // New constructs a datastucture that implements the Hamt interface.
 //
 // When the functional argument is true it implements a HamtFunctional data
@@ -10,6 +10,7 @@ type hamtBase struct {
 	nentries   uint
 	nograde    bool
 	startFixed bool
+	edit       *editToken // only used by HamtTransient
 }
 
 func (h *hamtBase) init(tblOpt int) {
@@ -27,6 +28,18 @@ func (h *hamtBase) init(tblOpt int) {
 	}
 }
 
+// tableOption returns the table option, HybridTables, SparseTables, xor
+// FixedTables, that init() was called with.
+func (h *hamtBase) tableOption() int {
+	switch {
+	case h.startFixed:
+		return FixedTables
+	case h.nograde:
+		return SparseTables
+	}
+	return HybridTables
+}
+
 // IsEmpty simply returns if the HamtFunctional datastucture has no entries.
 func (h *hamtBase) IsEmpty() bool {
 	//return h.root == nil
@@ -204,6 +217,12 @@ func (h *hamtBase) Range(fn func(KeyI, interface{}) bool) {
 	h.walk(visitLeafs)
 }
 
+// Iter returns a new Iterator positioned before the first KeyVal pair of the
+// Hamt.
+func (h *hamtBase) Iter() *Iterator {
+	return newIterator(&h.root)
+}
+
 // Stats walks the Hamt in a pre-order traversal and populates a Stats data
 // struture which it returns.
 func (h *hamtBase) Stats() *Stats {
@@ -239,13 +258,11 @@ func (h *hamtBase) Stats() *Stats {
 			stats.Leafs++
 			stats.FlatLeafs++
 			stats.KeyVals += 1
-			keepOn = false
 		case *collisionLeaf:
 			stats.Nodes++
 			stats.Leafs++
 			stats.CollisionLeafs++
 			stats.KeyVals += uint(len(x.kvs))
-			keepOn = false
 		}
 		return keepOn
 	}
@@ -253,3 +270,14 @@ func (h *hamtBase) Stats() *Stats {
 	h.walk(statFn)
 	return stats
 }
+
+// hamtBaseOf returns the hamtBase underneath a Hamt interface value.
+func hamtBaseOf(h Hamt) *hamtBase {
+	switch x := h.(type) {
+	case *HamtFunctional:
+		return &x.hamtBase
+	case *HamtTransient:
+		return &x.hamtBase
+	}
+	panic("hamtBaseOf: unknown Hamt implementation")
+}
@@ -1,5 +1,9 @@
 package hamt32
 
+import (
+	"iter"
+)
+
 // HamtFunctional is the data structure which the Funcitonal Hamt methods are
 // called upon. In fact it is identical to the HamtTransient data structure and
 // all the table and leaf data structures it uses are the same ones used by the
@@ -44,14 +48,18 @@ func (h *HamtFunctional) ToFunctional() Hamt {
 	return h
 }
 
-// ToTransient just recasts the HamtFunctional pointer to a HamtTransient
-// underneath the Hamt interface.
+// ToTransient returns a HamtTransient with the contents of the
+// HamtFunctional, in constant time. The two share every table; the
+// HamtTransient copies a table the first time it modifies it, so modifying it
+// never changes the HamtFunctional. Call Persistent() on the HamtTransient to
+// get back a HamtFunctional once the modifications are done.
 //
-// If you want a copy of the HamtFunctional data structure over to a completely
-// independent HamtTransient data structure, you should first do a DeepCopy
-// followed by a ToTransient call.
+// Hence, the cost of a bulk modification done this way is proportional to the
+// modified part of the Hamt, not to its size.
 func (h *HamtFunctional) ToTransient() Hamt {
-	var nh = (*HamtTransient)(h)
+	var nh = new(HamtTransient)
+	nh.hamtBase = h.hamtBase
+	nh.edit = new(editToken)
 	return nh
 }
 
@@ -121,13 +129,25 @@ func (h *HamtFunctional) Get(key KeyI) (interface{}, bool) {
 func (h *HamtFunctional) Put(key KeyI, val interface{}) (Hamt, bool) {
 	// Doing this in newFlatLeaf() and leafI.put().
 
-	var nh = new(HamtFunctional)
-	*nh = *h
-
 	var hv = key.Hash()
 
 	var path, leaf, idx = h.find(hv)
 
+	return h.put(hv, key, val, path, leaf, idx)
+}
+
+// put does the work of Put once find() has located where the key belongs.
+func (h *HamtFunctional) put(
+	hv HashVal,
+	key KeyI,
+	val interface{},
+	path tableStack,
+	leaf leafI,
+	idx uint,
+) (Hamt, bool) {
+	var nh = new(HamtFunctional)
+	*nh = *h
+
 	var curTable = path.pop()
 	var depth = uint(path.len())
 
@@ -203,6 +223,16 @@ func (h *HamtFunctional) Del(key KeyI) (Hamt, interface{}, bool) {
 	var hv = key.Hash()
 	var path, leaf, idx = h.find(hv)
 
+	return h.del(key, path, leaf, idx)
+}
+
+// del does the work of Del once find() has located where the key belongs.
+func (h *HamtFunctional) del(
+	key KeyI,
+	path tableStack,
+	leaf leafI,
+	idx uint,
+) (Hamt, interface{}, bool) {
 	if leaf == nil {
 		return h, nil, false
 	}
@@ -253,6 +283,120 @@ func (h *HamtFunctional) Del(key KeyI) (Hamt, interface{}, bool) {
 	return nh, val, deleted
 }
 
+// Update looks up the key in the HamtFunctional data structure once and
+// calls fn with the current value and whether the key exists. If fn returns
+// keep == true the key is stored with the returned value, otherwise the key
+// is deleted. It returns a bool indicating if the HamtFunctional was modified.
+//
+// If fn asks for no change, either keeping an existing key with the same
+// value or not keeping a key that does not exist, the original HamtFunctional
+// data structure is returned. Otherwise a new HamtFunctional data structure
+// containing the modification is returned.
+func (h *HamtFunctional) Update(key KeyI, fn UpdateFunc) (Hamt, bool) {
+	var hv = key.Hash()
+	var path, leaf, idx = h.find(hv)
+
+	var old interface{}
+	var exists bool
+	if leaf != nil {
+		old, exists = leaf.get(key)
+	}
+
+	var val, keep = fn(old, exists)
+
+	switch {
+	case keep && !(exists && sameVal(old, val)):
+		var nh, _ = h.put(hv, key, val, path, leaf, idx)
+		return nh, true
+	case !keep && exists:
+		var nh, _, _ = h.del(key, path, leaf, idx)
+		return nh, true
+	}
+
+	return h, false
+}
+
+// PutIfAbsent stores the (key,value) pair in the HamtFunctional data structure
+// only if the key is not already in it. It returns the current value of the
+// key and a bool indicating if the pair was added (true). When the pair was
+// added the value returned is nil.
+//
+// If the key was added a new HamtFunctional data structure containing the
+// modification is returned, otherwise the original HamtFunctional is returned.
+func (h *HamtFunctional) PutIfAbsent(key KeyI, val interface{}) (
+	Hamt, interface{}, bool,
+) {
+	var hv = key.Hash()
+	var path, leaf, idx = h.find(hv)
+
+	if leaf != nil {
+		if cur, exists := leaf.get(key); exists {
+			return h, cur, false
+		}
+	}
+
+	var nh, _ = h.put(hv, key, val, path, leaf, idx)
+	return nh, nil, true
+}
+
+// ReplaceIfPresent stores the new value for the key in the HamtFunctional data
+// structure only if the key is already in it. It returns the previous value of
+// the key and a bool indicating if the value was replaced (true). When the key
+// was not found the value returned is nil.
+//
+// If the value was replaced a new HamtFunctional data structure containing the
+// modification is returned, otherwise the original HamtFunctional is returned.
+func (h *HamtFunctional) ReplaceIfPresent(key KeyI, val interface{}) (
+	Hamt, interface{}, bool,
+) {
+	var hv = key.Hash()
+	var path, leaf, idx = h.find(hv)
+
+	if leaf == nil {
+		return h, nil, false
+	}
+
+	var old, exists = leaf.get(key)
+	if !exists {
+		return h, nil, false
+	}
+
+	var nh, _ = h.put(hv, key, val, path, leaf, idx)
+	return nh, old, true
+}
+
+// CompareAndSwap stores newVal for the key in the HamtFunctional data structure
+// only if the key is in it and its current value is equal to oldVal according
+// to eq; if eq is nil see ValEqFunc. It returns a bool indicating if the value
+// was swapped.
+//
+// If the value was swapped a new HamtFunctional data structure containing the
+// modification is returned, otherwise the original HamtFunctional is returned.
+func (h *HamtFunctional) CompareAndSwap(
+	key KeyI,
+	oldVal, newVal interface{},
+	eq ValEqFunc,
+) (Hamt, bool) {
+	if eq == nil {
+		eq = sameVal
+	}
+
+	var hv = key.Hash()
+	var path, leaf, idx = h.find(hv)
+
+	if leaf == nil {
+		return h, false
+	}
+
+	var cur, exists = leaf.get(key)
+	if !exists || !eq(cur, oldVal) {
+		return h, false
+	}
+
+	var nh, _ = h.put(hv, key, newVal, path, leaf, idx)
+	return nh, true
+}
+
 // String returns a simple string representation of the HamtFunctional data
 // structure.
 func (h *HamtFunctional) String() string {
@@ -283,6 +427,29 @@ func (h *HamtFunctional) Range(fn func(KeyI, interface{}) bool) {
 	h.hamtBase.Range(fn)
 }
 
+// All returns an iter.Seq2 of every KeyVal pair in the HamtFunctional.
+// Breaking out of the range loop stops the traversal like returning false from
+// Range() does.
+func (h *HamtFunctional) All() iter.Seq2[KeyI, interface{}] {
+	return h.hamtBase.All()
+}
+
+// Keys returns an iter.Seq of every key in the HamtFunctional.
+func (h *HamtFunctional) Keys() iter.Seq[KeyI] {
+	return h.hamtBase.Keys()
+}
+
+// Values returns an iter.Seq of every value in the HamtFunctional.
+func (h *HamtFunctional) Values() iter.Seq[interface{}] {
+	return h.hamtBase.Values()
+}
+
+// Iter returns a new Iterator positioned before the first KeyVal pair of the
+// HamtFunctional. See Iterator for the rules on holding on to it.
+func (h *HamtFunctional) Iter() *Iterator {
+	return h.hamtBase.Iter()
+}
+
 // Stats walks the Hamt in a pre-order traversal and populates a Stats data
 // struture which it returns.
 func (h *HamtFunctional) Stats() *Stats {
@@ -0,0 +1,81 @@
+package hamt32
+
+// Iterator is a pull-style iterator over the KeyVal pairs of a Hamt. It is
+// returned by Hamt.Iter() and walks the fixed tables, sparse tables, and
+// collision leafs lazily; a table is only entered when Next() gets to it.
+//
+// Typical use:
+//
+//	var it = h.Iter()
+//	for it.Next() {
+//	    fmt.Println(it.Key(), it.Val())
+//	}
+//
+// An Iterator over a HamtFunctional is safe to hold while other goroutines
+// derive new versions from that HamtFunctional, because the tables it walks
+// are never modified. An Iterator over a HamtTransient is invalidated by any
+// Put or Del on that HamtTransient.
+//
+// KeyVal pairs are visited in the same seemingly random order as Range().
+type Iterator struct {
+	cur   tableIterFunc
+	stack tableIterStack
+	kvs   []KeyVal // remaining KeyVal pairs of the current collisionLeaf
+	kv    KeyVal
+}
+
+func newIterator(root tableI) *Iterator {
+	var it = new(Iterator)
+	it.cur = root.iter()
+	it.stack = newTableIterStack()
+	return it
+}
+
+// Next advances the Iterator to the next KeyVal pair. It returns false when
+// there are no more KeyVal pairs.
+func (it *Iterator) Next() bool {
+	if len(it.kvs) > 0 {
+		it.kv, it.kvs = it.kvs[0], it.kvs[1:]
+		return true
+	}
+
+	for it.cur != nil {
+		switch n := it.cur().(type) {
+		case nil:
+			// current table is exhausted; go back up to its parent
+			it.cur = it.stack.pop()
+		case tableI:
+			it.stack.push(it.cur)
+			it.cur = n.iter()
+		case *flatLeaf:
+			it.kv = KeyVal{n.key, n.val}
+			return true
+		case *collisionLeaf:
+			// collisionLeaf.kvs is never modified in place, so we can hold
+			// on to it without copying.
+			it.kv, it.kvs = n.kvs[0], n.kvs[1:]
+			return true
+		}
+	}
+
+	it.kv = KeyVal{}
+	return false
+}
+
+// Key returns the key of the current KeyVal pair. It is only valid after a
+// call to Next() returned true.
+func (it *Iterator) Key() KeyI {
+	return it.kv.Key
+}
+
+// Val returns the value of the current KeyVal pair. It is only valid after a
+// call to Next() returned true.
+func (it *Iterator) Val() interface{} {
+	return it.kv.Val
+}
+
+// KeyVal returns the current KeyVal pair. It is only valid after a call to
+// Next() returned true.
+func (it *Iterator) KeyVal() KeyVal {
+	return it.kv
+}
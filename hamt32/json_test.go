@@ -0,0 +1,190 @@
+package hamt32_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt32"
+)
+
+var numJSONKvs = 20 * 1024
+
+// jsonValEq compares values across a JSON roundtrip, where ints come back as
+// float64s.
+func jsonValEq(a, b interface{}) bool {
+	if i, isInt := a.(int); isInt {
+		a = float64(i)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestHamt32JSON(t *testing.T) {
+	var name = "TestHamt32JSON:" + hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numJSONKvs]
+
+	var h, err = buildHamt32(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err = hamt32.EncodeJSON(&buf, h, false); err != nil {
+		t.Fatalf("%s: failed EncodeJSON() => %s", name, err)
+	}
+
+	var m map[string]int
+	if err = json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("%s: EncodeJSON() wrote invalid JSON => %s", name, err)
+	}
+	if len(m) != len(kvs) {
+		t.Fatalf("%s: len(m) %d != len(kvs) %d", name, len(m), len(kvs))
+	}
+	for _, kv := range kvs {
+		var key = string(kv.Key.(hamt32.StringKey))
+		if val, found := m[key]; !found || val != kv.Val {
+			t.Fatalf("%s: m[%q] => %d, %t; expected %d",
+				name, key, val, found, kv.Val)
+		}
+	}
+
+	for _, functional := range []bool{true, false} {
+		var nh, err = hamt32.DecodeJSON(bytes.NewReader(buf.Bytes()),
+			functional)
+		if err != nil {
+			t.Fatalf("%s: failed DecodeJSON() => %s", name, err)
+		}
+		if _, isFunctional := nh.(*hamt32.HamtFunctional); isFunctional !=
+			functional {
+			t.Fatalf("%s: DecodeJSON(functional=%t) returned a %T",
+				name, functional, nh)
+		}
+		if !hamt32.Equal(h, nh, jsonValEq) {
+			t.Fatalf("%s: !Equal(h, DecodeJSON(EncodeJSON(h)))", name)
+		}
+	}
+}
+
+func TestHamt32JSONNested(t *testing.T) {
+	var name = "TestHamt32JSONNested:" + hamt32.TableOptionName[TableOption]
+
+	var inner = hamt32.New(Functional, TableOption)
+	inner, _ = inner.Put(hamt32.StringKey("x"), 1)
+	inner, _ = inner.Put(hamt32.StringKey("list"), []interface{}{"a", true})
+
+	var h = hamt32.New(Functional, TableOption)
+	h, _ = h.Put(hamt32.StringKey("inner"), inner)
+	h, _ = h.Put(hamt32.StringKey("nil"), nil)
+	h, _ = h.Put(hamt32.StringKey("s"), "<&>")
+
+	var doc = struct {
+		Name string
+		Hamt *hamt32.HamtFunctional
+	}{Name: "doc"}
+	switch x := h.(type) {
+	case *hamt32.HamtFunctional:
+		doc.Hamt = x
+	case *hamt32.HamtTransient:
+		doc.Hamt = x.Persistent()
+	}
+
+	var data, err = json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("%s: failed json.Marshal() => %s", name, err)
+	}
+
+	var m map[string]interface{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("%s: failed json.Unmarshal() into a map => %s", name, err)
+	}
+	var x = m["Hamt"].(map[string]interface{})["inner"].(map[string]interface{})
+	if x["x"] != float64(1) {
+		t.Fatalf("%s: nested Hamt was not encoded as an object: %s",
+			name, data)
+	}
+
+	var ndoc struct {
+		Name string
+		Hamt *hamt32.HamtFunctional
+	}
+	if err = json.Unmarshal(data, &ndoc); err != nil {
+		t.Fatalf("%s: failed json.Unmarshal() => %s", name, err)
+	}
+
+	var val, found = ndoc.Hamt.Get(hamt32.StringKey("inner"))
+	if !found {
+		t.Fatalf("%s: inner not found", name)
+	}
+	var ninner, isHamt = val.(*hamt32.HamtFunctional)
+	if !isHamt {
+		t.Fatalf("%s: inner decoded as a %T", name, val)
+	}
+	if !hamt32.Equal(inner, ninner, jsonValEq) {
+		t.Fatalf("%s: !Equal(inner, ninner)", name)
+	}
+	if val, _ = ndoc.Hamt.Get(hamt32.StringKey("s")); val != "<&>" {
+		t.Fatalf("%s: s => %v", name, val)
+	}
+	if val, found = ndoc.Hamt.Get(hamt32.StringKey("nil")); !found ||
+		val != nil {
+		t.Fatalf("%s: nil => %v, %t", name, val, found)
+	}
+}
+
+func TestHamt32JSONNonStringKeys(t *testing.T) {
+	var name = "TestHamt32JSONNonStringKeys:" +
+		hamt32.TableOptionName[TableOption]
+
+	var h = hamt32.New(Functional, TableOption)
+	h, _ = h.Put(hamt32.StringKey("a"), 1)
+	h, _ = h.Put(hamt32.Int64Key(2), "b")
+
+	var buf bytes.Buffer
+	var err = hamt32.EncodeJSON(&buf, h, false)
+	if err == nil || !strings.Contains(err.Error(), "not a StringKey") {
+		t.Fatalf("%s: EncodeJSON() of an Int64Key => %v", name, err)
+	}
+
+	buf.Reset()
+	if err = hamt32.EncodeJSON(&buf, h, true); err != nil {
+		t.Fatalf("%s: failed EncodeJSON(pairs) => %s", name, err)
+	}
+
+	var pairs [][2]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &pairs); err != nil {
+		t.Fatalf("%s: EncodeJSON(pairs) wrote %s => %s", name, buf.Bytes(), err)
+	}
+	var got = make(map[interface{}]interface{})
+	for _, p := range pairs {
+		got[p[0]] = p[1]
+	}
+	var expected = map[interface{}]interface{}{
+		"a":        float64(1),
+		float64(2): "b",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("%s: EncodeJSON(pairs) => %v; expected %v",
+			name, got, expected)
+	}
+}
+
+func TestHamt32DecodeJSONErrors(t *testing.T) {
+	var name = "TestHamt32DecodeJSONErrors:" +
+		hamt32.TableOptionName[TableOption]
+
+	for _, s := range []string{
+		``,
+		`[1, 2]`,
+		`"a"`,
+		`{"a": 1`,
+		`{"a": {"b": [1, 2}}`,
+		`{"a" 1}`,
+	} {
+		var _, err = hamt32.DecodeJSON(strings.NewReader(s), Functional)
+		if err == nil {
+			t.Fatalf("%s: DecodeJSON(%q) succeeded", name, s)
+		}
+	}
+}
@@ -41,6 +41,17 @@ type tableI interface {
 	remove(idx uint)
 
 	iter() tableIterFunc
+
+	owner() *editToken
+	setOwner(edit *editToken)
+}
+
+// editToken identifies the HamtTransient that owns a table. A HamtTransient
+// only modifies the tables stamped with its own editToken in-place; any other
+// table is copied, and the copy stamped, the first time it is modified. The
+// token is frozen by HamtTransient.Persistent().
+type editToken struct {
+	frozen bool
 }
 
 type tableEntry struct {
@@ -51,3 +62,17 @@ type tableEntry struct {
 func (ent tableEntry) String() string {
 	return fmt.Sprintf("tableEntry{idx:%d, node:%s}", ent.idx, ent.node.String())
 }
+
+// child returns the node found at idx when the node n is treated as a table at
+// the given depth. A leaf is treated as a table holding just that leaf.
+func child(n nodeI, idx, depth uint) nodeI {
+	switch x := n.(type) {
+	case tableI:
+		return x.get(idx)
+	case leafI:
+		if x.Hash().Index(depth) == idx {
+			return x
+		}
+	}
+	return nil
+}
@@ -9,12 +9,13 @@ import (
 // sparseTable.
 const sparseTableInitCap int = 2
 
-// New sparseTable layout size == 44
+// New sparseTable layout size == 52
 type sparseTable struct {
-	nodes    []nodeI // 24
-	depth    uint    // 8; amd64 cpu
-	hashPath HashVal // 8
-	nodeMap  bitmap  // 4
+	nodes    []nodeI    // 24
+	depth    uint       // 8; amd64 cpu
+	hashPath HashVal    // 8
+	edit     *editToken // 8
+	nodeMap  bitmap     // 4
 }
 
 func (t *sparseTable) copy() tableI {
@@ -103,6 +104,7 @@ func downgradeToSparseTable(
 ) *sparseTable {
 	var nt = new(sparseTable)
 	nt.hashPath = hashPath
+	nt.depth = depth
 	//nt.nodeMap = 0
 	nt.nodes = make([]nodeI, len(ents), len(ents)+1)
 
@@ -266,3 +268,11 @@ func (t *sparseTable) iter() tableIterFunc {
 		return nil
 	}
 }
+
+func (t *sparseTable) owner() *editToken {
+	return t.edit
+}
+
+func (t *sparseTable) setOwner(edit *editToken) {
+	t.edit = edit
+}
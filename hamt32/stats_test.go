@@ -0,0 +1,74 @@
+package hamt32_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt32"
+)
+
+var numStatsKvs = 2 * 1024
+
+// TestHamt32Stats checks that Stats visits every leaf, not just the first.
+func TestHamt32Stats(t *testing.T) {
+	var name = "TestHamt32Stats:" + hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numStatsKvs]
+
+	var h, err = buildHamt32(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	var stats = h.Stats()
+	if stats.KeyVals != uint(len(kvs)) {
+		t.Fatalf("%s: stats.KeyVals,%d != %d", name, stats.KeyVals, len(kvs))
+	}
+	if stats.Leafs != stats.FlatLeafs+stats.CollisionLeafs ||
+		stats.Leafs < uint(len(kvs))/2 {
+		t.Fatalf("%s: stats.Leafs,%d for %d keys", name, stats.Leafs, len(kvs))
+	}
+	if stats.TableCountsByDepth[0] != 1 {
+		t.Fatalf("%s: stats.TableCountsByDepth[0],%d != 1",
+			name, stats.TableCountsByDepth[0])
+	}
+}
+
+// TestHamt32Downgrade checks that a fixed table downgraded to a sparse table
+// by Del keeps its depth, so it is counted at that depth and upgraded back
+// correctly by later Puts. It uses a HamtTransient, whose Del downgrades the
+// tables of these keys.
+func TestHamt32Downgrade(t *testing.T) {
+	var name = "TestHamt32Downgrade:" +
+		hamt32.TableOptionName[hamt32.HybridTables]
+	var kvs = KVS32[:numStatsKvs]
+
+	var h, err = buildHamt32(name, kvs, false, hamt32.HybridTables)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+	var fixed = h.Stats().FixedTables
+
+	for _, kv := range kvs[:len(kvs)*7/8] {
+		h, _, _ = h.Del(kv.Key)
+	}
+	var stats = h.Stats()
+	if stats.FixedTables >= fixed {
+		t.Fatalf("%s: no fixed table was downgraded", name)
+	}
+	if stats.TableCountsByDepth[0] != 1 {
+		t.Fatalf("%s: stats.TableCountsByDepth[0],%d != 1 after Del",
+			name, stats.TableCountsByDepth[0])
+	}
+
+	for _, kv := range kvs[:len(kvs)*7/8] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range kvs {
+		if val, found := h.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("%s: h.Get(%q) => %v, %t; expected %v",
+				name, kv.Key, val, found, kv.Val)
+		}
+	}
+	if stats = h.Stats(); stats.KeyVals != uint(len(kvs)) {
+		t.Fatalf("%s: stats.KeyVals,%d != %d", name, stats.KeyVals, len(kvs))
+	}
+}
@@ -0,0 +1,175 @@
+package hamt32_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt32"
+)
+
+func checkHamt32(
+	t *testing.T,
+	name string,
+	h hamt32.Hamt,
+	expected map[hamt32.StringKey]interface{},
+) {
+	t.Helper()
+
+	if h.Nentries() != uint(len(expected)) {
+		t.Fatalf("%s: h.Nentries(),%d != %d", name, h.Nentries(), len(expected))
+	}
+	if stats := h.Stats(); stats.KeyVals != uint(len(expected)) {
+		t.Fatalf("%s: stats.KeyVals,%d != %d",
+			name, stats.KeyVals, len(expected))
+	}
+	for k, v := range expected {
+		if val, found := h.Get(k); !found || val != v {
+			t.Fatalf("%s: h.Get(%q) => %v, %t; expected %v",
+				name, k, val, found, v)
+		}
+	}
+	h.Range(func(k hamt32.KeyI, v interface{}) bool {
+		if ev, found := expected[k.(hamt32.StringKey)]; !found || ev != v {
+			t.Fatalf("%s: unexpected KeyVal {%q, %v}", name, k, v)
+		}
+		return true
+	})
+}
+
+var numEditKvs = 20 * 1024
+
+func TestHamt32ToTransientIsolated(t *testing.T) {
+	var name = "TestHamt32ToTransientIsolated:" +
+		hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numEditKvs]
+	var half = len(kvs) / 2
+
+	var h, err = buildHamt32(name, kvs[:half], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	var expected = make(map[hamt32.StringKey]interface{})
+	for _, kv := range kvs[:half] {
+		expected[kv.Key.(hamt32.StringKey)] = kv.Val
+	}
+
+	// replace, delete, and add a quarter of the keys each
+	var th = h.ToTransient()
+	var texpected = make(map[hamt32.StringKey]interface{})
+	for _, kv := range kvs[half/2 : half] {
+		texpected[kv.Key.(hamt32.StringKey)] = kv.Val
+	}
+	for _, kv := range kvs[half/2 : half/2+half/4] {
+		th.Put(kv.Key, -kv.Val.(int))
+		texpected[kv.Key.(hamt32.StringKey)] = -kv.Val.(int)
+	}
+	for _, kv := range kvs[:half/2] {
+		th.Del(kv.Key)
+	}
+	for _, kv := range kvs[half : half+half/2] {
+		th.Put(kv.Key, kv.Val)
+		texpected[kv.Key.(hamt32.StringKey)] = kv.Val
+	}
+
+	checkHamt32(t, name+" transient", th, texpected)
+	checkHamt32(t, name+" original", h, expected)
+
+	// a second transient of the same HamtFunctional sees none of it
+	var th2 = h.ToTransient()
+	checkHamt32(t, name+" second transient", th2, expected)
+	th2.Put(kvs[0].Key, "th2")
+	checkHamt32(t, name+" transient after th2.Put", th, texpected)
+	checkHamt32(t, name+" original after th2.Put", h, expected)
+}
+
+func TestHamt32Persistent(t *testing.T) {
+	var name = "TestHamt32Persistent:" + hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numEditKvs]
+	var half = len(kvs) / 2
+
+	var h, err = buildHamt32(name, kvs[:half], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	var th = h.ToTransient().(*hamt32.HamtTransient)
+	for _, kv := range kvs[half:] {
+		th.Put(kv.Key, kv.Val)
+	}
+
+	var ph = th.Persistent()
+
+	var expected = make(map[hamt32.StringKey]interface{})
+	for _, kv := range kvs {
+		expected[kv.Key.(hamt32.StringKey)] = kv.Val
+	}
+	checkHamt32(t, name+" persistent", ph, expected)
+
+	// Get and the like still work on the invalidated transient
+	checkHamt32(t, name+" invalidated transient", th, expected)
+
+	var panicked = func(fn func()) (p bool) {
+		defer func() {
+			p = recover() != nil
+		}()
+		fn()
+		return
+	}
+	if !panicked(func() { th.Put(kvs[0].Key, 0) }) {
+		t.Fatalf("%s: Put after Persistent() did not panic", name)
+	}
+	if !panicked(func() { th.Del(kvs[0].Key) }) {
+		t.Fatalf("%s: Del after Persistent() did not panic", name)
+	}
+
+	// the persistent result can be made transient again without affecting it
+	var th2 = ph.ToTransient()
+	for _, kv := range kvs[:half] {
+		th2.Del(kv.Key)
+	}
+	checkHamt32(t, name+" persistent after th2.Del", ph, expected)
+	if th2.Nentries() != uint(len(kvs)-half) {
+		t.Fatalf("%s: th2.Nentries(),%d != %d",
+			name, th2.Nentries(), len(kvs)-half)
+	}
+}
+
+func TestHamt32TransientToFunctional(t *testing.T) {
+	var name = "TestHamt32TransientToFunctional:" +
+		hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numEditKvs]
+	var half = len(kvs) / 2
+
+	var th, err = buildHamt32(name, kvs[:half], false, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+	var expected = make(map[hamt32.StringKey]interface{})
+	for _, kv := range kvs[:half] {
+		expected[kv.Key.(hamt32.StringKey)] = kv.Val
+	}
+
+	var h = th.ToFunctional()
+	if _, isFunctional := h.(*hamt32.HamtFunctional); !isFunctional {
+		t.Fatalf("%s: ToFunctional() => %T", name, h)
+	}
+
+	// the transient stays usable, and its writes do not reach h
+	var texpected = make(map[hamt32.StringKey]interface{})
+	for _, kv := range kvs[half/2:] {
+		texpected[kv.Key.(hamt32.StringKey)] = kv.Val
+	}
+	for _, kv := range kvs[:half/2] {
+		th.Del(kv.Key)
+	}
+	for _, kv := range kvs[half:] {
+		th.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range kvs[half/2 : half] {
+		th.Put(kv.Key, -kv.Val.(int))
+		texpected[kv.Key.(hamt32.StringKey)] = -kv.Val.(int)
+	}
+
+	checkHamt32(t, name+" transient", th, texpected)
+	checkHamt32(t, name+" functional", h, expected)
+}
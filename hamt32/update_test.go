@@ -0,0 +1,162 @@
+package hamt32_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt32"
+)
+
+var numUpdateKvs = 10 * 1024
+
+func TestHamt32Update(t *testing.T) {
+	var name = "TestHamt32Update:" + hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numUpdateKvs]
+
+	var h, err = buildHamt32(name, kvs[:len(kvs)/2], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	// increment existing values and insert the missing ones
+	for i, kv := range kvs {
+		var modified bool
+		h, modified = h.Update(kv.Key,
+			func(old interface{}, exists bool) (interface{}, bool) {
+				if exists != (i < len(kvs)/2) {
+					t.Fatalf("%s: exists,%t for kvs[%d]", name, exists, i)
+				}
+				if !exists {
+					return i, true
+				}
+				return old.(int) + 1, true
+			})
+		if !modified {
+			t.Fatalf("%s: Update(%s) not modified", name, kv.Key)
+		}
+	}
+
+	if h.Nentries() != uint(len(kvs)) {
+		t.Fatalf("%s: h.Nentries(),%d != %d", name, h.Nentries(), len(kvs))
+	}
+
+	for i, kv := range kvs {
+		var want = i
+		if i < len(kvs)/2 {
+			want = kv.Val.(int) + 1
+		}
+		var val, found = h.Get(kv.Key)
+		if !found || val != want {
+			t.Fatalf("%s: h.Get(%s) => %v, %t; want %d",
+				name, kv.Key, val, found, want)
+		}
+	}
+
+	// delete every other key
+	for i, kv := range kvs {
+		var modified bool
+		h, modified = h.Update(kv.Key,
+			func(old interface{}, exists bool) (interface{}, bool) {
+				return old, i%2 == 1
+			})
+		if modified != (i%2 == 0) {
+			t.Fatalf("%s: Update(%s) modified,%t", name, kv.Key, modified)
+		}
+	}
+
+	if h.Nentries() != uint(len(kvs)/2) {
+		t.Fatalf("%s: h.Nentries(),%d != %d", name, h.Nentries(), len(kvs)/2)
+	}
+
+	for i, kv := range kvs {
+		var _, found = h.Get(kv.Key)
+		if found != (i%2 == 1) {
+			t.Fatalf("%s: h.Get(%s) found,%t", name, kv.Key, found)
+		}
+	}
+}
+
+func TestHamt32UpdateNoChange(t *testing.T) {
+	var name = "TestHamt32UpdateNoChange:" +
+		hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numUpdateKvs]
+
+	var h, err = buildHamt32(name, kvs[:len(kvs)/2], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	for i, kv := range kvs {
+		var nh, modified = h.Update(kv.Key,
+			func(old interface{}, exists bool) (interface{}, bool) {
+				return old, exists
+			})
+		if modified {
+			t.Fatalf("%s: Update(kvs[%d]) modified", name, i)
+		}
+		if nh != h {
+			t.Fatalf("%s: Update(kvs[%d]) returned a different Hamt", name, i)
+		}
+	}
+
+	if h.Nentries() != uint(len(kvs)/2) {
+		t.Fatalf("%s: h.Nentries(),%d != %d", name, h.Nentries(), len(kvs)/2)
+	}
+}
+
+func TestHamt32UpdatePersistent(t *testing.T) {
+	var name = "TestHamt32UpdatePersistent:" +
+		hamt32.TableOptionName[TableOption]
+	var kvs = KVS32[:numUpdateKvs]
+
+	var h, err = buildHamt32(name, kvs, true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt32() => %s", name, err)
+	}
+
+	var nh = h
+	for _, kv := range kvs {
+		nh, _ = nh.Update(kv.Key,
+			func(old interface{}, exists bool) (interface{}, bool) {
+				return nil, false
+			})
+	}
+
+	if !nh.IsEmpty() {
+		t.Fatalf("%s: nh.Nentries(),%d != 0", name, nh.Nentries())
+	}
+
+	for _, kv := range kvs {
+		var val, found = h.Get(kv.Key)
+		if !found || val != kv.Val {
+			t.Fatalf("%s: original h.Get(%s) => %v, %t",
+				name, kv.Key, val, found)
+		}
+	}
+}
+
+// TestHamt32UpdateUncomparable checks that values of a comparable type
+// holding an uncomparable value, on which == panics, count as changed.
+func TestHamt32UpdateUncomparable(t *testing.T) {
+	var name = "TestHamt32UpdateUncomparable:" +
+		hamt32.TableOptionName[TableOption]
+	type box struct{ v interface{} }
+	var key = hamt32.StringKey("box")
+
+	var h = hamt32.New(Functional, TableOption)
+	h, _ = h.Put(key, box{[]int{1}})
+
+	var h2, modified = h.Update(key,
+		func(old interface{}, exists bool) (interface{}, bool) {
+			return box{[]int{1}}, true
+		})
+	if !modified {
+		t.Fatalf("%s: Update() with an uncomparable value not modified",
+			name)
+	}
+
+	var _, swapped = h2.CompareAndSwap(key, box{[]int{1}}, 0, nil)
+	if swapped {
+		t.Fatalf("%s: CompareAndSwap() of an uncomparable value swapped",
+			name)
+	}
+}
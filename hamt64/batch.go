@@ -0,0 +1,213 @@
+package hamt64
+
+import (
+	"sort"
+)
+
+// batchOp is one Put (del == false) or Del (del == true) of a PutMany or
+// DelMany call.
+type batchOp struct {
+	hv  HashVal
+	key KeyI
+	val interface{}
+	del bool
+}
+
+// batcher holds the state of applying a sorted []batchOp to a trie. Like
+// merger, the result shares every subtree the operations leave unchanged.
+type batcher struct {
+	nograde    bool
+	startFixed bool
+	added      uint
+	removed    uint
+}
+
+// PutMany stores every (key,value) pair of kvs in the HamtFunctional data
+// structure. The result is the same as calling Put for each pair in order,
+// so if a key is in kvs more than once the last value wins. It returns the
+// number of pairs that were added rather than replaced.
+//
+// PutMany groups the pairs by hash path and copies each table it touches only
+// once, instead of copying the whole path for every pair. The returned Hamt is
+// a single new HamtFunctional containing all the modifications; if kvs is
+// empty the original HamtFunctional is returned.
+func (h *HamtFunctional) PutMany(kvs []KeyVal) (Hamt, uint) {
+	var ops = make([]batchOp, len(kvs))
+	for i, kv := range kvs {
+		ops[i] = batchOp{hv: kv.Key.Hash(), key: kv.Key, val: kv.Val}
+	}
+
+	var nh, b = h.batch(ops)
+
+	return nh, b.added
+}
+
+// DelMany removes every key of keys from the HamtFunctional data structure.
+// It returns the number of keys that were found and removed.
+//
+// Like PutMany, DelMany copies each table it touches only once. If none of the
+// keys were found the original HamtFunctional is returned.
+func (h *HamtFunctional) DelMany(keys []KeyI) (Hamt, uint) {
+	if h.IsEmpty() {
+		return h, 0
+	}
+
+	var ops = make([]batchOp, len(keys))
+	for i, key := range keys {
+		ops[i] = batchOp{hv: key.Hash(), key: key, del: true}
+	}
+
+	var nh, b = h.batch(ops)
+
+	return nh, b.removed
+}
+
+func (h *HamtFunctional) batch(ops []batchOp) (Hamt, *batcher) {
+	var b = &batcher{
+		nograde:    h.nograde,
+		startFixed: h.startFixed,
+	}
+
+	if len(ops) == 0 {
+		return h, b
+	}
+
+	// Group the operations by hash path; the sort is stable so operations on
+	// the same key are still applied in the order given.
+	sort.SliceStable(ops, func(i, j int) bool {
+		return hashPathLess(ops[i].hv, ops[j].hv)
+	})
+
+	var root = b.applyTable(&h.root, 0, ops)
+
+	if root == nodeI(&h.root) {
+		return h, b
+	}
+
+	var nh = new(HamtFunctional)
+	nh.nograde = h.nograde
+	nh.startFixed = h.startFixed
+	nh.nentries = h.nentries + b.added - b.removed
+
+	// applyTable() always returns a fixedTable at depth 0
+	nh.root.set(root.(*fixedTable))
+
+	return nh, b
+}
+
+// hashPathLess orders two HashVals by their table indexes, starting at the
+// root. Sorting by it puts every HashVal that goes through the same table
+// next to each other.
+func hashPathLess(a, b HashVal) bool {
+	for depth := uint(0); depth <= maxDepth; depth++ {
+		var ia, ib = a.Index(depth), b.Index(depth)
+		if ia != ib {
+			return ia < ib
+		}
+	}
+	return false
+}
+
+// applyNode applies ops to the node n found where they belong at the given
+// depth. If n is a table, it is a table at that depth.
+func (b *batcher) applyNode(n nodeI, depth uint, ops []batchOp) nodeI {
+	// ops is sorted, so if the first and last HashVal are equal so are all
+	// the ones between.
+	var hv = ops[0].hv
+	var sameHash = ops[len(ops)-1].hv == hv
+
+	switch x := n.(type) {
+	case nil:
+		if sameHash {
+			return b.applyLeaf(nil, ops)
+		}
+	case leafI:
+		if sameHash && (x.Hash() == hv || depth > maxDepth) {
+			return b.applyLeaf(x, ops)
+		}
+	}
+
+	return b.applyTable(n, depth, ops)
+}
+
+// applyTable applies ops to n as a table at the given depth. n may be a leaf
+// or nil, see child().
+func (b *batcher) applyTable(n nodeI, depth uint, ops []batchOp) nodeI {
+	var ents = make([]tableEntry, 0, IndexLimit)
+	var same = true
+
+	var start int
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var c = child(n, idx, depth)
+
+		var end = start
+		for end < len(ops) && ops[end].hv.Index(depth) == idx {
+			end++
+		}
+
+		var nc = c
+		if end > start {
+			nc = b.applyNode(c, depth+1, ops[start:end])
+			start = end
+		}
+
+		same = same && nc == c
+
+		if nc != nil {
+			ents = append(ents, tableEntry{idx, nc})
+		}
+	}
+
+	if _, isTable := n.(tableI); isTable && same {
+		return n
+	}
+
+	if depth > 0 {
+		switch len(ents) {
+		case 0:
+			return nil
+		case 1:
+			// collapse a lone leaf into the parent table
+			if _, isLeaf := ents[0].node.(leafI); isLeaf {
+				return ents[0].node
+			}
+		}
+	}
+
+	return newTableFrom(n, depth, ents, b.nograde, b.startFixed)
+}
+
+// applyLeaf applies ops, which all have the same HashVal, to the leaf l in
+// order. l may be nil.
+func (b *batcher) applyLeaf(l leafI, ops []batchOp) nodeI {
+	for _, op := range ops {
+		if op.del {
+			if l == nil {
+				continue
+			}
+			var nl, _, deleted = l.del(op.key)
+			if deleted {
+				l = nl
+				b.removed++
+			}
+			continue
+		}
+
+		if l == nil {
+			l = newFlatLeaf(op.key, op.val)
+			b.added++
+			continue
+		}
+
+		var nl, added = l.put(op.key, op.val)
+		l = nl
+		if added {
+			b.added++
+		}
+	}
+
+	if l == nil {
+		return nil
+	}
+	return l
+}
@@ -0,0 +1,117 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numBatchKvs = 20 * 1024
+
+func TestHamt64PutMany(t *testing.T) {
+	var name = "TestHamt64PutMany:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numBatchKvs]
+	var half = len(kvs) / 2
+
+	var h, err = buildHamt64(name, kvs[:half], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var hf = h.(*hamt64.HamtFunctional)
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[:half] {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+
+	// replace the second quarter and add the third; the first pair is in the
+	// batch twice so the second value has to win.
+	var batch = []hamt64.KeyVal{{kvs[half/2].Key, "first"}}
+	for _, kv := range kvs[half/2 : half+half/2] {
+		batch = append(batch, hamt64.KeyVal{kv.Key, -kv.Val.(int)})
+	}
+
+	var nh, added = hf.PutMany(batch)
+	if added != uint(half/2) {
+		t.Fatalf("%s: added,%d != %d", name, added, half/2)
+	}
+
+	var nexpected = make(map[hamt64.StringKey]interface{})
+	for k, v := range expected {
+		nexpected[k] = v
+	}
+	for _, kv := range batch {
+		nexpected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+
+	checkHamt64(t, name+" PutMany", nh, nexpected)
+	checkHamt64(t, name+" original", h, expected)
+
+	// the result must keep working with single Put and Del calls
+	nh, _ = nh.Put(kvs[len(kvs)-1].Key, 1)
+	nh, _, _ = nh.Del(kvs[0].Key)
+	nexpected[kvs[len(kvs)-1].Key.(hamt64.StringKey)] = 1
+	delete(nexpected, kvs[0].Key.(hamt64.StringKey))
+	checkHamt64(t, name+" Put&Del", nh, nexpected)
+
+	if nh, added := hf.PutMany(nil); nh != h || added != 0 {
+		t.Fatalf("%s: PutMany(nil) => %p, %d", name, nh, added)
+	}
+}
+
+func TestHamt64DelMany(t *testing.T) {
+	var name = "TestHamt64DelMany:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numBatchKvs]
+	var half = len(kvs) / 2
+
+	var h, err = buildHamt64(name, kvs[:half], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var hf = h.(*hamt64.HamtFunctional)
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[:half] {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+
+	// the first quarter is deleted twice and the last half is not in h
+	var keys []hamt64.KeyI
+	for _, kv := range kvs[:half/4] {
+		keys = append(keys, kv.Key)
+	}
+	for _, kv := range kvs[:half/2] {
+		keys = append(keys, kv.Key)
+	}
+	for _, kv := range kvs[half:] {
+		keys = append(keys, kv.Key)
+	}
+
+	var nh, removed = hf.DelMany(keys)
+	if removed != uint(half/2) {
+		t.Fatalf("%s: removed,%d != %d", name, removed, half/2)
+	}
+
+	var nexpected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[half/2 : half] {
+		nexpected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+
+	checkHamt64(t, name+" DelMany", nh, nexpected)
+	checkHamt64(t, name+" original", h, expected)
+
+	var missing []hamt64.KeyI
+	for _, kv := range kvs[half:] {
+		missing = append(missing, kv.Key)
+	}
+	if nh, removed := hf.DelMany(missing); nh != h || removed != 0 {
+		t.Fatalf("%s: DelMany(missing) => %p, %d", name, nh, removed)
+	}
+
+	var all []hamt64.KeyI
+	for _, kv := range kvs[:half] {
+		all = append(all, kv.Key)
+	}
+	nh, _ = hf.DelMany(all)
+	checkHamt64(t, name+" DelMany(all)", nh, nil)
+}
@@ -0,0 +1,229 @@
+package hamt64
+
+import (
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+// buildEntry is one (key,value) pair given to Build, along with its HashVal
+// and its position in hash path order.
+type buildEntry struct {
+	path uint64
+	hv   HashVal
+	key  KeyI
+	val  interface{}
+}
+
+func newBuildEntry(key KeyI, val interface{}) buildEntry {
+	var hv = key.Hash()
+
+	// The table indexes of hv, root first, as the digits of one number. So
+	// ordering by path puts every HashVal that goes through the same table
+	// next to each other, in the order of the indexes of that table.
+	var path uint64
+	for depth := uint(0); depth <= maxDepth; depth++ {
+		path = path<<NumIndexBits | uint64(hv.Index(depth))
+	}
+
+	return buildEntry{path, hv, key, val}
+}
+
+// sortBuildEntries sorts ents by hash path. The sort is stable so repeated
+// keys stay in the order they were given.
+func sortBuildEntries(ents []buildEntry) {
+	slices.SortStableFunc(ents, func(a, b buildEntry) int {
+		switch {
+		case a.path < b.path:
+			return -1
+		case a.path > b.path:
+			return 1
+		}
+		return 0
+	})
+}
+
+// SortByHashPath sorts kvs into the order a Builder takes them in: by the
+// table indexes of the HashVals of the keys, starting at the root. The sort
+// is stable, so a key in kvs more than once keeps its values in order.
+func SortByHashPath(kvs []KeyVal) {
+	var ents = make([]buildEntry, len(kvs))
+	for i, kv := range kvs {
+		ents[i] = newBuildEntry(kv.Key, kv.Val)
+	}
+	sortBuildEntries(ents)
+	for i, ent := range ents {
+		kvs[i] = KeyVal{ent.key, ent.val}
+	}
+}
+
+// buildFrame is a table of a Builder that is still open: the entries before
+// its current index are final, the one at idx is still being built.
+type buildFrame struct {
+	idx  uint
+	ents []tableEntry
+}
+
+// Builder constructs a HamtFunctional from (key,value) pairs fed one at a
+// time by Add, in hash path order; see SortByHashPath.
+//
+// In that order every table is complete as soon as a key arrives that does
+// not go through it, so the Builder creates each table once, at its final
+// size, the moment it is complete. It only holds the tables on the path to
+// the last key added, at most one per level, beyond the finished part of the
+// Hamt. Finish completes the tables still open.
+type Builder struct {
+	nograde    bool
+	startFixed bool
+	tblOpt     int
+	open       []buildFrame // the open tables, root first
+	leaf       leafI        // the leaf of the last HashVal added, if any
+	hv         HashVal
+	nentries   uint
+	nadded     int
+}
+
+// NewBuilder constructs a new Builder for a HamtFunctional with the given
+// table option.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+func NewBuilder(tblOpt int) *Builder {
+	var b = new(Builder)
+	b.tblOpt = tblOpt
+	b.reset()
+	return b
+}
+
+func (b *Builder) reset() {
+	var hb hamtBase
+	hb.init(b.tblOpt)
+	b.nograde = hb.nograde
+	b.startFixed = hb.startFixed
+	b.open = nil
+	b.leaf = nil
+	b.nentries = 0
+	b.nadded = 0
+}
+
+// Add adds the (key,value) pair to the Builder. The HashVal of key must not
+// come before the one of the previous key in hash path order; if it does, Add
+// returns an error and the pair is not added. If the key is added more than
+// once, in a row as the order requires, the last value wins, just as with
+// Put.
+func (b *Builder) Add(key KeyI, val interface{}) error {
+	var hv = key.Hash()
+	if b.leaf != nil && hv != b.hv && !hashPathLess(b.hv, hv) {
+		return errors.Errorf("Add: key %s out of hash path order", key)
+	}
+	b.add(hv, key, val)
+	return nil
+}
+
+func (b *Builder) add(hv HashVal, key KeyI, val interface{}) {
+	b.nadded++
+
+	switch {
+	case b.leaf == nil:
+		b.open = append(b.open, buildFrame{idx: hv.Index(0)})
+		b.leaf = newFlatLeaf(key, val)
+		b.nentries++
+	case hv == b.hv:
+		var added bool
+		b.leaf, added = b.leaf.put(key, val)
+		if added {
+			b.nentries++
+		}
+	default:
+		// the first depth at which hv leaves the path of the last HashVal
+		var d uint
+		for b.hv.Index(d) == hv.Index(d) {
+			d++
+		}
+
+		// The last leaf goes in the table at depth d, so open the tables
+		// down to it. Every table below d is complete.
+		for uint(len(b.open)) <= d {
+			var depth = uint(len(b.open))
+			b.open = append(b.open, buildFrame{idx: b.hv.Index(depth)})
+		}
+		b.closeNode(b.leaf)
+		for uint(len(b.open)) > d+1 {
+			b.closeTable()
+		}
+
+		b.open[d].idx = hv.Index(d)
+		b.leaf = newFlatLeaf(key, val)
+		b.nentries++
+	}
+
+	b.hv = hv
+}
+
+// closeNode makes n the final entry at the current index of the innermost
+// open table.
+func (b *Builder) closeNode(n nodeI) {
+	var top = &b.open[len(b.open)-1]
+	top.ents = append(top.ents, tableEntry{top.idx, n})
+}
+
+// closeTable creates the innermost open table, which is complete, and makes
+// it the final entry of the table above it.
+func (b *Builder) closeTable() {
+	var depth = uint(len(b.open) - 1)
+	var t = newTableFrom(nil, depth, b.open[depth].ents, b.nograde,
+		b.startFixed)
+	b.open = b.open[:depth]
+	b.closeNode(t)
+}
+
+// Len returns the number of pairs added to the Builder, counting any repeated
+// key every time it was added.
+func (b *Builder) Len() int {
+	return b.nadded
+}
+
+// Finish builds the HamtFunctional from every pair added and resets the
+// Builder.
+func (b *Builder) Finish() *HamtFunctional {
+	var h = NewFunctional(b.tblOpt)
+
+	if b.leaf != nil {
+		b.closeNode(b.leaf)
+		for len(b.open) > 1 {
+			b.closeTable()
+		}
+		for _, ent := range b.open[0].ents {
+			h.root.insert(ent.idx, ent.node)
+		}
+		h.nentries = b.nentries
+	}
+
+	b.reset()
+	return h
+}
+
+// Build constructs a HamtFunctional holding every pair of kvs. The result is
+// the same as Putting every pair in order into an empty Hamt; if a key is in
+// kvs more than once the last value wins. The tables are the same kind they
+// would be after that sequence of Puts.
+//
+// Build sorts the pairs by hash path and feeds them to a Builder, which
+// creates each table once, at its final size. So there are no intermediate
+// copies, no table upgrades, and no repeated descents from the root.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+func Build(kvs []KeyVal, tblOpt int) *HamtFunctional {
+	var ents = make([]buildEntry, len(kvs))
+	for i, kv := range kvs {
+		ents[i] = newBuildEntry(kv.Key, kv.Val)
+	}
+	sortBuildEntries(ents)
+
+	var b = NewBuilder(tblOpt)
+	for _, ent := range ents {
+		b.add(ent.hv, ent.key, ent.val)
+	}
+	return b.Finish()
+}
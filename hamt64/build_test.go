@@ -0,0 +1,144 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numBuildKvs = 100 * 1024
+
+func TestHamt64Build(t *testing.T) {
+	var name = "TestHamt64Build:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numBuildKvs]
+
+	// the same keys again, with new values, for the first 100 keys
+	var input = append([]hamt64.KeyVal(nil), kvs...)
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	for _, kv := range kvs[:100] {
+		input = append(input, hamt64.KeyVal{kv.Key, -kv.Val.(int)})
+		expected[kv.Key.(hamt64.StringKey)] = -kv.Val.(int)
+	}
+
+	var h = hamt64.Build(input, TableOption)
+	checkHamt64(t, name, h, expected)
+
+	// The table layout must match the one of a Put loop.
+	var ph, err = buildHamt64(name, kvs, false, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	if *h.Stats() != *ph.Stats() {
+		t.Fatalf("%s: Build() and Put() layouts differ;\n%+v\n%+v",
+			name, h.Stats(), ph.Stats())
+	}
+
+	// the result must keep working with single Put and Del calls
+	var nh, _ = h.Put(KVS64[numBuildKvs].Key, 1)
+	nh, _, _ = nh.Del(kvs[0].Key)
+	expected[KVS64[numBuildKvs].Key.(hamt64.StringKey)] = 1
+	delete(expected, kvs[0].Key.(hamt64.StringKey))
+	checkHamt64(t, name+" Put&Del", nh, expected)
+
+	var eh = hamt64.Build(nil, TableOption)
+	checkHamt64(t, name+" Build(nil)", eh, nil)
+}
+
+func TestHamt64Builder(t *testing.T) {
+	var name = "TestHamt64Builder:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numBuildKvs]
+
+	// the first 100 keys twice, the second time with new values
+	var input = append([]hamt64.KeyVal(nil), kvs...)
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	for _, kv := range kvs[:100] {
+		input = append(input, hamt64.KeyVal{kv.Key, -kv.Val.(int)})
+		expected[kv.Key.(hamt64.StringKey)] = -kv.Val.(int)
+	}
+	hamt64.SortByHashPath(input)
+
+	var b = hamt64.NewBuilder(TableOption)
+	for _, kv := range input {
+		if err := b.Add(kv.Key, kv.Val); err != nil {
+			t.Fatalf("%s: failed b.Add(%s) => %s", name, kv.Key, err)
+		}
+	}
+	if b.Len() != len(input) {
+		t.Fatalf("%s: b.Len(),%d != %d", name, b.Len(), len(input))
+	}
+
+	var h = b.Finish()
+	checkHamt64(t, name, h, expected)
+
+	// The table layout must match the one of a Put loop.
+	var ph, err = buildHamt64(name, kvs, false, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	if *h.Stats() != *ph.Stats() {
+		t.Fatalf("%s: Builder and Put() layouts differ;\n%+v\n%+v",
+			name, h.Stats(), ph.Stats())
+	}
+
+	if b.Len() != 0 {
+		t.Fatalf("%s: b.Len(),%d != 0 after Finish()", name, b.Len())
+	}
+	checkHamt64(t, name+" empty", b.Finish(), nil)
+}
+
+func TestHamt64BuilderOrder(t *testing.T) {
+	var name = "TestHamt64BuilderOrder:" + hamt64.TableOptionName[TableOption]
+	var input = append([]hamt64.KeyVal(nil), KVS64[:1024]...)
+	hamt64.SortByHashPath(input)
+
+	var b = hamt64.NewBuilder(TableOption)
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range input[:512] {
+		b.Add(kv.Key, kv.Val)
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+
+	// every key before the last one added is rejected, and not added
+	for _, kv := range input[:511] {
+		if err := b.Add(kv.Key, 0); err == nil {
+			t.Fatalf("%s: b.Add(%s) out of order succeeded", name, kv.Key)
+		}
+	}
+	if b.Len() != 512 {
+		t.Fatalf("%s: b.Len(),%d != 512", name, b.Len())
+	}
+
+	// the Builder keeps working after an error
+	for _, kv := range input[512:] {
+		if err := b.Add(kv.Key, kv.Val); err != nil {
+			t.Fatalf("%s: failed b.Add(%s) => %s", name, kv.Key, err)
+		}
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	checkHamt64(t, name, b.Finish(), expected)
+}
+
+func BenchmarkHamt64Build(b *testing.B) {
+	var kvs = KVS64[:numBuildKvs]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hamt64.Build(kvs, TableOption)
+	}
+}
+
+func BenchmarkHamt64BuildPutLoop(b *testing.B) {
+	var kvs = KVS64[:numBuildKvs]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var h = hamt64.New(true, TableOption)
+		for _, kv := range kvs {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package hamt64
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// EncodeFunc encodes a key or value of the type a codec was registered for.
+type EncodeFunc func(v interface{}) ([]byte, error)
+
+// DecodeFunc decodes a key or value encoded by the matching EncodeFunc. The
+// bs slice is reused after the call returns, so it must not be retained.
+type DecodeFunc func(bs []byte) (interface{}, error)
+
+type codec struct {
+	name string
+	enc  EncodeFunc
+	dec  DecodeFunc
+}
+
+// Registry maps the Go types of keys and values to the codecs used by Encode
+// and Decode. Every codec has a name, which is what the binary format records,
+// so the same names must be registered, for the same types, on both sides.
+//
+// A Registry is safe to use from several goroutines.
+type Registry struct {
+	mu     sync.RWMutex
+	byType map[reflect.Type]*codec
+	byName map[string]*codec
+}
+
+// DefaultRegistry is the Registry used by MarshalBinary and UnmarshalBinary,
+// and by Encode and Decode when they are given a nil Registry. Register the
+// codecs of your own key and value types here to use them with those.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry constructs a Registry with the codecs for the key types of
+// this package and for nil, bool, string, []byte, and the Go integer and
+// float types as values.
+func NewRegistry() *Registry {
+	var r = new(Registry)
+	r.byType = make(map[reflect.Type]*codec)
+	r.byName = make(map[string]*codec)
+
+	r.mustRegister("StringKey", StringKey(""),
+		func(v interface{}) ([]byte, error) {
+			return []byte(v.(StringKey)), nil
+		},
+		func(bs []byte) (interface{}, error) {
+			return StringKey(bs), nil
+		})
+	r.mustRegister("ByteSliceKey", ByteSliceKey(nil),
+		func(v interface{}) ([]byte, error) {
+			return []byte(v.(ByteSliceKey)), nil
+		},
+		func(bs []byte) (interface{}, error) {
+			return ByteSliceKey(append([]byte(nil), bs...)), nil
+		})
+	r.mustRegisterInt("Int32Key", Int32Key(0))
+	r.mustRegisterInt("Int64Key", Int64Key(0))
+	r.mustRegisterInt("Uint32Key", Uint32Key(0))
+	r.mustRegisterInt("Uint64Key", Uint64Key(0))
+
+	r.mustRegister("string", "",
+		func(v interface{}) ([]byte, error) {
+			return []byte(v.(string)), nil
+		},
+		func(bs []byte) (interface{}, error) {
+			return string(bs), nil
+		})
+	r.mustRegister("[]byte", []byte(nil),
+		func(v interface{}) ([]byte, error) {
+			return v.([]byte), nil
+		},
+		func(bs []byte) (interface{}, error) {
+			return append([]byte(nil), bs...), nil
+		})
+	r.mustRegister("bool", false,
+		func(v interface{}) ([]byte, error) {
+			if v.(bool) {
+				return []byte{1}, nil
+			}
+			return []byte{0}, nil
+		},
+		func(bs []byte) (interface{}, error) {
+			if len(bs) != 1 || bs[0] > 1 {
+				return nil, errors.Errorf("bool: bad encoding %v", bs)
+			}
+			return bs[0] == 1, nil
+		})
+	for _, sample := range []interface{}{
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+	} {
+		r.mustRegisterInt(reflect.TypeOf(sample).Name(), sample)
+	}
+	r.mustRegister("float32", float32(0),
+		func(v interface{}) ([]byte, error) {
+			return binary.BigEndian.AppendUint32(nil,
+				math.Float32bits(v.(float32))), nil
+		},
+		func(bs []byte) (interface{}, error) {
+			if len(bs) != 4 {
+				return nil, errors.Errorf("float32: bad length %d", len(bs))
+			}
+			return math.Float32frombits(binary.BigEndian.Uint32(bs)), nil
+		})
+	r.mustRegister("float64", float64(0),
+		func(v interface{}) ([]byte, error) {
+			return binary.BigEndian.AppendUint64(nil,
+				math.Float64bits(v.(float64))), nil
+		},
+		func(bs []byte) (interface{}, error) {
+			if len(bs) != 8 {
+				return nil, errors.Errorf("float64: bad length %d", len(bs))
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(bs)), nil
+		})
+
+	return r
+}
+
+// Register adds a codec for the type of sample under the given name. It
+// returns an error if the name or the type already has a codec.
+func (r *Registry) Register(
+	name string,
+	sample interface{},
+	enc EncodeFunc,
+	dec DecodeFunc,
+) error {
+	if name == nilCodecName || sample == nil {
+		return errors.Errorf("Register: %q: nil has a built-in codec", name)
+	}
+
+	var typ = reflect.TypeOf(sample)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, found := r.byName[name]; found {
+		return errors.Errorf("Register: codec name %q already registered", name)
+	}
+	if c, found := r.byType[typ]; found {
+		return errors.Errorf("Register: type %s already registered as %q",
+			typ, c.name)
+	}
+
+	var c = &codec{name, enc, dec}
+	r.byName[name] = c
+	r.byType[typ] = c
+
+	return nil
+}
+
+func (r *Registry) mustRegister(
+	name string,
+	sample interface{},
+	enc EncodeFunc,
+	dec DecodeFunc,
+) {
+	if err := r.Register(name, sample, enc, dec); err != nil {
+		panic(err)
+	}
+}
+
+// mustRegisterInt registers a codec, encoding as a varint, for an integer
+// type, signed or unsigned, of any size.
+func (r *Registry) mustRegisterInt(name string, sample interface{}) {
+	var typ = reflect.TypeOf(sample)
+	var signed = typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Int64
+
+	r.mustRegister(name, sample,
+		func(v interface{}) ([]byte, error) {
+			var rv = reflect.ValueOf(v)
+			if signed {
+				return binary.AppendVarint(nil, rv.Int()), nil
+			}
+			return binary.AppendUvarint(nil, rv.Uint()), nil
+		},
+		func(bs []byte) (interface{}, error) {
+			var rv = reflect.New(typ).Elem()
+			var n int
+			if signed {
+				var i int64
+				i, n = binary.Varint(bs)
+				rv.SetInt(i)
+				if rv.Int() != i {
+					n = -1
+				}
+			} else {
+				var u uint64
+				u, n = binary.Uvarint(bs)
+				rv.SetUint(u)
+				if rv.Uint() != u {
+					n = -1
+				}
+			}
+			if n != len(bs) {
+				return nil, errors.Errorf("%s: bad encoding %v", name, bs)
+			}
+			return rv.Interface(), nil
+		})
+}
+
+// nilCodecName is the name of the built-in codec for nil values.
+const nilCodecName = "nil"
+
+var nilCodec = &codec{
+	name: nilCodecName,
+	enc: func(interface{}) ([]byte, error) {
+		return nil, nil
+	},
+	dec: func([]byte) (interface{}, error) {
+		return nil, nil
+	},
+}
+
+// lookupType returns the codec for the type of v.
+func (r *Registry) lookupType(v interface{}) (*codec, error) {
+	if v == nil {
+		return nilCodec, nil
+	}
+
+	var typ = reflect.TypeOf(v)
+
+	r.mu.RLock()
+	var c, found = r.byType[typ]
+	r.mu.RUnlock()
+
+	if !found {
+		return nil, errors.Errorf("no codec registered for type %s", typ)
+	}
+	return c, nil
+}
+
+// lookupName returns the codec registered under name.
+func (r *Registry) lookupName(name string) (*codec, error) {
+	if name == nilCodecName {
+		return nilCodec, nil
+	}
+
+	r.mu.RLock()
+	var c, found = r.byName[name]
+	r.mu.RUnlock()
+
+	if !found {
+		return nil, errors.Errorf("no codec registered with name %q", name)
+	}
+	return c, nil
+}
@@ -0,0 +1,289 @@
+package hamt64
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// The binary format written by WriteDelta is:
+//
+//	magic     "HDLT"
+//	version   byte; deltaVersion
+//	base      32 bytes; the Fingerprint of the base Hamt
+//	nbase     uvarint; the Nentries of the base Hamt
+//	nnext     uvarint; the Nentries of the next Hamt
+//	records   ...
+//
+// followed by a sequence of records, each starting with a tag byte:
+//
+//	'C' id:uvarint name:chunk             codec name for the id
+//	'P' kid:uvarint key:chunk vid:uvarint val:chunk
+//	'D' kid:uvarint key:chunk
+//	'Z' nrecords:uvarint                  end of the delta
+//
+// The 'C' records and chunks are those of Encode. A 'P' record puts a pair
+// that was added or changed, a 'D' record deletes a key that was removed.
+
+const deltaMagic = "HDLT"
+
+const deltaVersion = 1
+
+const (
+	deltaPutTag = 'P'
+	deltaDelTag = 'D'
+)
+
+// deltaDigester calculates the base Fingerprints of deltas, and the digests
+// compared by Sync. It is shared by every WriteDelta, ApplyDelta and Sync
+// call, so the table digests cached by one call are reused by the next.
+var deltaDigester = NewDigester(nil)
+
+// fingerprintOf returns the Fingerprint of any Hamt. The tables of a
+// HamtTransient that it still owns are hashed but their digests not cached.
+func fingerprintOf(h Hamt, d *Digester) Digest {
+	return d.digestTable(&hamtBaseOf(h).root, false).sum
+}
+
+// WriteDelta writes the changes that turn base into next to w. The keys and
+// values are encoded with the codecs of DefaultRegistry.
+//
+// The changes are found by Diff, which skips every table the two Hamts
+// share; when next was derived from base by HamtFunctional Put and Del calls,
+// or by a HamtTransient made with base.ToTransient(), the cost and the size of
+// the delta are proportional to the size of the change. The Fingerprint of
+// base is recorded in the delta so ApplyDelta can refuse any other base. It
+// is calculated by a Digester shared by every WriteDelta and ApplyDelta call,
+// so only the first delta written from a line of HamtFunctional versions
+// hashes the whole base.
+func WriteDelta(base, next Hamt, w io.Writer) error {
+	var e = encoder{
+		op:  "WriteDelta",
+		w:   bufio.NewWriter(w),
+		reg: DefaultRegistry,
+		ids: make(map[*codec]uint64),
+	}
+
+	var fp = fingerprintOf(base, deltaDigester)
+	e.buf = append(e.buf[:0], deltaMagic...)
+	e.buf = append(e.buf, deltaVersion)
+	e.buf = append(e.buf, fp[:]...)
+	e.buf = binary.AppendUvarint(e.buf, uint64(base.Nentries()))
+	e.buf = binary.AppendUvarint(e.buf, uint64(next.Nentries()))
+	e.write(e.buf)
+
+	var nrecs uint64
+	Diff(base, next, func(c Change) bool {
+		if c.Kind == Removed {
+			e.writeDelete(c.Key)
+		} else {
+			e.writeEntry(deltaPutTag, c.Key, c.NewVal)
+		}
+		nrecs++
+		return e.err == nil
+	})
+
+	e.buf = append(e.buf[:0], endTag)
+	e.buf = binary.AppendUvarint(e.buf, nrecs)
+	e.write(e.buf)
+
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// writeDelete writes a 'D' record for the key k.
+func (e *encoder) writeDelete(k KeyI) {
+	var kc, kid = e.codecID(k)
+	if e.err != nil {
+		return
+	}
+
+	var kbs, err = kc.enc(k)
+	if err != nil {
+		e.err = errors.Wrapf(err, "%s: key %v", e.op, k)
+		return
+	}
+
+	e.buf = append(e.buf[:0], deltaDelTag)
+	e.buf = binary.AppendUvarint(e.buf, kid)
+	e.buf = appendChunk(e.buf, kbs)
+	e.write(e.buf)
+}
+
+// deltaOp is one record of a delta; val is nil for a delete.
+type deltaOp struct {
+	key KeyI
+	val interface{}
+	del bool
+}
+
+// ApplyDelta reads a delta written by WriteDelta from r and applies it to
+// base. The keys and values are decoded with the codecs of DefaultRegistry.
+//
+// The Fingerprint of base must be the one recorded in the delta, otherwise
+// ApplyDelta fails without changing base. The whole delta is read and checked
+// before it is applied.
+//
+// A HamtFunctional base is not modified; the HamtFunctional returned shares
+// every table the delta did not touch with it. A HamtTransient base is
+// modified in place and returned, like its Put and Del do.
+func ApplyDelta(base Hamt, r io.Reader) (Hamt, error) {
+	var d = decoder{reg: DefaultRegistry}
+	if br, ok := r.(byteReader); ok {
+		d.r = br
+	} else {
+		d.r = bufio.NewReader(r)
+	}
+
+	var hdr [len(deltaMagic) + 1 + len(Digest{})]byte
+	if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+		return nil, errors.Wrap(err, "ApplyDelta: failed to read header")
+	}
+	if string(hdr[:len(deltaMagic)]) != deltaMagic {
+		return nil, errors.Errorf("ApplyDelta: bad magic %q",
+			hdr[:len(deltaMagic)])
+	}
+	if version := hdr[len(deltaMagic)]; version != deltaVersion {
+		return nil, errors.Errorf("ApplyDelta: unsupported version %d",
+			version)
+	}
+	var bfp Digest
+	copy(bfp[:], hdr[len(deltaMagic)+1:])
+
+	var nbase, err = binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, errors.Wrap(noEOF(err), "ApplyDelta: bad header")
+	}
+	nnext, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, errors.Wrap(noEOF(err), "ApplyDelta: bad header")
+	}
+
+	// Compare the cheap Nentries before hashing the base.
+	if nbase != uint64(base.Nentries()) {
+		return nil, errors.Errorf(
+			"ApplyDelta: base has %d entries; delta is for %d",
+			base.Nentries(), nbase)
+	}
+	if fp := fingerprintOf(base, deltaDigester); fp != bfp {
+		return nil, errors.Errorf(
+			"ApplyDelta: base Fingerprint %s; delta is for %s", fp, bfp)
+	}
+
+	var ops []deltaOp
+	for done := false; !done; {
+		var tag, err = d.r.ReadByte()
+		if err != nil {
+			return nil, errors.Wrap(noEOF(err),
+				"ApplyDelta: failed to read tag")
+		}
+
+		switch tag {
+		case codecTag:
+			err = d.readCodec()
+		case deltaPutTag, deltaDelTag:
+			var op deltaOp
+			op, err = d.readDeltaOp(tag == deltaDelTag)
+			ops = append(ops, op)
+		case endTag:
+			var n uint64
+			n, err = binary.ReadUvarint(d.r)
+			if err != nil {
+				err = errors.Wrap(noEOF(err), "bad end record")
+			} else if n != uint64(len(ops)) {
+				err = errors.Errorf("read %d records; trailer says %d",
+					len(ops), n)
+			}
+			done = true
+		default:
+			err = errors.Errorf("unknown record tag %q", tag)
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "ApplyDelta")
+		}
+	}
+
+	if n := nentriesAfter(base, ops); uint64(n) != nnext {
+		return nil, errors.Errorf(
+			"ApplyDelta: result would have %d entries; delta says %d",
+			n, nnext)
+	}
+	return applyDeltaOps(base, ops), nil
+}
+
+// nentriesAfter returns the Nentries h would have after the ops were applied,
+// without changing h.
+func nentriesAfter(h Hamt, ops []deltaOp) uint {
+	var n = h.Nentries()
+
+	// whether each key seen so far is present after the ops up to now
+	var present = NewTransient(HybridTables)
+	for _, op := range ops {
+		var was bool
+		if v, seen := present.Get(op.key); seen {
+			was = v.(bool)
+		} else {
+			_, was = h.Get(op.key)
+		}
+		switch {
+		case op.del && was:
+			n--
+		case !op.del && !was:
+			n++
+		}
+		present.Put(op.key, !op.del)
+	}
+
+	return n
+}
+
+// applyDeltaOps applies the ops to h in order. A HamtFunctional h is not
+// modified, the ops are applied to a HamtTransient made from it, which is
+// returned as a HamtFunctional again; any other h is modified in place.
+func applyDeltaOps(h Hamt, ops []deltaOp) Hamt {
+	var hf, functional = h.(*HamtFunctional)
+	if functional {
+		h = hf.ToTransient()
+	}
+	for _, op := range ops {
+		if op.del {
+			h, _, _ = h.Del(op.key)
+		} else {
+			h, _ = h.Put(op.key, op.val)
+		}
+	}
+
+	if functional {
+		return h.(*HamtTransient).Persistent()
+	}
+	return h
+}
+
+// readDeltaOp reads the rest of a 'P' record, or of a 'D' record if del is
+// true.
+func (d *decoder) readDeltaOp(del bool) (deltaOp, error) {
+	var kv, err = d.readValue()
+	if err != nil {
+		return deltaOp{}, err
+	}
+	var key, isKey = kv.(KeyI)
+	if !isKey {
+		return deltaOp{}, errors.Errorf(
+			"decoded key of type %T is not a KeyI", kv)
+	}
+
+	if del {
+		return deltaOp{key: key, del: true}, nil
+	}
+
+	val, err := d.readValue()
+	if err != nil {
+		return deltaOp{}, err
+	}
+	return deltaOp{key: key, val: val}, nil
+}
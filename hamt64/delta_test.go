@@ -0,0 +1,163 @@
+package hamt64_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numDeltaKvs = 20 * 1024
+
+func TestHamt64Delta(t *testing.T) {
+	var name = "TestHamt64Delta:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numDeltaKvs]
+	var half = len(kvs) / 2
+
+	var base, err = buildHamt64(name, kvs[:half], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var full bytes.Buffer
+	if err = hamt64.Encode(&full, base, nil); err != nil {
+		t.Fatalf("%s: failed Encode() => %s", name, err)
+	}
+
+	// the next version adds, changes and removes a few keys
+	var next = base.ToFunctional()
+	if !Functional {
+		next = base.DeepCopy().ToFunctional()
+	}
+	for _, kv := range kvs[half : half+10] {
+		next, _ = next.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range kvs[10:20] {
+		next, _ = next.Put(kv.Key, -1)
+	}
+	for _, kv := range kvs[:10] {
+		next, _, _ = next.Del(kv.Key)
+	}
+
+	var delta bytes.Buffer
+	if err = hamt64.WriteDelta(base, next, &delta); err != nil {
+		t.Fatalf("%s: failed WriteDelta() => %s", name, err)
+	}
+	if delta.Len()*100 > full.Len() {
+		t.Fatalf("%s: delta of 30 changes is %d bytes; full Encode() %d",
+			name, delta.Len(), full.Len())
+	}
+
+	var data = delta.Bytes()
+
+	// a truncated delta is not applied
+	_, err = hamt64.ApplyDelta(base, bytes.NewReader(data[:len(data)-2]))
+	if err == nil {
+		t.Fatalf("%s: ApplyDelta() of a truncated delta succeeded", name)
+	}
+	if base.Nentries() != uint(half) {
+		t.Fatalf("%s: ApplyDelta() of a truncated delta modified base", name)
+	}
+
+	applied, err := hamt64.ApplyDelta(base, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s: failed ApplyDelta() => %s", name, err)
+	}
+	if !hamt64.Equal(next, applied, nil) {
+		t.Fatalf("%s: !Equal(next, ApplyDelta(base, delta))", name)
+	}
+	if Functional {
+		if _, isFunctional := applied.(*hamt64.HamtFunctional); !isFunctional {
+			t.Fatalf("%s: ApplyDelta() to a HamtFunctional => %T",
+				name, applied)
+		}
+		if base.Nentries() != uint(half) {
+			t.Fatalf("%s: ApplyDelta() modified the HamtFunctional base",
+				name)
+		}
+	}
+
+	// the delta does not apply to anything but base
+	for _, x := range []struct {
+		desc string
+		h    hamt64.Hamt
+	}{
+		{"next", applied},
+		{"base with one value changed",
+			hamt64.Build(kvs[:half], TableOption).ToFunctional()},
+	} {
+		if x.desc != "next" {
+			x.h, _ = x.h.Put(kvs[half-1].Key, -1)
+		}
+		_, err = hamt64.ApplyDelta(x.h, bytes.NewReader(data))
+		if err == nil {
+			t.Fatalf("%s: ApplyDelta() to %s succeeded", name, x.desc)
+		}
+	}
+
+	// an empty delta
+	delta.Reset()
+	if err = hamt64.WriteDelta(next, next, &delta); err != nil {
+		t.Fatalf("%s: failed WriteDelta() => %s", name, err)
+	}
+	same, err := hamt64.ApplyDelta(next, &delta)
+	if err != nil {
+		t.Fatalf("%s: failed ApplyDelta() of an empty delta => %s", name, err)
+	}
+	if !hamt64.Equal(next, same, nil) {
+		t.Fatalf("%s: !Equal(next, ApplyDelta(next, empty delta))", name)
+	}
+}
+
+// TestHamt64DeltaTransientRejected checks that a delta that fails its checks
+// leaves a HamtTransient base unchanged, even though a valid delta is applied
+// to one in place.
+func TestHamt64DeltaTransientRejected(t *testing.T) {
+	var name = "TestHamt64DeltaTransientRejected:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:1024]
+
+	var base, err = buildHamt64(name, kvs[:512], false, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var orig = base.DeepCopy()
+
+	var next = base.DeepCopy()
+	for _, kv := range kvs[512:] {
+		next.Put(kv.Key, kv.Val)
+	}
+	next.Del(kvs[0].Key)
+
+	var delta bytes.Buffer
+	if err = hamt64.WriteDelta(base, next, &delta); err != nil {
+		t.Fatalf("%s: failed WriteDelta() => %s", name, err)
+	}
+
+	// rewrite the nnext count of the header: magic, version, Fingerprint,
+	// nbase, nnext
+	var data = delta.Bytes()
+	var off = 4 + 1 + 32
+	var _, nlen = binary.Uvarint(data[off:])
+	off += nlen
+	var nnext, nnlen = binary.Uvarint(data[off:])
+	var bad = append([]byte{}, data[:off]...)
+	bad = binary.AppendUvarint(bad, nnext+1)
+	bad = append(bad, data[off+nnlen:]...)
+
+	if _, err = hamt64.ApplyDelta(base, bytes.NewReader(bad)); err == nil {
+		t.Fatalf("%s: ApplyDelta() with a wrong count succeeded", name)
+	}
+	if !hamt64.Equal(base, orig, nil) {
+		t.Fatalf("%s: rejected ApplyDelta() modified the transient base",
+			name)
+	}
+
+	applied, err := hamt64.ApplyDelta(base, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s: failed ApplyDelta() => %s", name, err)
+	}
+	if applied != base || !hamt64.Equal(base, next, nil) {
+		t.Fatalf("%s: ApplyDelta() did not modify the transient base", name)
+	}
+}
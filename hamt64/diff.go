@@ -0,0 +1,147 @@
+package hamt64
+
+import (
+	"fmt"
+	"iter"
+)
+
+// ChangeKind is the kind of difference a Change describes.
+type ChangeKind int
+
+const (
+	// Added means the key is only in the new Hamt.
+	Added ChangeKind = iota
+	// Removed means the key is only in the old Hamt.
+	Removed
+	// Changed means the key is in both Hamts with different values.
+	Changed
+)
+
+// ChangeKindName is a lookup table to map the Added, Removed, and Changed
+// constants to a string.
+var ChangeKindName = [3]string{
+	Added:   "Added",
+	Removed: "Removed",
+	Changed: "Changed",
+}
+
+// Change describes one difference between two Hamts found by Diff. OldVal is
+// nil for Added changes and NewVal is nil for Removed changes.
+type Change struct {
+	Kind   ChangeKind
+	Key    KeyI
+	OldVal interface{}
+	NewVal interface{}
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("Change{%s, %q, %v, %v}",
+		ChangeKindName[c.Kind], c.Key, c.OldVal, c.NewVal)
+}
+
+// Diff calls fn for every key that was added, removed, or changed between the
+// oldHamt and newHamt. The traversal stops if fn returns false.
+//
+// Diff walks both tries in parallel and skips every table and leaf the two
+// Hamts share. When newHamt was derived from oldHamt (or vice versa) by
+// HamtFunctional Put and Del calls, only the paths those calls copied are
+// visited, so the cost is proportional to the size of the change, not the size
+// of the Hamts.
+//
+// A value counts as changed unless both values are of the same comparable type
+// and are ==. So a key Put again with an uncomparable value, like a slice, is
+// always reported as Changed.
+func Diff(oldHamt, newHamt Hamt, fn func(Change) bool) {
+	var ob, nb = hamtBaseOf(oldHamt), hamtBaseOf(newHamt)
+	diffNodes(&ob.root, &nb.root, 0, fn)
+}
+
+// Changes returns an iter.Seq of the Changes Diff would report.
+func Changes(oldHamt, newHamt Hamt) iter.Seq[Change] {
+	return func(yield func(Change) bool) {
+		Diff(oldHamt, newHamt, yield)
+	}
+}
+
+// diffNodes reports the differences between the nodes a (old) and b (new)
+// found at the same slot of the two tries. If they are tables, they are tables
+// at the given depth. It returns false if fn stopped the traversal.
+func diffNodes(a, b nodeI, depth uint, fn func(Change) bool) bool {
+	if a == b {
+		return true
+	}
+
+	if a == nil {
+		return reportAll(b, Added, fn)
+	}
+
+	if b == nil {
+		return reportAll(a, Removed, fn)
+	}
+
+	var la, aIsLeaf = a.(leafI)
+	var lb, bIsLeaf = b.(leafI)
+	if aIsLeaf && bIsLeaf && (la.Hash() == lb.Hash() || depth > maxDepth) {
+		return diffLeafs(la, lb, fn)
+	}
+
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var ca = child(a, idx, depth)
+		var cb = child(b, idx, depth)
+		if !diffNodes(ca, cb, depth+1, fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reportAll reports every KeyVal pair of the subtree n as a Change of the
+// given kind.
+func reportAll(n nodeI, kind ChangeKind, fn func(Change) bool) bool {
+	return n.visit(func(x nodeI) bool {
+		if l, isLeaf := x.(leafI); isLeaf {
+			for _, kv := range l.keyVals() {
+				var c = Change{Kind: kind, Key: kv.Key}
+				if kind == Added {
+					c.NewVal = kv.Val
+				} else {
+					c.OldVal = kv.Val
+				}
+				if !fn(c) {
+					return false
+				}
+			}
+		}
+		return true
+	})
+}
+
+// diffLeafs reports the differences between two leafs with the same HashVal.
+func diffLeafs(a, b leafI, fn func(Change) bool) bool {
+	for _, akv := range a.keyVals() {
+		var bval, inB = b.get(akv.Key)
+		var c Change
+		switch {
+		case !inB:
+			c = Change{Removed, akv.Key, akv.Val, nil}
+		case !sameVal(akv.Val, bval):
+			c = Change{Changed, akv.Key, akv.Val, bval}
+		default:
+			continue
+		}
+		if !fn(c) {
+			return false
+		}
+	}
+
+	for _, bkv := range b.keyVals() {
+		if _, inA := a.get(bkv.Key); !inA {
+			if !fn(Change{Added, bkv.Key, nil, bkv.Val}) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
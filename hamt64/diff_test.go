@@ -0,0 +1,95 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numDiffKvs = 64 * 1024
+
+func TestHamt64Diff(t *testing.T) {
+	var name = "TestHamt64Diff:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numDiffKvs]
+
+	var h0, err = buildHamt64(name, kvs[:numDiffKvs/2], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var expected = make(map[hamt64.StringKey]hamt64.Change)
+
+	var h1 = h0
+	for _, kv := range kvs[numDiffKvs/2 : numDiffKvs/2+100] {
+		h1, _ = h1.Put(kv.Key, kv.Val)
+		expected[kv.Key.(hamt64.StringKey)] =
+			hamt64.Change{hamt64.Added, kv.Key, nil, kv.Val}
+	}
+	for _, kv := range kvs[:100] {
+		h1, _, _ = h1.Del(kv.Key)
+		expected[kv.Key.(hamt64.StringKey)] =
+			hamt64.Change{hamt64.Removed, kv.Key, kv.Val, nil}
+	}
+	for _, kv := range kvs[100:200] {
+		h1, _ = h1.Put(kv.Key, -kv.Val.(int))
+		expected[kv.Key.(hamt64.StringKey)] =
+			hamt64.Change{hamt64.Changed, kv.Key, kv.Val, -kv.Val.(int)}
+	}
+	for _, kv := range kvs[200:300] {
+		// same value Put again; not a change
+		h1, _ = h1.Put(kv.Key, kv.Val)
+	}
+
+	var n int
+	for c := range hamt64.Changes(h0, h1) {
+		var e, found = expected[c.Key.(hamt64.StringKey)]
+		if !found || e != c {
+			t.Fatalf("%s: unexpected %s; expected %s", name, c, e)
+		}
+		n++
+	}
+	if n != len(expected) {
+		t.Fatalf("%s: Diff reported %d changes; expected %d",
+			name, n, len(expected))
+	}
+
+	n = 0
+	hamt64.Diff(h0, h1, func(hamt64.Change) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("%s: Diff did not stop early; n,%d != 10", name, n)
+	}
+
+	hamt64.Diff(h1, h1, func(c hamt64.Change) bool {
+		t.Fatalf("%s: Diff(h1, h1) reported %s", name, c)
+		return false
+	})
+}
+
+func TestHamt64DiffUnrelated(t *testing.T) {
+	var name = "TestHamt64DiffUnrelated:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numDiffKvs]
+
+	// Same content built twice with different table options shares nothing.
+	var h0, err = buildHamt64(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var h1 hamt64.Hamt = hamt64.NewTransient(hamt64.FixedTables)
+	for _, kv := range kvs[1:] {
+		h1, _ = h1.Put(kv.Key, kv.Val)
+	}
+
+	var changes []hamt64.Change
+	hamt64.Diff(h0, h1, func(c hamt64.Change) bool {
+		changes = append(changes, c)
+		return true
+	})
+	if len(changes) != 1 || changes[0].Kind != hamt64.Removed ||
+		!changes[0].Key.Equals(kvs[0].Key) {
+		t.Fatalf("%s: changes=%v; expected only %s removed",
+			name, changes, kvs[0].Key)
+	}
+}
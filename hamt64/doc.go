@@ -19,5 +19,13 @@ with sparse tables only or with a hybrid of the two. Thia hybid form is meant
 to allow the denser lower inner nodes to be implemented by the faster fixed
 tables and the much more numerous but sparser higher inner nodes to be
 implemented by the space conscious sparse tables.
+
+The generic Map[K, V] interface, with its MapFunctional and MapTransient
+implementations, is built from the same fixed and sparse tables. Its leafs hold
+typed keys and values, so there is no KeyI wrapping or interface{} boxing. The
+HashVal of a key is calculated by a Hasher, like HashString or HashInt64.
+
+The Set interface, with its SetFunctional and SetTransient implementations, is
+also built from the same tables. Its leafs only hold keys.
 */
 package hamt64
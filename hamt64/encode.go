@@ -0,0 +1,383 @@
+package hamt64
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// The binary format written by Encode is:
+//
+//	magic     "HAMT"
+//	version   byte; encodingVersion
+//	hashSize  byte; 32 or 64, the HashVal size of the encoding package
+//	tblOpt    byte; HybridTables, SparseTables, xor FixedTables
+//	nentries  uvarint
+//	records   ...
+//
+// followed by a sequence of records, each starting with a tag byte:
+//
+//	'C' id:uvarint name:chunk             codec name for the id
+//	'E' kid:uvarint key:chunk vid:uvarint val:chunk
+//	'Z' nentries:uvarint                  end of the Hamt
+//
+// where a chunk is a uvarint length followed by that many bytes. A codec id
+// is defined by a 'C' record before the first 'E' record using it. The
+// HashVals are not part of the format, so a Hamt encoded by hamt32 can be
+// decoded by hamt64 and vice versa.
+
+const encodingMagic = "HAMT"
+
+const encodingVersion = 1
+
+const (
+	codecTag = 'C'
+	entryTag = 'E'
+	endTag   = 'Z'
+)
+
+// maxChunkLen bounds the length of a single encoded key or value, so a corrupt
+// length can not make Decode allocate an arbitrary amount of memory.
+const maxChunkLen = 1 << 30
+
+// encoder writes the records of one Hamt.
+type encoder struct {
+	op  string // the function name errors are wrapped with
+	w   *bufio.Writer
+	reg *Registry
+	ids map[*codec]uint64
+	buf []byte
+	err error
+}
+
+// Encode writes the Hamt h to w in the binary format described above. Keys
+// and values are encoded by the codecs of reg; if reg is nil DefaultRegistry
+// is used. Encode walks h once and buffers only the current pair, so it runs
+// in constant memory beyond the Hamt itself.
+func Encode(w io.Writer, h Hamt, reg *Registry) error {
+	if reg == nil {
+		reg = DefaultRegistry
+	}
+
+	var e = encoder{
+		op:  "Encode",
+		w:   bufio.NewWriter(w),
+		reg: reg,
+		ids: make(map[*codec]uint64),
+	}
+
+	e.buf = append(e.buf[:0], encodingMagic...)
+	e.buf = append(e.buf, encodingVersion, byte(hashSize),
+		byte(hamtBaseOf(h).tableOption()))
+	e.buf = binary.AppendUvarint(e.buf, uint64(h.Nentries()))
+	e.write(e.buf)
+
+	h.Range(func(k KeyI, v interface{}) bool {
+		e.writeEntry(entryTag, k, v)
+		return e.err == nil
+	})
+
+	e.buf = append(e.buf[:0], endTag)
+	e.buf = binary.AppendUvarint(e.buf, uint64(h.Nentries()))
+	e.write(e.buf)
+
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+func (e *encoder) write(bs []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(bs)
+	}
+}
+
+// codecID returns the id of the codec for v, writing a 'C' record the first
+// time the codec is used.
+func (e *encoder) codecID(v interface{}) (*codec, uint64) {
+	var c, err = e.reg.lookupType(v)
+	if err != nil {
+		e.err = errors.Wrap(err, e.op)
+		return nil, 0
+	}
+
+	var id, found = e.ids[c]
+	if !found {
+		id = uint64(len(e.ids))
+		e.ids[c] = id
+
+		e.buf = append(e.buf[:0], codecTag)
+		e.buf = binary.AppendUvarint(e.buf, id)
+		e.buf = appendChunk(e.buf, []byte(c.name))
+		e.write(e.buf)
+	}
+
+	return c, id
+}
+
+// writeEntry writes a record with the given tag for the pair k and v.
+func (e *encoder) writeEntry(tag byte, k KeyI, v interface{}) {
+	var kc, kid = e.codecID(k)
+	var vc, vid = e.codecID(v)
+	if e.err != nil {
+		return
+	}
+
+	var kbs, err = kc.enc(k)
+	if err != nil {
+		e.err = errors.Wrapf(err, "%s: key %v", e.op, k)
+		return
+	}
+	vbs, err := vc.enc(v)
+	if err != nil {
+		e.err = errors.Wrapf(err, "%s: value of key %v", e.op, k)
+		return
+	}
+
+	e.buf = append(e.buf[:0], tag)
+	e.buf = binary.AppendUvarint(e.buf, kid)
+	e.buf = appendChunk(e.buf, kbs)
+	e.buf = binary.AppendUvarint(e.buf, vid)
+	e.buf = appendChunk(e.buf, vbs)
+	e.write(e.buf)
+}
+
+func appendChunk(buf, bs []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(bs)))
+	return append(buf, bs...)
+}
+
+// byteReader is what Decode reads from.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// decoder reads the records of one Hamt.
+type decoder struct {
+	r      byteReader
+	reg    *Registry
+	codecs []*codec
+	buf    []byte
+}
+
+// Decode reads a Hamt written by Encode from r. The key and value codecs are
+// looked up, by name, in reg; if reg is nil DefaultRegistry is used. The Hamt
+// is a HamtFunctional if functional is true, otherwise a HamtTransient, with
+// the table option it was encoded with.
+//
+// Decode reads one pair at a time straight into the new Hamt, so it runs in
+// constant memory beyond the Hamt itself. If r is not an io.ByteReader it is
+// wrapped in a bufio.Reader, which may read past the end of the Hamt.
+func Decode(r io.Reader, functional bool, reg *Registry) (Hamt, error) {
+	if reg == nil {
+		reg = DefaultRegistry
+	}
+
+	var d = decoder{reg: reg}
+	if br, ok := r.(byteReader); ok {
+		d.r = br
+	} else {
+		d.r = bufio.NewReader(r)
+	}
+
+	var hdr [len(encodingMagic) + 3]byte
+	if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+		return nil, errors.Wrap(err, "Decode: failed to read header")
+	}
+	if string(hdr[:len(encodingMagic)]) != encodingMagic {
+		return nil, errors.Errorf("Decode: bad magic %q",
+			hdr[:len(encodingMagic)])
+	}
+	var version, hsize, tblOpt = hdr[4], hdr[5], int(hdr[6])
+	if version != encodingVersion {
+		return nil, errors.Errorf("Decode: unsupported version %d", version)
+	}
+	if hsize != 32 && hsize != 64 {
+		return nil, errors.Errorf("Decode: bad hash size %d", hsize)
+	}
+	if tblOpt != HybridTables && tblOpt != SparseTables &&
+		tblOpt != FixedTables {
+		return nil, errors.Errorf("Decode: bad table option %d", tblOpt)
+	}
+
+	var nentries, err = binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, errors.Wrap(err, "Decode: failed to read nentries")
+	}
+
+	var h = NewTransient(tblOpt)
+
+	for {
+		var tag, err = d.r.ReadByte()
+		if err != nil {
+			return nil, errors.Wrap(noEOF(err), "Decode: failed to read tag")
+		}
+
+		switch tag {
+		case codecTag:
+			err = d.readCodec()
+		case entryTag:
+			err = d.readEntry(h)
+		case endTag:
+			var n uint64
+			n, err = binary.ReadUvarint(d.r)
+			if err != nil {
+				return nil, errors.Wrap(noEOF(err), "Decode: bad end record")
+			}
+			if n != nentries || uint64(h.Nentries()) != nentries {
+				return nil, errors.Errorf(
+					"Decode: read %d entries; header says %d, trailer says %d",
+					h.Nentries(), nentries, n)
+			}
+			if functional {
+				return h.Persistent(), nil
+			}
+			return h, nil
+		default:
+			err = errors.Errorf("unknown record tag %q", tag)
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "Decode")
+		}
+	}
+}
+
+// readChunk reads a chunk into d.buf; it is only valid until the next call.
+func (d *decoder) readChunk() ([]byte, error) {
+	var n, err = binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	if n > maxChunkLen {
+		return nil, errors.Errorf("chunk length %d too long", n)
+	}
+	if uint64(cap(d.buf)) < n {
+		d.buf = make([]byte, n)
+	}
+	d.buf = d.buf[:n]
+	if _, err = io.ReadFull(d.r, d.buf); err != nil {
+		return nil, noEOF(err)
+	}
+	return d.buf, nil
+}
+
+func (d *decoder) readCodec() error {
+	var id, err = binary.ReadUvarint(d.r)
+	if err != nil {
+		return noEOF(err)
+	}
+	if id != uint64(len(d.codecs)) {
+		return errors.Errorf("codec id %d out of order", id)
+	}
+
+	name, err := d.readChunk()
+	if err != nil {
+		return err
+	}
+
+	c, err := d.reg.lookupName(string(name))
+	if err != nil {
+		return err
+	}
+
+	d.codecs = append(d.codecs, c)
+	return nil
+}
+
+// readValue reads a codec id and a chunk, and decodes the chunk.
+func (d *decoder) readValue() (interface{}, error) {
+	var id, err = binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	if id >= uint64(len(d.codecs)) {
+		return nil, errors.Errorf("undefined codec id %d", id)
+	}
+	var c = d.codecs[id]
+
+	bs, err := d.readChunk()
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := c.dec(bs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "codec %q", c.name)
+	}
+	return v, nil
+}
+
+func (d *decoder) readEntry(h *HamtTransient) error {
+	var kv, err = d.readValue()
+	if err != nil {
+		return err
+	}
+	var key, isKey = kv.(KeyI)
+	if !isKey {
+		return errors.Errorf("decoded key of type %T is not a KeyI", kv)
+	}
+
+	val, err := d.readValue()
+	if err != nil {
+		return err
+	}
+
+	h.Put(key, val)
+	return nil
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for reads inside a record.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler with Encode and
+// DefaultRegistry.
+func (h *HamtFunctional) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, h, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler with Decode and
+// DefaultRegistry. It replaces the contents of h.
+func (h *HamtFunctional) UnmarshalBinary(data []byte) error {
+	var nh, err = Decode(bytes.NewReader(data), true, nil)
+	if err != nil {
+		return err
+	}
+	h.set(&nh.(*HamtFunctional).hamtBase)
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler with Encode and
+// DefaultRegistry.
+func (h *HamtTransient) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, h, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler with Decode and
+// DefaultRegistry. It replaces the contents of h.
+func (h *HamtTransient) UnmarshalBinary(data []byte) error {
+	var nh, err = Decode(bytes.NewReader(data), false, nil)
+	if err != nil {
+		return err
+	}
+	h.set(&nh.(*HamtTransient).hamtBase)
+	return nil
+}
@@ -0,0 +1,93 @@
+package hamt64
+
+// Equal returns true if the Hamts a and b hold the same keys and, for every
+// key, valEq reports the two values equal; if valEq is nil see ValEqFunc.
+//
+// Equal compares Nentries first and then walks both tries in parallel,
+// skipping every table and leaf the two Hamts share. When one Hamt was derived
+// from the other the cost is proportional to the size of the change. Tries
+// with different layouts, like a fixedTable in one where the other has a
+// sparseTable, or a table in one where the other has a leaf, are compared by
+// their contents.
+func Equal(a, b Hamt, valEq ValEqFunc) bool {
+	if a.Nentries() != b.Nentries() {
+		return false
+	}
+
+	if valEq == nil {
+		valEq = sameVal
+	}
+
+	var ab, bb = hamtBaseOf(a), hamtBaseOf(b)
+	return equalNodes(&ab.root, &bb.root, 0, valEq, false)
+}
+
+// IsSubmapOf returns true if every key of the Hamt a is in the Hamt b and,
+// for every key of a, valEq reports the two values equal; if valEq is nil see
+// ValEqFunc.
+//
+// Like Equal, IsSubmapOf returns false early if a has more entries than b and
+// skips every table and leaf the two Hamts share.
+func IsSubmapOf(a, b Hamt, valEq ValEqFunc) bool {
+	if a.Nentries() > b.Nentries() {
+		return false
+	}
+
+	if valEq == nil {
+		valEq = sameVal
+	}
+
+	var ab, bb = hamtBaseOf(a), hamtBaseOf(b)
+	return equalNodes(&ab.root, &bb.root, 0, valEq, true)
+}
+
+// equalNodes compares the nodes x and y found at the same slot of two tries.
+// If they are tables, they are tables at the given depth. If sub is true it
+// only checks that the pairs of x are in y, otherwise also the reverse.
+func equalNodes(x, y nodeI, depth uint, valEq ValEqFunc, sub bool) bool {
+	if x == y {
+		return true
+	}
+
+	// tables and leafs are never empty
+	if x == nil {
+		return sub
+	}
+	if y == nil {
+		return false
+	}
+
+	var lx, xIsLeaf = x.(leafI)
+	var ly, yIsLeaf = y.(leafI)
+	if xIsLeaf && yIsLeaf && (lx.Hash() == ly.Hash() || depth > maxDepth) {
+		return equalLeafs(lx, ly, valEq, sub)
+	}
+
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var cx = child(x, idx, depth)
+		var cy = child(y, idx, depth)
+		if !equalNodes(cx, cy, depth+1, valEq, sub) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// equalLeafs compares two leafs with the same HashVal.
+func equalLeafs(x, y leafI, valEq ValEqFunc, sub bool) bool {
+	var xkvs = x.keyVals()
+
+	if !sub && len(xkvs) != len(y.keyVals()) {
+		return false
+	}
+
+	for _, kv := range xkvs {
+		var val, found = y.get(kv.Key)
+		if !found || !valEq(kv.Val, val) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,103 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numEqualKvs = 20 * 1024
+
+func TestHamt64Equal(t *testing.T) {
+	var name = "TestHamt64Equal:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numEqualKvs]
+
+	var a, err = buildHamt64(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	if !hamt64.Equal(a, a, nil) {
+		t.Fatalf("%s: !Equal(a, a)", name)
+	}
+
+	// the same contents with every other table option, so the layouts differ
+	for _, tblOpt := range []int{
+		hamt64.HybridTables, hamt64.FixedTables, hamt64.SparseTables,
+	} {
+		var b, err = buildHamt64(name, kvs, !Functional, tblOpt)
+		if err != nil {
+			t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+		}
+		if !hamt64.Equal(a, b, nil) || !hamt64.Equal(b, a, nil) {
+			t.Fatalf("%s: !Equal(a, b) for b %s",
+				name, hamt64.TableOptionName[tblOpt])
+		}
+	}
+
+	var b = a.DeepCopy()
+	b, _ = b.Put(kvs[0].Key, -1)
+	if hamt64.Equal(a, b, nil) {
+		t.Fatalf("%s: Equal(a, b) with a changed value", name)
+	}
+
+	var absEq = func(x, y interface{}) bool {
+		var ix, iy = x.(int), y.(int)
+		return ix == iy || ix == -iy || ix < 0 || iy < 0
+	}
+	if !hamt64.Equal(a, b, absEq) {
+		t.Fatalf("%s: !Equal(a, b, absEq)", name)
+	}
+
+	b, _, _ = b.Del(kvs[0].Key)
+	if hamt64.Equal(a, b, nil) {
+		t.Fatalf("%s: Equal(a, b) with a deleted key", name)
+	}
+
+	// same Nentries, different keys
+	b, _ = b.Put(KVS64[numEqualKvs].Key, kvs[0].Val)
+	if hamt64.Equal(a, b, nil) {
+		t.Fatalf("%s: Equal(a, b) with a replaced key", name)
+	}
+}
+
+func TestHamt64IsSubmapOf(t *testing.T) {
+	var name = "TestHamt64IsSubmapOf:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numEqualKvs]
+
+	var a, err = buildHamt64(name, kvs[:len(kvs)/2], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var tblOpt = (TableOption + 1) % 3
+	b, err := buildHamt64(name, kvs, !Functional, tblOpt)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	if !hamt64.IsSubmapOf(a, b, nil) {
+		t.Fatalf("%s: !IsSubmapOf(a, b)", name)
+	}
+	if hamt64.IsSubmapOf(b, a, nil) {
+		t.Fatalf("%s: IsSubmapOf(b, a)", name)
+	}
+	if !hamt64.IsSubmapOf(a, a, nil) {
+		t.Fatalf("%s: !IsSubmapOf(a, a)", name)
+	}
+
+	b, _ = b.Put(kvs[0].Key, -1)
+	if hamt64.IsSubmapOf(a, b, nil) {
+		t.Fatalf("%s: IsSubmapOf(a, b) with a changed value", name)
+	}
+
+	b, _, _ = b.Del(kvs[0].Key)
+	if hamt64.IsSubmapOf(a, b, nil) {
+		t.Fatalf("%s: IsSubmapOf(a, b) with a deleted key", name)
+	}
+
+	var e = hamt64.New(Functional, TableOption)
+	if !hamt64.IsSubmapOf(e, a, nil) {
+		t.Fatalf("%s: !IsSubmapOf(empty, a)", name)
+	}
+}
@@ -0,0 +1,195 @@
+package hamt64
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Digest is a SHA-256 sum, as returned by HamtFunctional.Fingerprint().
+type Digest [sha256.Size]byte
+
+// String returns the Digest as a hex string.
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// ValDigestFunc returns the bytes that stand for a value in a Fingerprint.
+// Equal values must return equal bytes.
+type ValDigestFunc func(val interface{}) []byte
+
+// Digester calculates the Fingerprints of HamtFunctionals. The Digest of every
+// table is cached in the table, tagged with the Digester, so Fingerprints
+// calculated with the same Digester only visit the tables that changed since
+// the last one.
+type Digester struct {
+	valDigest ValDigestFunc
+}
+
+// NewDigester constructs a Digester that uses valDigest for the values. If
+// valDigest is nil the values are formatted with "%T:%#v".
+func NewDigester(valDigest ValDigestFunc) *Digester {
+	var d = new(Digester)
+	d.valDigest = valDigest
+	if d.valDigest == nil {
+		d.valDigest = func(val interface{}) []byte {
+			return []byte(fmt.Sprintf("%T:%#v", val, val))
+		}
+	}
+	return d
+}
+
+// tableDigest is the Digest of a node, calculated by the Digester by. The leaf
+// flag is true if the Digest is that of a leaf.
+type tableDigest struct {
+	by   *Digester
+	sum  Digest
+	leaf bool
+}
+
+// Fingerprint returns a Merkle hash of the contents of the HamtFunctional,
+// calculated by d. Two Hamts with the same keys and values, by the
+// ValDigestFunc of d, have the same Fingerprint, no matter their table options
+// or the order of the Puts and Dels that built them.
+//
+// The Digest of a table is combined from the Digests of its entries in index
+// order, and cached in the table. As a HamtFunctional shares every table a Put
+// or Del did not copy with its predecessor, the Fingerprint of a HamtFunctional
+// derived from one that already has a Fingerprint only rehashes the copied
+// paths. Tables still owned by a HamtTransient are never cached.
+//
+// Keys contribute their own bytes for the key types of this package and for
+// keys implementing encoding.BinaryMarshaler; other keys are formatted with
+// "%T:%#v".
+func (h *HamtFunctional) Fingerprint(d *Digester) Digest {
+	// The root table is embedded in the HamtFunctional, which Put and Del
+	// copy by value, so its digest is never cached.
+	var td = d.digestTable(&h.root, false)
+	return td.sum
+}
+
+// digestNode returns the digest of the node n, which is not nil.
+func (d *Digester) digestNode(n nodeI) *tableDigest {
+	switch x := n.(type) {
+	case tableI:
+		return d.digestTable(x, true)
+	case leafI:
+		return &tableDigest{by: d, sum: d.digestLeaf(x), leaf: true}
+	}
+	panic("digestNode: unknown node type")
+}
+
+// digestTable returns the digest of the table t, from its cache if possible.
+// If cache is true and t is not owned by a live HamtTransient, the digest is
+// stored in t.
+//
+// To be independent of the layout, a table holding nothing but one leaf, which
+// Del can leave behind, has the digest of that leaf; the layout Put builds
+// for the same keys has just the leaf there.
+func (d *Digester) digestTable(t tableI, cache bool) *tableDigest {
+	if td := t.cachedDigest(); td != nil && td.by == d {
+		return td
+	}
+
+	var sh = sha256.New()
+	sh.Write([]byte{'T'})
+
+	var nents int
+	var last *tableDigest
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var n = t.get(idx)
+		if n == nil {
+			continue
+		}
+		last = d.digestNode(n)
+		nents++
+		sh.Write([]byte{byte(idx)})
+		sh.Write(last.sum[:])
+	}
+
+	var td *tableDigest
+	if nents == 1 && last.leaf {
+		td = last
+	} else {
+		td = &tableDigest{by: d}
+		sh.Sum(td.sum[:0])
+	}
+
+	if cache && (t.owner() == nil || t.owner().frozen) {
+		t.cacheDigest(td)
+	}
+
+	return td
+}
+
+// digestLeaf returns the digest of the leaf l. The pairs of a collisionLeaf
+// are combined in byte order of their digests, so the order they were put in
+// does not matter.
+func (d *Digester) digestLeaf(l leafI) Digest {
+	var kvs = l.keyVals()
+	var sums = make([][]byte, len(kvs))
+	for i, kv := range kvs {
+		var sh = sha256.New()
+		writeChunk(sh, keyDigest(kv.Key))
+		writeChunk(sh, d.valDigest(kv.Val))
+		sums[i] = sh.Sum(nil)
+	}
+
+	sort.Slice(sums, func(i, j int) bool {
+		return bytes.Compare(sums[i], sums[j]) < 0
+	})
+
+	var sh = sha256.New()
+	sh.Write([]byte{'L'})
+	for _, sum := range sums {
+		sh.Write(sum)
+	}
+
+	var sum Digest
+	sh.Sum(sum[:0])
+	return sum
+}
+
+// writeChunk writes the length of bs followed by bs, so that the boundaries
+// between chunks are part of the digest.
+func writeChunk(w interface{ Write([]byte) (int, error) }, bs []byte) {
+	var lenbuf [binary.MaxVarintLen64]byte
+	w.Write(lenbuf[:binary.PutUvarint(lenbuf[:], uint64(len(bs)))])
+	w.Write(bs)
+}
+
+// keyDigest returns the bytes that stand for the key in a Fingerprint.
+func keyDigest(key KeyI) []byte {
+	var buf [9]byte
+	switch k := key.(type) {
+	case StringKey:
+		return append([]byte{'s'}, k...)
+	case ByteSliceKey:
+		return append([]byte{'b'}, k...)
+	case Int32Key:
+		buf[0] = 'i'
+		binary.BigEndian.PutUint32(buf[1:], uint32(k))
+		return buf[:5]
+	case Uint32Key:
+		buf[0] = 'u'
+		binary.BigEndian.PutUint32(buf[1:], uint32(k))
+		return buf[:5]
+	case Int64Key:
+		buf[0] = 'I'
+		binary.BigEndian.PutUint64(buf[1:], uint64(k))
+		return buf[:]
+	case Uint64Key:
+		buf[0] = 'U'
+		binary.BigEndian.PutUint64(buf[1:], uint64(k))
+		return buf[:]
+	case encoding.BinaryMarshaler:
+		if bs, err := k.MarshalBinary(); err == nil {
+			return append([]byte{'m'}, bs...)
+		}
+	}
+	return []byte(fmt.Sprintf("%T:%#v", key, key))
+}
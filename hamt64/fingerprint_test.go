@@ -0,0 +1,103 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numFingerprintKvs = 20 * 1024
+
+func TestHamt64Fingerprint(t *testing.T) {
+	var name = "TestHamt64Fingerprint:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numFingerprintKvs]
+	var d = hamt64.NewDigester(nil)
+
+	var h, err = buildHamt64(name, kvs, true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var hf = h.(*hamt64.HamtFunctional)
+	var fp = hf.Fingerprint(d)
+
+	if fp2 := hf.Fingerprint(d); fp2 != fp {
+		t.Fatalf("%s: second Fingerprint() %s != %s", name, fp2, fp)
+	}
+
+	// the same contents, built in reverse order with another table option
+	var rkvs = make([]hamt64.KeyVal, len(kvs))
+	for i, kv := range kvs {
+		rkvs[len(kvs)-1-i] = kv
+	}
+	var oh = hamt64.Build(rkvs, (TableOption+1)%3)
+	if ofp := oh.Fingerprint(d); ofp != fp {
+		t.Fatalf("%s: Fingerprint() of Build() %s != %s", name, ofp, fp)
+	}
+
+	var nh, _ = hf.Put(kvs[0].Key, -1)
+	var nfp = nh.(*hamt64.HamtFunctional).Fingerprint(d)
+	if nfp == fp {
+		t.Fatalf("%s: Fingerprint() did not change after Put()", name)
+	}
+
+	nh, _ = nh.Put(kvs[0].Key, kvs[0].Val)
+	if rfp := nh.(*hamt64.HamtFunctional).Fingerprint(d); rfp != fp {
+		t.Fatalf("%s: Fingerprint() %s != %s after restoring the value",
+			name, rfp, fp)
+	}
+
+	// Deleting half the keys and putting them back leaves a different layout
+	var dh = hamt64.Hamt(hf)
+	for _, kv := range kvs[:len(kvs)/2] {
+		dh, _, _ = dh.Del(kv.Key)
+	}
+	if dfp := dh.(*hamt64.HamtFunctional).Fingerprint(d); dfp == fp {
+		t.Fatalf("%s: Fingerprint() did not change after Del()", name)
+	}
+	for _, kv := range kvs[:len(kvs)/2] {
+		dh, _ = dh.Put(kv.Key, kv.Val)
+	}
+	if dfp := dh.(*hamt64.HamtFunctional).Fingerprint(d); dfp != fp {
+		t.Fatalf("%s: Fingerprint() %s != %s after Del() and Put()",
+			name, dfp, fp)
+	}
+
+	// The cached digests of another Digester are not used.
+	var d2 = hamt64.NewDigester(func(val interface{}) []byte {
+		return []byte{byte(val.(int))}
+	})
+	if fp2 := hf.Fingerprint(d2); fp2 == fp {
+		t.Fatalf("%s: Fingerprint(d2) == Fingerprint(d)", name)
+	}
+}
+
+func TestHamt64FingerprintTransient(t *testing.T) {
+	var name = "TestHamt64FingerprintTransient:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numFingerprintKvs]
+	var half = len(kvs) / 2
+	var d = hamt64.NewDigester(nil)
+
+	var h, err = buildHamt64(name, kvs[:half], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var hf = h.(*hamt64.HamtFunctional)
+	var fp = hf.Fingerprint(d)
+
+	// modifying a transient must not disturb the digests cached for h
+	var th = hf.ToTransient().(*hamt64.HamtTransient)
+	for _, kv := range kvs[half:] {
+		th.Put(kv.Key, kv.Val)
+	}
+	var ph = th.Persistent()
+
+	if hfp := hf.Fingerprint(d); hfp != fp {
+		t.Fatalf("%s: Fingerprint() of h changed; %s != %s", name, hfp, fp)
+	}
+
+	var want = hamt64.Build(kvs, TableOption).Fingerprint(d)
+	if pfp := ph.Fingerprint(d); pfp != want {
+		t.Fatalf("%s: Fingerprint() of Persistent() %s != %s", name, pfp, want)
+	}
+}
@@ -3,6 +3,7 @@ package hamt64
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 )
 
 type fixedTable struct {
@@ -10,14 +11,30 @@ type fixedTable struct {
 	depth    uint
 	nents    uint
 	hashPath HashVal
+	edit     *editToken
+	annot    atomic.Pointer[tableAnnot] // see node.go
 }
 
 func (t *fixedTable) copy() tableI {
 	var nt = new(fixedTable)
-	*nt = *t
+	nt.nodes = t.nodes
+	nt.depth = t.depth
+	nt.nents = t.nents
+	nt.hashPath = t.hashPath
 	return nt
 }
 
+// set makes t the same table as o, in place of copying the struct, which
+// would share the annotations cached for o. A root table, the only table
+// held by value, never has any.
+func (t *fixedTable) set(o *fixedTable) {
+	t.nodes = o.nodes
+	t.depth = o.depth
+	t.nents = o.nents
+	t.hashPath = o.hashPath
+	t.edit = o.edit
+}
+
 func (t *fixedTable) deepCopy() tableI {
 	var nt = new(fixedTable)
 	nt.hashPath = t.hashPath
@@ -223,3 +240,33 @@ func (t *fixedTable) iter() tableIterFunc {
 		return nil
 	}
 }
+
+func (t *fixedTable) owner() *editToken {
+	return t.edit
+}
+
+func (t *fixedTable) setOwner(edit *editToken) {
+	t.edit = edit
+}
+
+func (t *fixedTable) cachedDigest() *tableDigest {
+	if a := t.annot.Load(); a != nil {
+		return a.dig.Load()
+	}
+	return nil
+}
+
+func (t *fixedTable) cacheDigest(td *tableDigest) {
+	annotOf(&t.annot).dig.Store(td)
+}
+
+func (t *fixedTable) cachedStored() *storedTable {
+	if a := t.annot.Load(); a != nil {
+		return a.sto.Load()
+	}
+	return nil
+}
+
+func (t *fixedTable) cacheStored(st *storedTable) {
+	annotOf(&t.annot).sto.Store(st)
+}
@@ -10,6 +10,16 @@ type hamtBase struct {
 	nentries   uint
 	nograde    bool
 	startFixed bool
+	edit       *editToken // only used by HamtTransient
+}
+
+// set makes h the same as o; see fixedTable.set.
+func (h *hamtBase) set(o *hamtBase) {
+	h.root.set(&o.root)
+	h.nentries = o.nentries
+	h.nograde = o.nograde
+	h.startFixed = o.startFixed
+	h.edit = o.edit
 }
 
 func (h *hamtBase) init(tblOpt int) {
@@ -27,6 +37,18 @@ func (h *hamtBase) init(tblOpt int) {
 	}
 }
 
+// tableOption returns the table option, HybridTables, SparseTables, xor
+// FixedTables, that init() was called with.
+func (h *hamtBase) tableOption() int {
+	switch {
+	case h.startFixed:
+		return FixedTables
+	case h.nograde:
+		return SparseTables
+	}
+	return HybridTables
+}
+
 // IsEmpty simply returns if the HamtFunctional datastucture has no entries.
 func (h *hamtBase) IsEmpty() bool {
 	//return h.root == nil
@@ -44,7 +66,7 @@ func (h *hamtBase) Nentries() uint {
 // ToTransient and ToFunctional.
 func (h *hamtBase) DeepCopy() Hamt {
 	var nh = new(HamtFunctional)
-	nh.root = *h.root.deepCopy().(*fixedTable)
+	nh.root.set(h.root.deepCopy().(*fixedTable))
 	nh.nentries = h.nentries
 	nh.nograde = h.nograde
 	nh.startFixed = h.startFixed
@@ -204,9 +226,22 @@ func (h *hamtBase) Range(fn func(KeyI, interface{}) bool) {
 	h.walk(visitLeafs)
 }
 
+// Iter returns a new Iterator positioned before the first KeyVal pair of the
+// Hamt.
+func (h *hamtBase) Iter() *Iterator {
+	return newIterator(&h.root)
+}
+
 // Stats walks the Hamt in a pre-order traversal and populates a Stats data
 // struture which it returns.
 func (h *hamtBase) Stats() *Stats {
+	return calcStats(&h.root)
+}
+
+// calcStats walks the tables from root down in a pre-order traversal and
+// populates a Stats data struture which it returns. It is shared by the Hamt
+// and Map implementations, because they are built from the same tables.
+func calcStats(root tableI) *Stats {
 	var stats = new(Stats)
 
 	// statFn closes over the stats variable
@@ -239,17 +274,35 @@ func (h *hamtBase) Stats() *Stats {
 			stats.Leafs++
 			stats.FlatLeafs++
 			stats.KeyVals += 1
-			keepOn = false
 		case *collisionLeaf:
 			stats.Nodes++
 			stats.Leafs++
 			stats.CollisionLeafs++
 			stats.KeyVals += uint(len(x.kvs))
-			keepOn = false
+		case countedLeaf:
+			stats.Nodes++
+			stats.Leafs++
+			if x.nkeyvals() == 1 {
+				stats.FlatLeafs++
+			} else {
+				stats.CollisionLeafs++
+			}
+			stats.KeyVals += x.nkeyvals()
 		}
 		return keepOn
 	}
 
-	h.walk(statFn)
+	root.visit(statFn)
 	return stats
 }
+
+// hamtBaseOf returns the hamtBase underneath a Hamt interface value.
+func hamtBaseOf(h Hamt) *hamtBase {
+	switch x := h.(type) {
+	case *HamtFunctional:
+		return &x.hamtBase
+	case *HamtTransient:
+		return &x.hamtBase
+	}
+	panic("hamtBaseOf: unknown Hamt implementation")
+}
@@ -1,5 +1,9 @@
 package hamt64
 
+import (
+	"iter"
+)
+
 // HamtTransient is the data structure which the Transient Hamt methods are
 // called upon. In fact it is identical to the HamtFunctional data structure and
 // all the table and leaf data structures it uses are the same ones used by the
@@ -22,6 +26,7 @@ func NewTransient(tblOpt int) *HamtTransient {
 	var h = new(HamtTransient)
 
 	h.hamtBase.init(tblOpt)
+	h.edit = new(editToken)
 
 	return h
 }
@@ -37,14 +42,15 @@ func (h *HamtTransient) Nentries() uint {
 	return h.hamtBase.Nentries()
 }
 
-// ToFunctional just recasts the HamtFunctional pointer to a HamtFunctional
-// underneath the Hamt interface.
-//
-// If you want a copy of the HamtTransient data structure over to a completely
-// independent HamtFunctional data structure, you should first do a DeepCopy
-// followed by a ToFunctional call.
+// ToFunctional returns a HamtFunctional with the contents of the
+// HamtTransient underneath the Hamt interface. Like Persistent it is done in
+// constant time and freezes every table the HamtTransient owns, so the
+// HamtFunctional shares them safely. Unlike Persistent, the HamtTransient
+// stays usable: it takes a new editToken, and copies a shared table the first
+// time it modifies it.
 func (h *HamtTransient) ToFunctional() Hamt {
-	var nh = (*HamtFunctional)(h)
+	var nh = h.Persistent()
+	h.edit = new(editToken)
 	return nh
 }
 
@@ -54,14 +60,45 @@ func (h *HamtTransient) ToTransient() Hamt {
 	return h
 }
 
+// Persistent returns a HamtFunctional with the contents of the HamtTransient
+// and invalidates the HamtTransient; modifying it afterwards panics. This is
+// done in constant time, the HamtFunctional shares every table with the
+// HamtTransient.
+func (h *HamtTransient) Persistent() *HamtFunctional {
+	var nh = new(HamtFunctional)
+	nh.set(&h.hamtBase)
+	nh.edit = nil
+
+	if h.edit == nil {
+		h.edit = new(editToken)
+	}
+	h.edit.frozen = true
+
+	return nh
+}
+
+// editable makes sure the HamtTransient owns every table in the path find()
+// returned for hv, so they can be modified in-place; see editToken.claim.
+func (h *HamtTransient) editable(hv HashVal, path tableStack) {
+	if h.edit == nil {
+		h.edit = new(editToken)
+	}
+	if h.edit.frozen {
+		panic("HamtTransient modified after Persistent()")
+	}
+	h.edit.claim(hv, path)
+}
+
 // DeepCopy() copies the HamtTransient data structure and every table it
 // contains recursively.
 func (h *HamtTransient) DeepCopy() Hamt {
 	var nh = new(HamtTransient)
-	nh.root = *h.root.deepCopy().(*fixedTable)
+	nh.root.set(h.root.deepCopy().(*fixedTable))
 	nh.nentries = h.nentries
 	nh.nograde = h.nograde
 	nh.startFixed = h.startFixed
+	nh.edit = new(editToken)
+	nh.edit.own(&nh.root)
 	return nh
 }
 
@@ -82,6 +119,20 @@ func (h *HamtTransient) Put(key KeyI, val interface{}) (Hamt, bool) {
 	var hv = key.Hash()
 	var path, leaf, idx = h.find(hv)
 
+	return h.put(hv, key, val, path, leaf, idx)
+}
+
+// put does the work of Put once find() has located where the key belongs.
+func (h *HamtTransient) put(
+	hv HashVal,
+	key KeyI,
+	val interface{},
+	path tableStack,
+	leaf leafI,
+	idx uint,
+) (Hamt, bool) {
+	h.editable(hv, path)
+
 	var curTable = path.pop()
 	var depth = uint(path.len())
 	var added bool
@@ -92,6 +143,7 @@ func (h *HamtTransient) Put(key KeyI, val interface{}) (Hamt, bool) {
 			(curTable.nentries()+1) == UpgradeThreshold {
 			var newTable = upgradeToFixedTable(
 				curTable.Hash(), depth, curTable.entries())
+			newTable.setOwner(h.edit)
 
 			var parentTable = path.peek()
 			var parentIdx = hv.Index(depth - 1)
@@ -110,6 +162,7 @@ func (h *HamtTransient) Put(key KeyI, val interface{}) (Hamt, bool) {
 			curTable.replace(idx, newLeaf)
 		} else {
 			var t = h.createTable(depth+1, leaf, newFlatLeaf(key, val))
+			h.edit.own(t)
 			curTable.replace(idx, t)
 			added = true
 		}
@@ -141,9 +194,17 @@ func (h *HamtTransient) Del(key KeyI) (Hamt, interface{}, bool) {
 	var hv = key.Hash()
 	var path, leaf, idx = h.find(hv)
 
-	var curTable = path.pop()
-	var depth = uint(path.len())
+	return h.del(hv, key, path, leaf, idx)
+}
 
+// del does the work of Del once find() has located where the key belongs.
+func (h *HamtTransient) del(
+	hv HashVal,
+	key KeyI,
+	path tableStack,
+	leaf leafI,
+	idx uint,
+) (Hamt, interface{}, bool) {
 	if leaf == nil {
 		return h, nil, false
 	}
@@ -154,6 +215,11 @@ func (h *HamtTransient) Del(key KeyI) (Hamt, interface{}, bool) {
 		return h, nil, false
 	}
 
+	h.editable(hv, path)
+
+	var curTable = path.pop()
+	var depth = uint(path.len())
+
 	h.nentries--
 
 	if newLeaf != nil { //leaf was a CollisionLeaf
@@ -178,6 +244,7 @@ func (h *HamtTransient) Del(key KeyI) (Hamt, interface{}, bool) {
 				//when nentries is decr'd it will be <DowngradeThreshold
 				var newTable = downgradeToSparseTable(
 					curTable.Hash(), depth, curTable.entries())
+				newTable.setOwner(h.edit)
 				var parentTable = path.peek()
 				var parentIdx = hv.Index(depth - 1)
 				parentTable.replace(parentIdx, newTable)
@@ -188,6 +255,110 @@ func (h *HamtTransient) Del(key KeyI) (Hamt, interface{}, bool) {
 	return h, val, deleted
 }
 
+// Update looks up the key in the HamtTransient data structure once and calls
+// fn with the current value and whether the key exists. If fn returns
+// keep == true the key is stored with the returned value, otherwise the key
+// is deleted. It returns a bool indicating if the HamtTransient was modified.
+// Either way it returns the original HamtTransient data structure.
+func (h *HamtTransient) Update(key KeyI, fn UpdateFunc) (Hamt, bool) {
+	var hv = key.Hash()
+	var path, leaf, idx = h.find(hv)
+
+	var old interface{}
+	var exists bool
+	if leaf != nil {
+		old, exists = leaf.get(key)
+	}
+
+	var val, keep = fn(old, exists)
+
+	switch {
+	case keep && !(exists && sameVal(old, val)):
+		var nh, _ = h.put(hv, key, val, path, leaf, idx)
+		return nh, true
+	case !keep && exists:
+		var nh, _, _ = h.del(hv, key, path, leaf, idx)
+		return nh, true
+	}
+
+	return h, false
+}
+
+// PutIfAbsent stores the (key,value) pair in the HamtTransient data structure
+// only if the key is not already in it. It returns the current value of the
+// key and a bool indicating if the pair was added (true). When the pair was
+// added the value returned is nil.
+// Either way it returns the original HamtTransient data structure.
+func (h *HamtTransient) PutIfAbsent(key KeyI, val interface{}) (
+	Hamt, interface{}, bool,
+) {
+	var hv = key.Hash()
+	var path, leaf, idx = h.find(hv)
+
+	if leaf != nil {
+		if cur, exists := leaf.get(key); exists {
+			return h, cur, false
+		}
+	}
+
+	var nh, _ = h.put(hv, key, val, path, leaf, idx)
+	return nh, nil, true
+}
+
+// ReplaceIfPresent stores the new value for the key in the HamtTransient data
+// structure only if the key is already in it. It returns the previous value of
+// the key and a bool indicating if the value was replaced (true). When the key
+// was not found the value returned is nil.
+// Either way it returns the original HamtTransient data structure.
+func (h *HamtTransient) ReplaceIfPresent(key KeyI, val interface{}) (
+	Hamt, interface{}, bool,
+) {
+	var hv = key.Hash()
+	var path, leaf, idx = h.find(hv)
+
+	if leaf == nil {
+		return h, nil, false
+	}
+
+	var old, exists = leaf.get(key)
+	if !exists {
+		return h, nil, false
+	}
+
+	var nh, _ = h.put(hv, key, val, path, leaf, idx)
+	return nh, old, true
+}
+
+// CompareAndSwap stores newVal for the key in the HamtTransient data structure
+// only if the key is in it and its current value is equal to oldVal according
+// to eq; if eq is nil see ValEqFunc. It returns a bool indicating if the value
+// was swapped.
+// Either way it returns the original HamtTransient data structure.
+func (h *HamtTransient) CompareAndSwap(
+	key KeyI,
+	oldVal, newVal interface{},
+	eq ValEqFunc,
+) (Hamt, bool) {
+	if eq == nil {
+		eq = sameVal
+	}
+
+	var hv = key.Hash()
+	var path, leaf, idx = h.find(hv)
+
+	if leaf == nil {
+		return h, false
+	}
+
+	var cur, exists = leaf.get(key)
+	if !exists || !eq(cur, oldVal) {
+		return h, false
+	}
+
+	var nh, _ = h.put(hv, key, newVal, path, leaf, idx)
+	return nh, true
+}
+
 // String returns a simple string representation of the HamtTransient data
 // structure.
 func (h *HamtTransient) String() string {
@@ -218,6 +389,29 @@ func (h *HamtTransient) Range(fn func(KeyI, interface{}) bool) {
 	h.hamtBase.Range(fn)
 }
 
+// All returns an iter.Seq2 of every KeyVal pair in the HamtTransient.
+// Breaking out of the range loop stops the traversal like returning false from
+// Range() does.
+func (h *HamtTransient) All() iter.Seq2[KeyI, interface{}] {
+	return h.hamtBase.All()
+}
+
+// Keys returns an iter.Seq of every key in the HamtTransient.
+func (h *HamtTransient) Keys() iter.Seq[KeyI] {
+	return h.hamtBase.Keys()
+}
+
+// Values returns an iter.Seq of every value in the HamtTransient.
+func (h *HamtTransient) Values() iter.Seq[interface{}] {
+	return h.hamtBase.Values()
+}
+
+// Iter returns a new Iterator positioned before the first KeyVal pair of the
+// HamtTransient. See Iterator for the rules on holding on to it.
+func (h *HamtTransient) Iter() *Iterator {
+	return h.hamtBase.Iter()
+}
+
 // Stats walks the Hamt in a pre-order traversal and populates a Stats data
 // struture which it returns.
 func (h *HamtTransient) Stats() *Stats {
@@ -0,0 +1,122 @@
+package hamt64_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numIterKvs = 100 * 1024
+
+func TestHamt64Iter(t *testing.T) {
+	var name = "TestHamt64Iter:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numIterKvs]
+
+	var h, err = buildHamt64(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var ranged []hamt64.KeyVal
+	h.Range(func(k hamt64.KeyI, v interface{}) bool {
+		ranged = append(ranged, hamt64.KeyVal{k, v})
+		return true
+	})
+
+	var i int
+	var it = h.Iter()
+	for it.Next() {
+		if i >= len(ranged) {
+			t.Fatalf("%s: Iterator returned more than %d KeyVals",
+				name, len(ranged))
+		}
+		if !it.Key().Equals(ranged[i].Key) || it.Val() != ranged[i].Val {
+			t.Fatalf("%s: Iterator KeyVal #%d, %s != Range KeyVal %s",
+				name, i, it.KeyVal(), ranged[i])
+		}
+		i++
+	}
+
+	if i != len(kvs) {
+		t.Fatalf("%s: Iterator returned %d KeyVals; expected %d",
+			name, i, len(kvs))
+	}
+
+	if it.Next() {
+		t.Fatalf("%s: exhausted Iterator returned true from Next()", name)
+	}
+
+	if hamt64.New(Functional, TableOption).Iter().Next() {
+		t.Fatalf("%s: Iterator over empty Hamt returned true", name)
+	}
+}
+
+func TestHamt64IterInterleaved(t *testing.T) {
+	var name = "TestHamt64IterInterleaved:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numIterKvs]
+
+	var h, err = buildHamt64(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var it1, it2 = h.Iter(), h.Iter()
+	var n int
+	for it1.Next() {
+		if !it2.Next() {
+			t.Fatalf("%s: it2 ended before it1 at #%d", name, n)
+		}
+		if !it1.Key().Equals(it2.Key()) {
+			t.Fatalf("%s: it1.Key(),%s != it2.Key(),%s",
+				name, it1.Key(), it2.Key())
+		}
+		n++
+	}
+	if it2.Next() {
+		t.Fatalf("%s: it2 continued after it1 ended", name)
+	}
+}
+
+func TestHamt64IterFunctionalConcurrent(t *testing.T) {
+	var name = "TestHamt64IterFunctionalConcurrent:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numIterKvs]
+
+	var h, err = buildHamt64(name, kvs[:numIterKvs/2], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var it = h.Iter()
+
+	// derive new versions while the Iterator is in use.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var nh = h
+		for _, kv := range kvs[numIterKvs/2:] {
+			nh, _ = nh.Put(kv.Key, kv.Val)
+		}
+		for _, kv := range kvs[:numIterKvs/4] {
+			nh, _, _ = nh.Del(kv.Key)
+		}
+	}()
+
+	var n uint
+	for it.Next() {
+		if _, found := h.Get(it.Key()); !found {
+			t.Errorf("%s: Iterator returned key %s not in h", name, it.Key())
+		}
+		n++
+	}
+
+	wg.Wait()
+
+	if n != h.Nentries() {
+		t.Fatalf("%s: Iterator returned %d KeyVals; h.Nentries()=%d",
+			name, n, h.Nentries())
+	}
+}
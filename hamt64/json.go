@@ -0,0 +1,299 @@
+package hamt64
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// jsonEncoder writes one Hamt, and the Hamts nested in it, as JSON.
+type jsonEncoder struct {
+	w     *bufio.Writer
+	pairs bool
+	err   error
+}
+
+// EncodeJSON writes the Hamt h to w as a JSON object, with the StringKeys of
+// h as the member names. Values that are themselves Hamts are written as
+// nested objects, every other value is written with json.Marshal.
+//
+// JSON objects only have string member names, so a Hamt with a key that is
+// not a StringKey is an error, unless pairs is true. Then such a Hamt is
+// written as an array of [key, value] arrays, with the keys written by
+// json.Marshal.
+func EncodeJSON(w io.Writer, h Hamt, pairs bool) error {
+	var e = jsonEncoder{w: bufio.NewWriter(w), pairs: pairs}
+	e.encodeHamt(h)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+func (e *jsonEncoder) write(bs []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(bs)
+	}
+}
+
+func (e *jsonEncoder) writeByte(b byte) {
+	if e.err == nil {
+		e.err = e.w.WriteByte(b)
+	}
+}
+
+// encodeValue writes a JSON encoding of v; nested Hamts are written by
+// encodeHamt so they use the same pairs option.
+func (e *jsonEncoder) encodeValue(v interface{}) {
+	if h, isHamt := v.(Hamt); isHamt {
+		e.encodeHamt(h)
+		return
+	}
+
+	var bs, err = json.Marshal(v)
+	if err != nil {
+		e.err = errors.Wrapf(err, "EncodeJSON: failed to encode %T value", v)
+		return
+	}
+	e.write(bs)
+}
+
+func (e *jsonEncoder) encodeHamt(h Hamt) {
+	if e.pairs && !hasOnlyStringKeys(h) {
+		e.encodePairs(h)
+		return
+	}
+
+	e.writeByte('{')
+	var first = true
+	h.Range(func(k KeyI, v interface{}) bool {
+		var sk, isString = k.(StringKey)
+		if !isString {
+			e.err = errors.Errorf(
+				"EncodeJSON: key %v of type %T is not a StringKey", k, k)
+			return false
+		}
+
+		if !first {
+			e.writeByte(',')
+		}
+		first = false
+
+		e.encodeValue(string(sk))
+		e.writeByte(':')
+		e.encodeValue(v)
+
+		return e.err == nil
+	})
+	e.writeByte('}')
+}
+
+func (e *jsonEncoder) encodePairs(h Hamt) {
+	e.writeByte('[')
+	var first = true
+	h.Range(func(k KeyI, v interface{}) bool {
+		if !first {
+			e.writeByte(',')
+		}
+		first = false
+
+		e.writeByte('[')
+		e.encodeValue(k)
+		e.writeByte(',')
+		e.encodeValue(v)
+		e.writeByte(']')
+
+		return e.err == nil
+	})
+	e.writeByte(']')
+}
+
+func hasOnlyStringKeys(h Hamt) bool {
+	var only = true
+	h.Range(func(k KeyI, v interface{}) bool {
+		_, only = k.(StringKey)
+		return only
+	})
+	return only
+}
+
+// jsonDecoder builds Hamts from the tokens of a json.Decoder.
+type jsonDecoder struct {
+	d          *json.Decoder
+	functional bool
+}
+
+// DecodeJSON reads a JSON object from r and returns it as a Hamt with a
+// StringKey for every member. The Hamt is a HamtFunctional if functional is
+// true, otherwise a HamtTransient, with the HybridTables option.
+//
+// Nested objects become nested Hamts of the same kind; arrays become
+// []interface{}, and numbers, strings, booleans and null become what
+// json.Unmarshal makes of them in an interface{}. So an array of [key, value]
+// pairs written by EncodeJSON is not turned back into a Hamt, as the key types
+// are not part of the JSON.
+//
+// DecodeJSON reads the object a token at a time and puts every member
+// straight into the Hamt, without building an intermediate
+// map[string]interface{}.
+func DecodeJSON(r io.Reader, functional bool) (Hamt, error) {
+	var d = jsonDecoder{
+		d:          json.NewDecoder(r),
+		functional: functional,
+	}
+
+	var tok, err = d.d.Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "DecodeJSON")
+	}
+	if tok != json.Delim('{') {
+		return nil, errors.Errorf("DecodeJSON: expected an object, got %v", tok)
+	}
+
+	h, err := d.decodeObject()
+	if err != nil {
+		return nil, errors.Wrap(err, "DecodeJSON")
+	}
+	return h, nil
+}
+
+// DecodeJSONValue reads one JSON value of any kind from r, and returns it as
+// DecodeJSON would return it as the value of a member: an object becomes a
+// Hamt, of the kind functional selects, an array becomes an []interface{}.
+func DecodeJSONValue(r io.Reader, functional bool) (interface{}, error) {
+	var d = jsonDecoder{
+		d:          json.NewDecoder(r),
+		functional: functional,
+	}
+
+	var tok, err = d.d.Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "DecodeJSONValue")
+	}
+
+	val, err := d.decodeValue(tok)
+	if err != nil {
+		return nil, errors.Wrap(err, "DecodeJSONValue")
+	}
+	return val, nil
+}
+
+// decodeObject reads the members of an object, after its opening '{', and
+// the closing '}'.
+func (d *jsonDecoder) decodeObject() (Hamt, error) {
+	var h = NewTransient(HybridTables)
+
+	for d.d.More() {
+		var tok, err = d.d.Token()
+		if err != nil {
+			return nil, err
+		}
+		var key = StringKey(tok.(string))
+
+		tok, err = d.d.Token()
+		if err != nil {
+			return nil, err
+		}
+		val, err := d.decodeValue(tok)
+		if err != nil {
+			return nil, errors.Wrapf(err, "member %q", string(key))
+		}
+
+		h.Put(key, val)
+	}
+
+	if _, err := d.d.Token(); err != nil { // '}'
+		return nil, err
+	}
+
+	if d.functional {
+		return h.Persistent(), nil
+	}
+	return h, nil
+}
+
+// decodeArray reads the elements of an array, after its opening '[', and the
+// closing ']'.
+func (d *jsonDecoder) decodeArray() ([]interface{}, error) {
+	var vals = make([]interface{}, 0)
+
+	for d.d.More() {
+		var tok, err = d.d.Token()
+		if err != nil {
+			return nil, err
+		}
+		val, err := d.decodeValue(tok)
+		if err != nil {
+			return nil, errors.Wrapf(err, "element %d", len(vals))
+		}
+		vals = append(vals, val)
+	}
+
+	if _, err := d.d.Token(); err != nil { // ']'
+		return nil, err
+	}
+
+	return vals, nil
+}
+
+// decodeValue returns the value starting with the token tok.
+func (d *jsonDecoder) decodeValue(tok json.Token) (interface{}, error) {
+	switch tok {
+	case json.Delim('{'):
+		return d.decodeObject()
+	case json.Delim('['):
+		return d.decodeArray()
+	}
+	return tok, nil
+}
+
+// MarshalJSON implements json.Marshaler with EncodeJSON, without the pairs
+// option.
+func (h *HamtFunctional) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := EncodeJSON(&buf, h, false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler with DecodeJSON. It replaces the
+// contents of h, unless data is null.
+func (h *HamtFunctional) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var nh, err = DecodeJSON(bytes.NewReader(data), true)
+	if err != nil {
+		return err
+	}
+	h.set(&nh.(*HamtFunctional).hamtBase)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler with EncodeJSON, without the pairs
+// option.
+func (h *HamtTransient) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := EncodeJSON(&buf, h, false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler with DecodeJSON. It replaces the
+// contents of h, unless data is null.
+func (h *HamtTransient) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var nh, err = DecodeJSON(bytes.NewReader(data), false)
+	if err != nil {
+		return err
+	}
+	h.set(&nh.(*HamtTransient).hamtBase)
+	return nil
+}
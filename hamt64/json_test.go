@@ -0,0 +1,234 @@
+package hamt64_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numJSONKvs = 20 * 1024
+
+// jsonValEq compares values across a JSON roundtrip, where ints come back as
+// float64s.
+func jsonValEq(a, b interface{}) bool {
+	if i, isInt := a.(int); isInt {
+		a = float64(i)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestHamt64JSON(t *testing.T) {
+	var name = "TestHamt64JSON:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numJSONKvs]
+
+	var h, err = buildHamt64(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err = hamt64.EncodeJSON(&buf, h, false); err != nil {
+		t.Fatalf("%s: failed EncodeJSON() => %s", name, err)
+	}
+
+	var m map[string]int
+	if err = json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("%s: EncodeJSON() wrote invalid JSON => %s", name, err)
+	}
+	if len(m) != len(kvs) {
+		t.Fatalf("%s: len(m) %d != len(kvs) %d", name, len(m), len(kvs))
+	}
+	for _, kv := range kvs {
+		var key = string(kv.Key.(hamt64.StringKey))
+		if val, found := m[key]; !found || val != kv.Val {
+			t.Fatalf("%s: m[%q] => %d, %t; expected %d",
+				name, key, val, found, kv.Val)
+		}
+	}
+
+	for _, functional := range []bool{true, false} {
+		var nh, err = hamt64.DecodeJSON(bytes.NewReader(buf.Bytes()),
+			functional)
+		if err != nil {
+			t.Fatalf("%s: failed DecodeJSON() => %s", name, err)
+		}
+		if _, isFunctional := nh.(*hamt64.HamtFunctional); isFunctional !=
+			functional {
+			t.Fatalf("%s: DecodeJSON(functional=%t) returned a %T",
+				name, functional, nh)
+		}
+		if !hamt64.Equal(h, nh, jsonValEq) {
+			t.Fatalf("%s: !Equal(h, DecodeJSON(EncodeJSON(h)))", name)
+		}
+	}
+}
+
+func TestHamt64JSONNested(t *testing.T) {
+	var name = "TestHamt64JSONNested:" + hamt64.TableOptionName[TableOption]
+
+	var inner = hamt64.New(Functional, TableOption)
+	inner, _ = inner.Put(hamt64.StringKey("x"), 1)
+	inner, _ = inner.Put(hamt64.StringKey("list"), []interface{}{"a", true})
+
+	var h = hamt64.New(Functional, TableOption)
+	h, _ = h.Put(hamt64.StringKey("inner"), inner)
+	h, _ = h.Put(hamt64.StringKey("nil"), nil)
+	h, _ = h.Put(hamt64.StringKey("s"), "<&>")
+
+	var doc = struct {
+		Name string
+		Hamt *hamt64.HamtFunctional
+	}{Name: "doc"}
+	switch x := h.(type) {
+	case *hamt64.HamtFunctional:
+		doc.Hamt = x
+	case *hamt64.HamtTransient:
+		doc.Hamt = x.Persistent()
+	}
+
+	var data, err = json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("%s: failed json.Marshal() => %s", name, err)
+	}
+
+	var m map[string]interface{}
+	if err = json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("%s: failed json.Unmarshal() into a map => %s", name, err)
+	}
+	var x = m["Hamt"].(map[string]interface{})["inner"].(map[string]interface{})
+	if x["x"] != float64(1) {
+		t.Fatalf("%s: nested Hamt was not encoded as an object: %s",
+			name, data)
+	}
+
+	var ndoc struct {
+		Name string
+		Hamt *hamt64.HamtFunctional
+	}
+	if err = json.Unmarshal(data, &ndoc); err != nil {
+		t.Fatalf("%s: failed json.Unmarshal() => %s", name, err)
+	}
+
+	var val, found = ndoc.Hamt.Get(hamt64.StringKey("inner"))
+	if !found {
+		t.Fatalf("%s: inner not found", name)
+	}
+	var ninner, isHamt = val.(*hamt64.HamtFunctional)
+	if !isHamt {
+		t.Fatalf("%s: inner decoded as a %T", name, val)
+	}
+	if !hamt64.Equal(inner, ninner, jsonValEq) {
+		t.Fatalf("%s: !Equal(inner, ninner)", name)
+	}
+	if val, _ = ndoc.Hamt.Get(hamt64.StringKey("s")); val != "<&>" {
+		t.Fatalf("%s: s => %v", name, val)
+	}
+	if val, found = ndoc.Hamt.Get(hamt64.StringKey("nil")); !found ||
+		val != nil {
+		t.Fatalf("%s: nil => %v, %t", name, val, found)
+	}
+}
+
+func TestHamt64JSONNonStringKeys(t *testing.T) {
+	var name = "TestHamt64JSONNonStringKeys:" +
+		hamt64.TableOptionName[TableOption]
+
+	var h = hamt64.New(Functional, TableOption)
+	h, _ = h.Put(hamt64.StringKey("a"), 1)
+	h, _ = h.Put(hamt64.Int64Key(2), "b")
+
+	var buf bytes.Buffer
+	var err = hamt64.EncodeJSON(&buf, h, false)
+	if err == nil || !strings.Contains(err.Error(), "not a StringKey") {
+		t.Fatalf("%s: EncodeJSON() of an Int64Key => %v", name, err)
+	}
+
+	buf.Reset()
+	if err = hamt64.EncodeJSON(&buf, h, true); err != nil {
+		t.Fatalf("%s: failed EncodeJSON(pairs) => %s", name, err)
+	}
+
+	var pairs [][2]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &pairs); err != nil {
+		t.Fatalf("%s: EncodeJSON(pairs) wrote %s => %s", name, buf.Bytes(), err)
+	}
+	var got = make(map[interface{}]interface{})
+	for _, p := range pairs {
+		got[p[0]] = p[1]
+	}
+	var expected = map[interface{}]interface{}{
+		"a":        float64(1),
+		float64(2): "b",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("%s: EncodeJSON(pairs) => %v; expected %v",
+			name, got, expected)
+	}
+}
+
+func TestHamt64DecodeJSONErrors(t *testing.T) {
+	var name = "TestHamt64DecodeJSONErrors:" +
+		hamt64.TableOptionName[TableOption]
+
+	for _, s := range []string{
+		``,
+		`[1, 2]`,
+		`"a"`,
+		`{"a": 1`,
+		`{"a": {"b": [1, 2}}`,
+		`{"a" 1}`,
+	} {
+		var _, err = hamt64.DecodeJSON(strings.NewReader(s), Functional)
+		if err == nil {
+			t.Fatalf("%s: DecodeJSON(%q) succeeded", name, s)
+		}
+	}
+}
+
+func TestHamt64DecodeJSONValue(t *testing.T) {
+	var name = "TestHamt64DecodeJSONValue:" +
+		hamt64.TableOptionName[TableOption]
+
+	for _, x := range []struct {
+		s   string
+		val interface{}
+	}{
+		{`"a"`, "a"},
+		{`1.5`, 1.5},
+		{`null`, nil},
+		{`[1, "b", [true]]`, []interface{}{1.0, "b", []interface{}{true}}},
+	} {
+		var r = strings.NewReader(x.s)
+		var val, err = hamt64.DecodeJSONValue(r, Functional)
+		if err != nil {
+			t.Fatalf("%s: failed DecodeJSONValue(%q) => %s", name, x.s, err)
+		}
+		if !reflect.DeepEqual(val, x.val) {
+			t.Fatalf("%s: DecodeJSONValue(%q) => %#v; expected %#v",
+				name, x.s, val, x.val)
+		}
+	}
+
+	var obj = strings.NewReader(`{"a": {"b": 1}}`)
+	var val, err = hamt64.DecodeJSONValue(obj, Functional)
+	if err != nil {
+		t.Fatalf("%s: failed DecodeJSONValue() of an object => %s", name, err)
+	}
+	var h, isHamt = val.(hamt64.Hamt)
+	if !isHamt {
+		t.Fatalf("%s: DecodeJSONValue() of an object => %T", name, val)
+	}
+	var _, isFunctional = h.(*hamt64.HamtFunctional)
+	if isFunctional != Functional {
+		t.Fatalf("%s: DecodeJSONValue(..., %t) => %T", name, Functional, h)
+	}
+
+	if _, err = hamt64.DecodeJSONValue(strings.NewReader(`[1,`),
+		Functional); err == nil {
+		t.Fatalf("%s: DecodeJSONValue() of a partial array succeeded", name)
+	}
+}
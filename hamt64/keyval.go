@@ -2,6 +2,7 @@ package hamt64
 
 import (
 	"fmt"
+	"reflect"
 )
 
 // KeyVal is a simple struct used to transfer lists ([]KeyVal) from one
@@ -14,3 +15,21 @@ type KeyVal struct {
 func (kv KeyVal) String() string {
 	return fmt.Sprintf("{%q, %v}", kv.Key, kv.Val)
 }
+
+// sameVal returns true if a and b are of the same comparable type and a == b.
+// A struct or array type with interface fields is comparable, but == panics
+// if such a field holds an uncomparable value, like a slice; sameVal returns
+// false for those instead.
+func sameVal(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	var ta = reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) || !ta.Comparable() {
+		return false
+	}
+	if !reflect.ValueOf(a).Comparable() || !reflect.ValueOf(b).Comparable() {
+		return false
+	}
+	return a == b
+}
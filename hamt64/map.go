@@ -0,0 +1,83 @@
+package hamt64
+
+// Map defines the interface that both the MapFunctional and MapTransient data
+// structures implement. It is the generic counterpart of the Hamt interface;
+// keys and values are typed end to end, so there is no KeyI wrapping of keys
+// and no interface{} boxing of values.
+//
+// A Map is built from the very same fixedTable and sparseTable interior nodes
+// as a Hamt, so the HybridTables, SparseTables, and FixedTables options behave
+// exactly the same way. Only the leafs differ, they hold a K and a V.
+type Map[K comparable, V any] interface {
+	IsEmpty() bool
+	Nentries() uint
+	ToFunctional() Map[K, V]
+	ToTransient() Map[K, V]
+	DeepCopy() Map[K, V]
+	Get(K) (V, bool)
+	Put(K, V) (Map[K, V], bool)
+	Del(K) (Map[K, V], V, bool)
+	String() string
+	LongString(string) string
+	Range(func(K, V) bool)
+	Stats() *Stats
+	walk(visitFn) bool
+}
+
+// Hasher is the function a Map uses to calculate the HashVal of its keys. It
+// must be deterministic, and keys that are == must hash to the same HashVal.
+//
+// See HashString, HashInt32, HashInt64, HashUint32, and HashUint64 for hashers
+// of the common key types.
+type Hasher[K comparable] func(K) HashVal
+
+// HashString is a Hasher for string keys. It calculates the same HashVal as
+// StringKey.Hash().
+func HashString(s string) HashVal {
+	return StringKey(s).Hash()
+}
+
+// HashInt32 is a Hasher for int32 keys. It calculates the same HashVal as
+// Int32Key.Hash().
+func HashInt32(i int32) HashVal {
+	return Int32Key(i).Hash()
+}
+
+// HashInt64 is a Hasher for int64 keys. It calculates the same HashVal as
+// Int64Key.Hash().
+func HashInt64(i int64) HashVal {
+	return Int64Key(i).Hash()
+}
+
+// HashUint32 is a Hasher for uint32 keys. It calculates the same HashVal as
+// Uint32Key.Hash().
+func HashUint32(i uint32) HashVal {
+	return Uint32Key(i).Hash()
+}
+
+// HashUint64 is a Hasher for uint64 keys. It calculates the same HashVal as
+// Uint64Key.Hash().
+func HashUint64(i uint64) HashVal {
+	return Uint64Key(i).Hash()
+}
+
+// NewMap constructs a datastructure that implements the Map interface.
+//
+// When the functional argument is true it implements a MapFunctional data
+// structure. When the functional argument is false it implements a
+// MapTransient data structure.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+//
+// The hasher argument calculates the HashVal of every key.
+func NewMap[K comparable, V any](
+	functional bool,
+	tblOpt int,
+	hasher Hasher[K],
+) Map[K, V] {
+	if functional {
+		return NewMapFunctional[K, V](tblOpt, hasher)
+	}
+	return NewMapTransient[K, V](tblOpt, hasher)
+}
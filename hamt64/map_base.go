@@ -0,0 +1,222 @@
+package hamt64
+
+import (
+	"fmt"
+)
+
+// This is here as the Map base data structure. It mirrors hamtBase, plus the
+// Hasher used to calculate the HashVal of the keys.
+type mapBase[K comparable, V any] struct {
+	root       fixedTable
+	nentries   uint
+	nograde    bool
+	startFixed bool
+	hasher     Hasher[K]
+	edit       *editToken // only used by MapTransient
+}
+
+func (h *mapBase[K, V]) init(tblOpt int, hasher Hasher[K]) {
+	h.hasher = hasher
+
+	// boolean zero value is false
+	switch tblOpt {
+	case HybridTables:
+		h.nograde = false
+	case SparseTables:
+		h.nograde = true
+	case FixedTables:
+		h.nograde = true
+		h.startFixed = true
+	}
+}
+
+// IsEmpty simply returns if the Map datastucture has no entries.
+func (h *mapBase[K, V]) IsEmpty() bool {
+	return h.nentries == 0
+}
+
+// Nentries return the number of (key,value) pairs are stored in the Map data
+// structure.
+func (h *mapBase[K, V]) Nentries() uint {
+	return h.nentries
+}
+
+// set makes h the same as o; see fixedTable.set.
+func (h *mapBase[K, V]) set(o *mapBase[K, V]) {
+	h.root.set(&o.root)
+	h.nentries = o.nentries
+	h.nograde = o.nograde
+	h.startFixed = o.startFixed
+	h.hasher = o.hasher
+	h.edit = o.edit
+}
+
+// deepCopyTo makes nb a copy of h, with a copy of every table of h.
+func (h *mapBase[K, V]) deepCopyTo(nb *mapBase[K, V]) {
+	nb.root.set(h.root.deepCopy().(*fixedTable))
+	nb.nentries = h.nentries
+	nb.nograde = h.nograde
+	nb.startFixed = h.startFixed
+	nb.hasher = h.hasher
+}
+
+func (h *mapBase[K, V]) find(hv HashVal) (tableStack, mapLeafI[K, V], uint) {
+	var curTable tableI = &h.root
+
+	var path = newTableSlice() //conforms to tableStack interface
+	var leaf mapLeafI[K, V]
+	var idx uint
+
+DepthIter:
+	for depth := uint(0); depth <= maxDepth; depth++ {
+		path.push(curTable)
+		idx = hv.Index(depth)
+		var curNode = curTable.get(idx)
+
+		switch n := curNode.(type) {
+		case nil:
+			leaf = nil
+			break DepthIter
+		case mapLeafI[K, V]:
+			leaf = n
+			break DepthIter
+		case tableI:
+			curTable = n
+		}
+	}
+
+	return path, leaf, idx
+}
+
+// Get retrieves the value related to the key in the Map data structure. It
+// also return a bool to indicate the value was found.
+func (h *mapBase[K, V]) Get(key K) (V, bool) {
+	var val V
+	var found bool
+
+	if h.IsEmpty() {
+		return val, found
+	}
+
+	var hv = h.hasher(key)
+	var curTable tableI = &h.root
+
+DepthIter:
+	for depth := uint(0); depth <= maxDepth; depth++ {
+		var idx = hv.Index(depth)
+		var curNode = curTable.get(idx) //nodeI
+
+		switch n := curNode.(type) {
+		case nil:
+			break DepthIter
+		case mapLeafI[K, V]:
+			val, found = n.get(key)
+			break DepthIter
+		case tableI:
+			curTable = n
+		}
+	}
+
+	return val, found
+}
+
+// createTable is the Map version of createFixedTable and createSparseTable.
+// Which kind of table is created depends on the table option of the Map.
+func (h *mapBase[K, V]) createTable(
+	depth uint,
+	leaf1 mapLeafI[K, V],
+	leaf2 *mapFlatLeaf[K, V],
+) tableI {
+	_ = assertOn && assertf(depth > 0, "createTable(): depth,%d < 1", depth)
+
+	var hashPath = leaf1.Hash().hashPath(depth)
+
+	var retTable tableI
+	if h.startFixed {
+		var ft = new(fixedTable)
+		ft.hashPath = hashPath
+		ft.depth = depth
+		retTable = ft
+	} else {
+		var st = new(sparseTable)
+		st.hashPath = hashPath
+		st.depth = depth
+		st.nodes = make([]nodeI, 0, sparseTableInitCap)
+		retTable = st
+	}
+
+	var idx1 = leaf1.Hash().Index(depth)
+	var idx2 = leaf2.Hash().Index(depth)
+	if idx1 != idx2 {
+		retTable.insert(idx1, leaf1)
+		retTable.insert(idx2, leaf2)
+	} else { //idx1 == idx2
+		var node nodeI
+		if depth == maxDepth {
+			node = newMapCollisionLeaf(leaf1.Hash(),
+				append(leaf1.keyVals(), leaf2.keyVals()...))
+		} else {
+			node = h.createTable(depth+1, leaf1, leaf2)
+		}
+		retTable.insert(idx1, node)
+	}
+
+	return retTable
+}
+
+// String returns a string representation of the mapBase stastructure.
+func (h *mapBase[K, V]) String() string {
+	return fmt.Sprintf(
+		"mapBase{ nentries: %d, root: %s }",
+		h.nentries,
+		h.root.String(),
+	)
+}
+
+// LongString returns a complete recusive listing of the entire mapBase
+// data structure.
+func (h *mapBase[K, V]) LongString(indent string) string {
+	var str string
+
+	str = indent +
+		fmt.Sprintf("mapBase{ nentries: %d, root:\n", h.nentries)
+	str += indent + h.root.LongString(indent, 0)
+	str += indent + "} //mapBase"
+
+	return str
+}
+
+// walk traverses the Trie in pre-order traversal. For a Trie this is also a
+// in-order traversal of all leaf nodes.
+//
+// walk returns false if the traversal stopped early.
+func (h *mapBase[K, V]) walk(fn visitFn) bool {
+	return h.root.visit(fn)
+}
+
+// Range executes the given function for every key,value pair in the Map. The
+// pairs are visited in a seeminly random order; see Hamt.Range().
+func (h *mapBase[K, V]) Range(fn func(K, V) bool) {
+	var visitLeafs = func(n nodeI) bool {
+		var keepOn = true
+
+		if x, isLeaf := n.(mapLeafI[K, V]); isLeaf {
+			for _, kv := range x.keyVals() {
+				if !fn(kv.key, kv.val) {
+					keepOn = false
+					break //for
+				}
+			}
+		}
+
+		return keepOn
+	}
+
+	h.walk(visitLeafs)
+}
+
+// Stats walks the Map in a pre-order traversal and populates a Stats data
+// struture which it returns.
+func (h *mapBase[K, V]) Stats() *Stats {
+	return calcStats(&h.root)
+}
@@ -0,0 +1,239 @@
+package hamt64
+
+// MapFunctional is the generic counterpart of HamtFunctional. Put() and Del()
+// are copy-on-write, the original MapFunctional isn't modified and a slightly
+// modified copy is returned. So sharing this data structure between threads is
+// safe.
+type MapFunctional[K comparable, V any] struct {
+	mapBase[K, V]
+}
+
+// NewMapFunctional constructs a new MapFunctional data structure.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+//
+// The hasher argument calculates the HashVal of every key.
+func NewMapFunctional[K comparable, V any](
+	tblOpt int,
+	hasher Hasher[K],
+) *MapFunctional[K, V] {
+	var h = new(MapFunctional[K, V])
+
+	h.mapBase.init(tblOpt, hasher)
+
+	return h
+}
+
+// ToFunctional does nothing to a MapFunctional pointer. This method
+// only here for conformance with the Map interface.
+func (h *MapFunctional[K, V]) ToFunctional() Map[K, V] {
+	return h
+}
+
+// ToTransient returns a MapTransient with the contents of the MapFunctional,
+// in constant time. Like HamtFunctional.ToTransient, the two share every
+// table, and the MapTransient copies a table the first time it modifies it,
+// so modifying it never changes the MapFunctional.
+func (h *MapFunctional[K, V]) ToTransient() Map[K, V] {
+	var nh = new(MapTransient[K, V])
+	nh.set(&h.mapBase)
+	nh.edit = new(editToken)
+	return nh
+}
+
+// DeepCopy copies the MapFunctional data structure and every table it
+// contains recursively.
+func (h *MapFunctional[K, V]) DeepCopy() Map[K, V] {
+	var nh = new(MapFunctional[K, V])
+	h.mapBase.deepCopyTo(&nh.mapBase)
+	return nh
+}
+
+// persist() is ONLY called on a fresh copy of the current Map.
+// Hence, modifying it is allowed.
+func (h *MapFunctional[K, V]) persist(
+	oldTable, newTable tableI,
+	path tableStack,
+) {
+	_ = assertOn && assert(path.len() != 0,
+		"path.len()==0; This case should be handled directly in Put & Del.")
+
+	var depth = uint(path.len()) //guaranteed depth > 0
+	var parentDepth = depth - 1
+
+	var parentIdx = oldTable.Hash().Index(parentDepth)
+
+	var oldParent = path.pop()
+
+	var newParent tableI
+	if path.len() == 0 {
+		h.root.set(oldParent.(*fixedTable))
+		newParent = &h.root
+	} else {
+		newParent = oldParent.copy()
+	}
+
+	if newTable == nil {
+		newParent.remove(parentIdx)
+	} else {
+		newParent.replace(parentIdx, newTable)
+	}
+
+	if path.len() > 0 {
+		h.persist(oldParent, newParent, path)
+	}
+}
+
+// Put stores a new (key,value) pair in the MapFunctional data structure. It
+// returns a bool indicating if a new pair was added (true) or if the value
+// replaced (false). Either way it returns a new MapFunctional data structure
+// containing the modification.
+func (h *MapFunctional[K, V]) Put(key K, val V) (Map[K, V], bool) {
+	var nh = new(MapFunctional[K, V])
+	nh.set(&h.mapBase)
+
+	var hv = h.hasher(key)
+
+	var path, leaf, idx = h.find(hv)
+
+	var curTable = path.pop()
+	var depth = uint(path.len())
+
+	var added bool
+
+	if curTable == &h.root {
+		//copying all h.root into nh.root already done in *nh = *h
+		if leaf == nil {
+			nh.root.insert(idx, newMapFlatLeaf(hv, key, val))
+			added = true
+		} else {
+			var node nodeI
+			if leaf.Hash() == hv {
+				node, added = leaf.put(hv, key, val)
+			} else {
+				node = nh.createTable(depth+1, leaf,
+					newMapFlatLeaf(hv, key, val))
+				added = true
+			}
+
+			nh.root.replace(idx, node)
+		}
+	} else {
+		var newTable tableI
+
+		if leaf == nil {
+			if !nh.nograde && (curTable.nentries()+1) == UpgradeThreshold {
+				newTable = upgradeToFixedTable(
+					curTable.Hash(), depth, curTable.entries())
+			} else {
+				newTable = curTable.copy()
+			}
+
+			newTable.insert(idx, newMapFlatLeaf(hv, key, val))
+			added = true
+		} else {
+			newTable = curTable.copy()
+
+			var node nodeI
+			if leaf.Hash() == hv {
+				node, added = leaf.put(hv, key, val)
+			} else {
+				node = nh.createTable(depth+1, leaf,
+					newMapFlatLeaf(hv, key, val))
+				added = true
+			}
+
+			newTable.replace(idx, node)
+		}
+
+		nh.persist(curTable, newTable, path)
+	}
+
+	if added {
+		nh.nentries++
+	}
+
+	return nh, added
+}
+
+// Del searches the MapFunctional for the key argument and returns three
+// values: a Map interface, a value, and a bool.
+//
+// If the key was found then the bool returned is true and the value is the
+// value related to that key and the returned Map is the new MapFunctional
+// data structure pointer.
+//
+// If key was not found, then the bool is false, the value is the zero value of
+// V, and the Map value is the original MapFunctional data structure pointer.
+func (h *MapFunctional[K, V]) Del(key K) (Map[K, V], V, bool) {
+	var zero V
+
+	if h.IsEmpty() {
+		return h, zero, false
+	}
+
+	var hv = h.hasher(key)
+	var path, leaf, idx = h.find(hv)
+
+	if leaf == nil {
+		return h, zero, false
+	}
+
+	var newLeaf, val, deleted = leaf.del(key)
+
+	if !deleted {
+		return h, zero, false
+	}
+
+	var curTable = path.pop()
+	var depth = uint(path.len())
+
+	var nh = new(MapFunctional[K, V])
+	nh.set(&h.mapBase)
+
+	nh.nentries--
+
+	if curTable == &h.root {
+		//copying all h.root into nh.root already done in *nh = *h
+		if newLeaf == nil { //leaf was a mapFlatLeaf
+			nh.root.remove(idx)
+		} else { //leaf was a mapCollisionLeaf
+			nh.root.replace(idx, newLeaf)
+		}
+	} else {
+		var newTable = curTable.copy()
+
+		if newLeaf == nil { //leaf was a mapFlatLeaf
+			newTable.remove(idx)
+
+			// Side-Effects of removing a key,value from the table
+			var nents = newTable.nentries()
+			switch {
+			case nents == 0:
+				newTable = nil
+			case !h.nograde && nents == DowngradeThreshold:
+				newTable = downgradeToSparseTable(
+					newTable.Hash(), depth, newTable.entries())
+			}
+		} else { //leaf was a mapCollisionLeaf
+			newTable.replace(idx, newLeaf)
+		}
+
+		nh.persist(curTable, newTable, path)
+	}
+
+	return nh, val, deleted
+}
+
+// String returns a simple string representation of the MapFunctional data
+// structure.
+func (h *MapFunctional[K, V]) String() string {
+	return "MapFunctional{" + h.mapBase.String() + "}"
+}
+
+// LongString returns a complete recusive listing of the entire MapFunctional
+// data structure.
+func (h *MapFunctional[K, V]) LongString(indent string) string {
+	return "MapFunctional{\n" + indent + h.mapBase.LongString(indent) + "\n}"
+}
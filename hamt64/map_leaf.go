@@ -0,0 +1,194 @@
+package hamt64
+
+import (
+	"fmt"
+	"strings"
+)
+
+// mapKeyVal is the typed version of KeyVal used inside the Map leafs.
+type mapKeyVal[K comparable, V any] struct {
+	key K
+	val V
+}
+
+func (kv mapKeyVal[K, V]) String() string {
+	return fmt.Sprintf("{%v, %v}", kv.key, kv.val)
+}
+
+// mapLeafI is the Map version of leafI. The leafs store the HashVal of their
+// key, because a generic key has no Hash() method to call.
+type mapLeafI[K comparable, V any] interface {
+	nodeI
+
+	get(key K) (V, bool)
+	put(hv HashVal, key K, val V) (mapLeafI[K, V], bool)
+	del(key K) (mapLeafI[K, V], V, bool)
+	keyVals() []mapKeyVal[K, V]
+}
+
+// implements nodeI
+// implements mapLeafI
+type mapFlatLeaf[K comparable, V any] struct {
+	hv  HashVal
+	key K
+	val V
+}
+
+func newMapFlatLeaf[K comparable, V any](
+	hv HashVal,
+	key K,
+	val V,
+) *mapFlatLeaf[K, V] {
+	var fl = new(mapFlatLeaf[K, V])
+	fl.hv = hv
+	fl.key = key
+	fl.val = val
+	return fl
+}
+
+func (l *mapFlatLeaf[K, V]) Hash() HashVal {
+	return l.hv
+}
+
+func (l *mapFlatLeaf[K, V]) String() string {
+	return fmt.Sprintf("mapFlatLeaf{key: %v, val: %v}", l.key, l.val)
+}
+
+func (l *mapFlatLeaf[K, V]) get(key K) (V, bool) {
+	if l.key == key {
+		return l.val, true
+	}
+	var zero V
+	return zero, false
+}
+
+// put maintains the functional behavior that any modification returns a new
+// leaf and the original remains unaltered.
+func (l *mapFlatLeaf[K, V]) put(hv HashVal, key K, val V) (mapLeafI[K, V], bool) {
+	if l.key == key {
+		return newMapFlatLeaf(l.hv, l.key, val), false //replaced
+	}
+
+	var nl = newMapCollisionLeaf(l.hv,
+		[]mapKeyVal[K, V]{{l.key, l.val}, {key, val}})
+	return nl, true // key,val was added
+}
+
+func (l *mapFlatLeaf[K, V]) del(key K) (mapLeafI[K, V], V, bool) {
+	if l.key == key {
+		return nil, l.val, true //found
+	}
+	var zero V
+	return l, zero, false //not found
+}
+
+func (l *mapFlatLeaf[K, V]) keyVals() []mapKeyVal[K, V] {
+	return []mapKeyVal[K, V]{{l.key, l.val}}
+}
+
+func (l *mapFlatLeaf[K, V]) nkeyvals() uint {
+	return 1
+}
+
+func (l *mapFlatLeaf[K, V]) visit(fn visitFn) bool {
+	return fn(l)
+}
+
+// implements nodeI
+// implements mapLeafI
+type mapCollisionLeaf[K comparable, V any] struct {
+	hv  HashVal
+	kvs []mapKeyVal[K, V]
+}
+
+func newMapCollisionLeaf[K comparable, V any](
+	hv HashVal,
+	kvs []mapKeyVal[K, V],
+) *mapCollisionLeaf[K, V] {
+	var leaf = new(mapCollisionLeaf[K, V])
+	leaf.hv = hv
+	leaf.kvs = append(leaf.kvs, kvs...)
+	return leaf
+}
+
+func (l *mapCollisionLeaf[K, V]) copy() *mapCollisionLeaf[K, V] {
+	return newMapCollisionLeaf(l.hv, l.kvs)
+}
+
+func (l *mapCollisionLeaf[K, V]) Hash() HashVal {
+	return l.hv
+}
+
+func (l *mapCollisionLeaf[K, V]) String() string {
+	var kvstrs = make([]string, len(l.kvs))
+	for i := 0; i < len(l.kvs); i++ {
+		kvstrs[i] = l.kvs[i].String()
+	}
+	var jkvstr = strings.Join(kvstrs, ",")
+
+	return fmt.Sprintf("mapCollisionLeaf{hash:%s, kvs:[]mapKeyVal{%s}}",
+		l.hv, jkvstr)
+}
+
+func (l *mapCollisionLeaf[K, V]) get(key K) (V, bool) {
+	for _, kv := range l.kvs {
+		if kv.key == key {
+			return kv.val, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+func (l *mapCollisionLeaf[K, V]) put(
+	hv HashVal,
+	key K,
+	val V,
+) (mapLeafI[K, V], bool) {
+	for i, kv := range l.kvs {
+		if kv.key == key {
+			var nl = l.copy()
+			nl.kvs[i].val = val
+			return nl, false //replaced
+		}
+	}
+	var nl = new(mapCollisionLeaf[K, V])
+	nl.hv = l.hv
+	nl.kvs = make([]mapKeyVal[K, V], len(l.kvs)+1)
+	copy(nl.kvs, l.kvs)
+	nl.kvs[len(l.kvs)] = mapKeyVal[K, V]{key, val}
+
+	return nl, true // key,val was added
+}
+
+func (l *mapCollisionLeaf[K, V]) del(key K) (mapLeafI[K, V], V, bool) {
+	for i, kv := range l.kvs {
+		if kv.key == key {
+			var nl mapLeafI[K, V]
+			if len(l.kvs) == 2 {
+				nl = newMapFlatLeaf(l.hv, l.kvs[1-i].key, l.kvs[1-i].val)
+			} else {
+				var cl = l.copy()
+				cl.kvs = append(cl.kvs[:i], cl.kvs[i+1:]...)
+				nl = cl
+			}
+			return nl, kv.val, true
+		}
+	}
+	var zero V
+	return l, zero, false
+}
+
+func (l *mapCollisionLeaf[K, V]) keyVals() []mapKeyVal[K, V] {
+	var r = make([]mapKeyVal[K, V], 0, len(l.kvs))
+	r = append(r, l.kvs...)
+	return r
+}
+
+func (l *mapCollisionLeaf[K, V]) nkeyvals() uint {
+	return uint(len(l.kvs))
+}
+
+func (l *mapCollisionLeaf[K, V]) visit(fn visitFn) bool {
+	return fn(l)
+}
@@ -0,0 +1,194 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numMapSVS = 100 * 1024
+
+func buildMap64(svs []StrVal, functional bool, tblOpt int) hamt64.Map[string, int] {
+	var m = hamt64.NewMap[string, int](functional, tblOpt, hamt64.HashString)
+	for _, sv := range svs {
+		m, _ = m.Put(sv.Str, sv.Val.(int))
+	}
+	return m
+}
+
+func TestMap64PutGetDel(t *testing.T) {
+	var name = "TestMap64PutGetDel:" + hamt64.TableOptionName[TableOption]
+	var svs = SVS[:numMapSVS]
+
+	var m = hamt64.NewMap[string, int](Functional, TableOption, hamt64.HashString)
+	for _, sv := range svs {
+		var added bool
+		m, added = m.Put(sv.Str, sv.Val.(int))
+		if !added {
+			t.Fatalf("%s: failed to m.Put(%q, %d)", name, sv.Str, sv.Val)
+		}
+	}
+
+	if m.Nentries() != uint(len(svs)) {
+		t.Fatalf("%s: m.Nentries(),%d != len(svs),%d",
+			name, m.Nentries(), len(svs))
+	}
+
+	if stats := m.Stats(); stats.KeyVals != uint(len(svs)) {
+		t.Fatalf("%s: stats.KeyVals,%d != len(svs),%d",
+			name, stats.KeyVals, len(svs))
+	}
+
+	for _, sv := range svs {
+		var val, found = m.Get(sv.Str)
+		if !found {
+			t.Fatalf("%s: failed to m.Get(%q)", name, sv.Str)
+		}
+		if val != sv.Val.(int) {
+			t.Fatalf("%s: m.Get(%q) val,%d != %d", name, sv.Str, val, sv.Val)
+		}
+	}
+
+	var added bool
+	m, added = m.Put(svs[0].Str, -1)
+	if added {
+		t.Fatalf("%s: replacing m.Put(%q, -1) returned added", name, svs[0].Str)
+	}
+	if val, _ := m.Get(svs[0].Str); val != -1 {
+		t.Fatalf("%s: m.Get(%q) val,%d != -1", name, svs[0].Str, val)
+	}
+
+	for _, sv := range svs {
+		var deleted bool
+		m, _, deleted = m.Del(sv.Str)
+		if !deleted {
+			t.Fatalf("%s: failed to m.Del(%q)", name, sv.Str)
+		}
+		if _, found := m.Get(sv.Str); found {
+			t.Fatalf("%s: m.Get(%q) found after m.Del()", name, sv.Str)
+		}
+	}
+
+	if !m.IsEmpty() {
+		t.Fatalf("%s: m.IsEmpty() false after deleting every entry", name)
+	}
+}
+
+func TestMap64Range(t *testing.T) {
+	var name = "TestMap64Range:" + hamt64.TableOptionName[TableOption]
+	var svs = SVS[:numMapSVS]
+
+	var m = buildMap64(svs, Functional, TableOption)
+
+	var seen = make(map[string]int, len(svs))
+	m.Range(func(k string, v int) bool {
+		seen[k] = v
+		return true
+	})
+
+	if len(seen) != len(svs) {
+		t.Fatalf("%s: len(seen),%d != len(svs),%d", name, len(seen), len(svs))
+	}
+	for _, sv := range svs {
+		if seen[sv.Str] != sv.Val.(int) {
+			t.Fatalf("%s: seen[%q],%d != %d",
+				name, sv.Str, seen[sv.Str], sv.Val)
+		}
+	}
+
+	var count int
+	m.Range(func(k string, v int) bool {
+		count++
+		return count < 10
+	})
+	if count != 10 {
+		t.Fatalf("%s: Range did not stop early; count,%d != 10", name, count)
+	}
+}
+
+// TestMap64ToTransientIsolated checks that writes through the MapTransient of
+// a MapFunctional, before and after ToFunctional, leave it unchanged.
+func TestMap64ToTransientIsolated(t *testing.T) {
+	var name = "TestMap64ToTransientIsolated:" +
+		hamt64.TableOptionName[TableOption]
+	var svs = SVS[:numMapSVS/4]
+	var half = len(svs) / 2
+
+	var m0 = buildMap64(svs[:half], true, TableOption)
+
+	var checkM0 = func(when string) {
+		if m0.Nentries() != uint(half) {
+			t.Fatalf("%s: m0.Nentries(),%d != %d %s",
+				name, m0.Nentries(), half, when)
+		}
+		for _, sv := range svs[:half] {
+			if v, found := m0.Get(sv.Str); !found || v != sv.Val.(int) {
+				t.Fatalf("%s: m0.Get(%q) => %d, %t %s",
+					name, sv.Str, v, found, when)
+			}
+		}
+	}
+
+	// replace and delete a quarter of the keys each, and add as many again
+	var tm = m0.ToTransient()
+	for _, sv := range svs[:half/4] {
+		tm.Put(sv.Str, -sv.Val.(int))
+	}
+	for _, sv := range svs[half/4 : half/2] {
+		tm.Del(sv.Str)
+	}
+	for _, sv := range svs[half:] {
+		tm.Put(sv.Str, sv.Val.(int))
+	}
+	checkM0("after writes through m0.ToTransient()")
+
+	var m1 = tm.ToFunctional()
+	var n1 = uint(len(svs) - half/4)
+	if m1.Nentries() != n1 || tm.Nentries() != n1 {
+		t.Fatalf("%s: m1.Nentries(),%d tm.Nentries(),%d != %d",
+			name, m1.Nentries(), tm.Nentries(), n1)
+	}
+
+	// the transient keeps working after ToFunctional, without touching m1
+	for _, sv := range svs {
+		tm.Put(sv.Str, 0)
+	}
+	checkM0("after writes through tm after ToFunctional()")
+	for i, sv := range svs {
+		var v, found = m1.Get(sv.Str)
+		var expected = sv.Val.(int)
+		if i < half/4 {
+			expected = -expected
+		}
+		if found != (i < half/4 || i >= half/2) || found && v != expected {
+			t.Fatalf("%s: m1.Get(%q) => %d, %t", name, sv.Str, v, found)
+		}
+	}
+}
+
+func TestMap64Persistent(t *testing.T) {
+	var name = "TestMap64Persistent:" + hamt64.TableOptionName[TableOption]
+	var svs = SVS[:numMapSVS]
+
+	var m0 = buildMap64(svs, true, TableOption)
+
+	var m1 = m0
+	for _, sv := range svs[:len(svs)/2] {
+		m1, _, _ = m1.Del(sv.Str)
+	}
+
+	if m0.Nentries() != uint(len(svs)) {
+		t.Fatalf("%s: m0.Nentries(),%d != %d", name, m0.Nentries(), len(svs))
+	}
+	for _, sv := range svs {
+		if _, found := m0.Get(sv.Str); !found {
+			t.Fatalf("%s: m0.Get(%q) not found after deriving m1", name, sv.Str)
+		}
+	}
+	for i, sv := range svs {
+		var _, found = m1.Get(sv.Str)
+		if found != (i >= len(svs)/2) {
+			t.Fatalf("%s: m1.Get(%q) found=%t", name, sv.Str, found)
+		}
+	}
+}
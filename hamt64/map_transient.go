@@ -0,0 +1,195 @@
+package hamt64
+
+// MapTransient is the generic counterpart of HamtTransient. All modifications
+// are done in-place. So sharing this datastruture between threads is NOT safe
+// unless you were to implement a locking stategy CORRECTLY.
+type MapTransient[K comparable, V any] struct {
+	mapBase[K, V]
+}
+
+// NewMapTransient constructs a new MapTransient data structure.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+//
+// The hasher argument calculates the HashVal of every key.
+func NewMapTransient[K comparable, V any](
+	tblOpt int,
+	hasher Hasher[K],
+) *MapTransient[K, V] {
+	var h = new(MapTransient[K, V])
+
+	h.mapBase.init(tblOpt, hasher)
+
+	return h
+}
+
+// ToFunctional returns a MapFunctional with the contents of the MapTransient
+// underneath the Map interface, in constant time. Like
+// HamtTransient.ToFunctional, the MapFunctional shares every table, and the
+// MapTransient stays usable: it takes a new editToken, and copies a shared
+// table the first time it modifies it.
+func (h *MapTransient[K, V]) ToFunctional() Map[K, V] {
+	var nh = new(MapFunctional[K, V])
+	nh.set(&h.mapBase)
+	nh.edit = nil
+	h.edit = new(editToken)
+	return nh
+}
+
+// ToTransient does nothing to a MapTransient pointer. This method
+// only here for conformance with the Map interface.
+func (h *MapTransient[K, V]) ToTransient() Map[K, V] {
+	return h
+}
+
+// DeepCopy copies the MapTransient data structure and every table it
+// contains recursively.
+func (h *MapTransient[K, V]) DeepCopy() Map[K, V] {
+	var nh = new(MapTransient[K, V])
+	h.mapBase.deepCopyTo(&nh.mapBase)
+	nh.edit = new(editToken)
+	nh.edit.own(&nh.root)
+	return nh
+}
+
+// editable makes sure the MapTransient owns every table in the path find()
+// returned for hv, so they can be modified in-place; see editToken.claim.
+func (h *MapTransient[K, V]) editable(hv HashVal, path tableStack) {
+	if h.edit == nil {
+		h.edit = new(editToken)
+	}
+	h.edit.claim(hv, path)
+}
+
+// Put stores a new (key,value) pair in the MapTransient data structure. It
+// returns a bool indicating if a new pair were added or if the value replaced
+// the value in a previously stored (key,value) pair. Either way it returns the
+// original MapTransient data structure containing the modification.
+func (h *MapTransient[K, V]) Put(key K, val V) (Map[K, V], bool) {
+	var hv = h.hasher(key)
+	var path, leaf, idx = h.find(hv)
+
+	h.editable(hv, path)
+
+	var curTable = path.pop()
+	var depth = uint(path.len())
+	var added bool
+
+	if leaf == nil {
+		//check if upgrading allowed & if it is required
+		if !h.nograde && curTable != &h.root &&
+			(curTable.nentries()+1) == UpgradeThreshold {
+			var newTable = upgradeToFixedTable(
+				curTable.Hash(), depth, curTable.entries())
+			newTable.setOwner(h.edit)
+
+			var parentTable = path.peek()
+			var parentIdx = hv.Index(depth - 1)
+			parentTable.replace(parentIdx, newTable)
+
+			curTable = newTable
+		}
+		curTable.insert(idx, newMapFlatLeaf(hv, key, val))
+		added = true
+	} else {
+		if leaf.Hash() == hv {
+			var newLeaf mapLeafI[K, V]
+			newLeaf, added = leaf.put(hv, key, val)
+			curTable.replace(idx, newLeaf)
+		} else {
+			var t = h.createTable(depth+1, leaf, newMapFlatLeaf(hv, key, val))
+			h.edit.own(t)
+			curTable.replace(idx, t)
+			added = true
+		}
+	}
+
+	if added {
+		h.nentries++
+	}
+
+	return h, added
+}
+
+// Del searches the MapTransient for the key argument and returns three
+// values: a Map data structure, a value, and a bool.
+//
+// If the key was found, then the bool returned is true and the value is the
+// value related to that key.
+//
+// If key was not found, then the bool returned is false and the value is the
+// zero value of V.
+//
+// In either case, the Map value is the original MapTransient pointer as a
+// Map interface.
+func (h *MapTransient[K, V]) Del(key K) (Map[K, V], V, bool) {
+	var zero V
+
+	if h.IsEmpty() {
+		return h, zero, false
+	}
+
+	var hv = h.hasher(key)
+	var path, leaf, idx = h.find(hv)
+
+	if leaf == nil {
+		return h, zero, false
+	}
+
+	var newLeaf, val, deleted = leaf.del(key)
+
+	if !deleted {
+		return h, zero, false
+	}
+
+	h.editable(hv, path)
+
+	var curTable = path.pop()
+	var depth = uint(path.len())
+
+	h.nentries--
+
+	if newLeaf != nil { //leaf was a mapCollisionLeaf
+		curTable.replace(idx, newLeaf)
+	} else { //leaf was a mapFlatLeaf
+		curTable.remove(idx)
+
+		// Side-Effects of removing an key,value from the table
+		if curTable != &h.root {
+			switch {
+			// if no entries left in table need to colapse down to parent
+			case curTable.nentries() == 1:
+				var lastNode = curTable.entries()[0].node
+				if _, isLeaf := lastNode.(mapLeafI[K, V]); isLeaf {
+					var parentTable = path.peek()
+					var parentIdx = hv.Index(depth - 1)
+					parentTable.replace(parentIdx, lastNode)
+				}
+
+				// else check if downgrade allowed and required
+			case !h.nograde && curTable.nentries() == DowngradeThreshold:
+				var newTable = downgradeToSparseTable(
+					curTable.Hash(), depth, curTable.entries())
+				newTable.setOwner(h.edit)
+				var parentTable = path.peek()
+				var parentIdx = hv.Index(depth - 1)
+				parentTable.replace(parentIdx, newTable)
+			}
+		}
+	}
+
+	return h, val, deleted
+}
+
+// String returns a simple string representation of the MapTransient data
+// structure.
+func (h *MapTransient[K, V]) String() string {
+	return "MapTransient{" + h.mapBase.String() + "}"
+}
+
+// LongString returns a complete recusive listing of the entire MapTransient
+// data structure.
+func (h *MapTransient[K, V]) LongString(indent string) string {
+	return "MapTransient{\n" + indent + h.mapBase.LongString(indent) + "\n}"
+}
@@ -0,0 +1,537 @@
+package hamt64
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"os"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// The file format written by WriteMapped is:
+//
+//	header   "HAMTMMAP" version:uint32 hashSize:byte NumIndexBits:byte 0 0
+//	nodes    ...
+//	trailer  root:uint64 nentries:uint64 "HAMTMMAP"
+//
+// with every integer little-endian. The nodes are the tables and leafs of the
+// Hamt, each child written before its parent, so the root table is the last
+// node and the trailer can be written without seeking back:
+//
+//	table    'T' bitmap:[bitmapSize]uint32 offsets:[popcount(bitmap)]uint64
+//	leaf     'L' n:uint32 n*(klen:uint32 key vlen:uint32 val)
+//
+// The bitmap of a table has a bit set for every index with a child, and the
+// offsets of the children are in index order, just like a sparseTable. An
+// offset is counted from the start of the file.
+//
+// The trie depends on the HashVal size and NumIndexBits, so a file can only
+// be read by the package that wrote it.
+
+const mappedMagic = "HAMTMMAP"
+
+const mappedVersion = 1
+
+const (
+	mappedHeaderSize  = len(mappedMagic) + 8
+	mappedTrailerSize = 16 + len(mappedMagic)
+)
+
+const (
+	mappedTableTag = 'T'
+	mappedLeafTag  = 'L'
+)
+
+// mappedWriter writes the nodes of a Hamt, keeping track of the offset.
+type mappedWriter struct {
+	w   *bufio.Writer
+	off uint64
+	buf []byte
+	err error
+}
+
+// WriteMapped writes the Hamt h to w in the file format read by OpenMapped
+// and NewMappedHamt. Every key must be a ByteSliceKey or a StringKey, and
+// every value a []byte or a string; they are all written as bytes.
+//
+// The tables are written as they are in h, so the trie in the file is the
+// one that h.Get() walks.
+func WriteMapped(w io.Writer, h Hamt) error {
+	var mw = mappedWriter{w: bufio.NewWriter(w)}
+
+	mw.buf = append(mw.buf[:0], mappedMagic...)
+	mw.buf = binary.LittleEndian.AppendUint32(mw.buf, mappedVersion)
+	mw.buf = append(mw.buf, byte(hashSize), byte(NumIndexBits), 0, 0)
+	mw.write(mw.buf)
+
+	var root = mw.writeTable(&hamtBaseOf(h).root)
+
+	mw.buf = binary.LittleEndian.AppendUint64(mw.buf[:0], root)
+	mw.buf = binary.LittleEndian.AppendUint64(mw.buf, uint64(h.Nentries()))
+	mw.buf = append(mw.buf, mappedMagic...)
+	mw.write(mw.buf)
+
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+func (mw *mappedWriter) write(bs []byte) {
+	if mw.err == nil {
+		var n int
+		n, mw.err = mw.w.Write(bs)
+		mw.off += uint64(n)
+	}
+}
+
+// writeTable writes the children of t, then t, and returns the offset of t.
+func (mw *mappedWriter) writeTable(t tableI) uint64 {
+	var bm bitmap
+	var offs = make([]uint64, 0, IndexLimit)
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var n = t.get(idx)
+		if n == nil {
+			continue
+		}
+		bm.Set(idx)
+		switch x := n.(type) {
+		case tableI:
+			offs = append(offs, mw.writeTable(x))
+		case leafI:
+			offs = append(offs, mw.writeLeaf(x))
+		}
+	}
+
+	var off = mw.off
+	mw.buf = append(mw.buf[:0], mappedTableTag)
+	for _, word := range bm {
+		mw.buf = binary.LittleEndian.AppendUint32(mw.buf, word)
+	}
+	for _, o := range offs {
+		mw.buf = binary.LittleEndian.AppendUint64(mw.buf, o)
+	}
+	mw.write(mw.buf)
+
+	return off
+}
+
+// writeLeaf writes l and returns its offset.
+func (mw *mappedWriter) writeLeaf(l leafI) uint64 {
+	var off = mw.off
+	var kvs = l.keyVals()
+
+	mw.buf = append(mw.buf[:0], mappedLeafTag)
+	mw.buf = binary.LittleEndian.AppendUint32(mw.buf, uint32(len(kvs)))
+	for _, kv := range kvs {
+		var kbs, vbs []byte
+		switch k := kv.Key.(type) {
+		case ByteSliceKey:
+			kbs = k
+		case StringKey:
+			kbs = []byte(k)
+		default:
+			mw.fail(errors.Errorf(
+				"WriteMapped: key %v of type %T is not a ByteSliceKey "+
+					"or a StringKey", kv.Key, kv.Key))
+			return 0
+		}
+		switch v := kv.Val.(type) {
+		case []byte:
+			vbs = v
+		case string:
+			vbs = []byte(v)
+		default:
+			mw.fail(errors.Errorf(
+				"WriteMapped: value of key %v of type %T is not a []byte "+
+					"or a string", kv.Key, kv.Val))
+			return 0
+		}
+		if len(kbs) > math.MaxUint32 || len(vbs) > math.MaxUint32 {
+			mw.fail(errors.Errorf("WriteMapped: key or value of key %v "+
+				"is too long", kv.Key))
+			return 0
+		}
+
+		mw.buf = binary.LittleEndian.AppendUint32(mw.buf, uint32(len(kbs)))
+		mw.buf = append(mw.buf, kbs...)
+		mw.buf = binary.LittleEndian.AppendUint32(mw.buf, uint32(len(vbs)))
+		mw.buf = append(mw.buf, vbs...)
+	}
+	mw.write(mw.buf)
+
+	return off
+}
+
+func (mw *mappedWriter) fail(err error) {
+	if mw.err == nil {
+		mw.err = err
+	}
+}
+
+// MappedHamt is a read-only Hamt in the file format written by WriteMapped.
+// Get and Range read the tables and leafs straight from the bytes of the
+// file, walking the trie by HashVal.Index() just like hamtBase.Get(), so
+// opening a MappedHamt takes no time and no memory beyond the mapping, which
+// the operating system can share between every process that maps the file.
+//
+// Keys are returned as ByteSliceKeys and values as []bytes. Both point into
+// the mapped bytes; they must not be modified, and are only valid until
+// Close.
+//
+// Every offset and length read from the file is checked against its size,
+// so a corrupt file can not crash the process. Get and Range take a corrupt
+// node for a missing one, and Err returns the error of the first one they
+// came across. A file that is modified while it is mapped can still crash
+// the process.
+type MappedHamt struct {
+	data     []byte
+	root     uint64
+	end      uint64 // of the nodes; the offset of the trailer
+	nentries uint
+	unmap    func([]byte) error
+	err      atomic.Pointer[error] // the first corrupt node found
+}
+
+// OpenMapped maps the file at path, written by WriteMapped, read-only into
+// memory and returns a MappedHamt reading from it. On systems without mmap
+// the file is read into memory instead.
+func OpenMapped(path string) (*MappedHamt, error) {
+	var f, err = os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenMapped")
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenMapped")
+	}
+	var size = fi.Size()
+	if size < int64(mappedHeaderSize+mappedTrailerSize) || size > math.MaxInt {
+		return nil, errors.Errorf("OpenMapped: %s: bad file size %d",
+			path, size)
+	}
+
+	data, unmap, err := mapFile(f, int(size))
+	if err != nil {
+		return nil, errors.Wrapf(err, "OpenMapped: %s", path)
+	}
+
+	m, err := NewMappedHamt(data)
+	if err != nil {
+		unmap(data)
+		return nil, errors.Wrapf(err, "OpenMapped: %s", path)
+	}
+	m.unmap = unmap
+
+	return m, nil
+}
+
+// NewMappedHamt returns a MappedHamt reading from data, which holds a file
+// written by WriteMapped. data must not be modified while the MappedHamt is
+// in use.
+func NewMappedHamt(data []byte) (*MappedHamt, error) {
+	if len(data) < mappedHeaderSize+mappedTrailerSize {
+		return nil, errors.Errorf("NewMappedHamt: %d bytes is too short",
+			len(data))
+	}
+
+	var hdr = data[:mappedHeaderSize]
+	var trl = data[len(data)-mappedTrailerSize:]
+	if string(hdr[:len(mappedMagic)]) != mappedMagic ||
+		string(trl[16:]) != mappedMagic {
+		return nil, errors.New("NewMappedHamt: bad magic")
+	}
+
+	var version = binary.LittleEndian.Uint32(hdr[len(mappedMagic):])
+	if version != mappedVersion {
+		return nil, errors.Errorf("NewMappedHamt: unsupported version %d",
+			version)
+	}
+	var hsize, nbits = uint(hdr[len(mappedMagic)+4]),
+		uint(hdr[len(mappedMagic)+5])
+	if hsize != hashSize || nbits != NumIndexBits {
+		return nil, errors.Errorf(
+			"NewMappedHamt: written with %d bit HashVals and %d index bits; "+
+				"expected %d and %d", hsize, nbits, hashSize, NumIndexBits)
+	}
+
+	var m = new(MappedHamt)
+	m.data = data
+	m.root = binary.LittleEndian.Uint64(trl)
+	m.end = uint64(len(data) - mappedTrailerSize)
+	m.nentries = uint(binary.LittleEndian.Uint64(trl[8:]))
+
+	if tag, err := m.tag(m.root, m.end); err != nil || tag != mappedTableTag {
+		return nil, errors.Errorf("NewMappedHamt: bad root offset %d",
+			m.root)
+	}
+
+	return m, nil
+}
+
+// Close releases the mapping of a MappedHamt from OpenMapped. The MappedHamt,
+// and every key and value it returned, must not be used afterwards.
+func (m *MappedHamt) Close() error {
+	if m.unmap == nil {
+		return nil
+	}
+	var err = m.unmap(m.data)
+	m.data, m.unmap = nil, nil
+	return err
+}
+
+// IsEmpty returns if the MappedHamt has no entries.
+func (m *MappedHamt) IsEmpty() bool {
+	return m.nentries == 0
+}
+
+// Nentries returns the number of (key,value) pairs in the MappedHamt.
+func (m *MappedHamt) Nentries() uint {
+	return m.nentries
+}
+
+// Err returns the error of the first corrupt node Get or Range came across,
+// or nil if they found none.
+func (m *MappedHamt) Err() error {
+	if err := m.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// fail records err for Err, unless an earlier error was.
+func (m *MappedHamt) fail(err error) {
+	m.err.CompareAndSwap(nil, &err)
+}
+
+func corruptNode(off uint64, format string, args ...interface{}) error {
+	return errors.Wrapf(errors.Errorf(format, args...),
+		"MappedHamt: corrupt node at offset %d", off)
+}
+
+// tag returns the tag of the node at off, which must be a node before
+// limit: the offset of its parent, or the end of the nodes for the root.
+func (m *MappedHamt) tag(off, limit uint64) (byte, error) {
+	if off < uint64(mappedHeaderSize) || off >= limit {
+		return 0, corruptNode(off, "offset out of range [%d, %d)",
+			mappedHeaderSize, limit)
+	}
+	var tag = m.data[off]
+	if tag != mappedTableTag && tag != mappedLeafTag {
+		return 0, corruptNode(off, "unknown tag %q", tag)
+	}
+	return tag, nil
+}
+
+// span returns the n bytes at p, of the node at off, if they are within the
+// nodes.
+func (m *MappedHamt) span(off, p, n uint64) ([]byte, error) {
+	if p > m.end || n > m.end-p {
+		return nil, corruptNode(off, "%d bytes at offset %d run past %d",
+			n, p, m.end)
+	}
+	return m.data[p : p+n : p+n], nil
+}
+
+// child returns the offset of the child at idx of the table at off, and
+// whether there is one.
+func (m *MappedHamt) child(off uint64, idx uint) (uint64, bool, error) {
+	var bm bitmap
+	var bs, err = m.span(off, off+1, uint64(len(bm))*4)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := range bm {
+		bm[i] = binary.LittleEndian.Uint32(bs[i*4:])
+	}
+	if !bm.IsSet(idx) {
+		return 0, false, nil
+	}
+	var rank = uint64(bm.Count(idx))
+	bs, err = m.span(off, off+1+uint64(len(bm))*4+rank*8, 8)
+	if err != nil {
+		return 0, false, err
+	}
+	return binary.LittleEndian.Uint64(bs), true, nil
+}
+
+// Get retrieves the value of the key from the MappedHamt. The key must be a
+// ByteSliceKey or a StringKey, any other key is never found. The value is a
+// []byte pointing into the mapped bytes.
+func (m *MappedHamt) Get(key KeyI) (interface{}, bool) {
+	var val, found, err = m.get(key)
+	if err != nil {
+		m.fail(err)
+		return nil, false
+	}
+	if !found {
+		return nil, false
+	}
+	return val, true
+}
+
+func (m *MappedHamt) get(key KeyI) ([]byte, bool, error) {
+	var kbs []byte
+	switch k := key.(type) {
+	case ByteSliceKey:
+		kbs = k
+	case StringKey:
+		kbs = []byte(k)
+	default:
+		return nil, false, nil
+	}
+
+	var hv = key.Hash()
+	var off = m.root
+
+	for depth := uint(0); depth <= maxDepth; depth++ {
+		var idx = hv.Index(depth)
+
+		var child, found, err = m.child(off, idx)
+		if err != nil || !found {
+			return nil, false, err
+		}
+
+		tag, err := m.tag(child, off)
+		if err != nil {
+			return nil, false, err
+		}
+		if tag == mappedLeafTag {
+			var val []byte
+			found = false
+			_, err = m.visitLeaf(child, func(k, v []byte) bool {
+				if bytes.Equal(k, kbs) {
+					val, found = v, true
+				}
+				return !found
+			})
+			return val, found, err
+		}
+
+		off = child
+	}
+
+	return nil, false, corruptNode(off, "tables deeper than %d", maxDepth)
+}
+
+// visitLeaf calls fn for every key,value pair of the leaf at off, until fn
+// returns false. It returns false if fn did.
+func (m *MappedHamt) visitLeaf(
+	off uint64,
+	fn func(k, v []byte) bool,
+) (bool, error) {
+	var bs, err = m.span(off, off+1, 4)
+	if err != nil {
+		return false, err
+	}
+	var n = binary.LittleEndian.Uint32(bs)
+	var p = off + 5
+	for i := uint32(0); i < n; i++ {
+		var kv [2][]byte
+		for j := range kv {
+			if bs, err = m.span(off, p, 4); err != nil {
+				return false, err
+			}
+			var size = uint64(binary.LittleEndian.Uint32(bs))
+			if kv[j], err = m.span(off, p+4, size); err != nil {
+				return false, err
+			}
+			p += 4 + size
+		}
+		if !fn(kv[0], kv[1]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// visitTable calls fn for every key,value pair under the table at off, at
+// depth, in index order, until fn returns false. It returns false if fn did.
+func (m *MappedHamt) visitTable(
+	off uint64,
+	depth uint,
+	fn func(k, v []byte) bool,
+) (bool, error) {
+	if depth > maxDepth {
+		return false, corruptNode(off, "tables deeper than %d", maxDepth)
+	}
+
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var child, found, err = m.child(off, idx)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			continue
+		}
+		tag, err := m.tag(child, off)
+		if err != nil {
+			return false, err
+		}
+		var keepOn bool
+		if tag == mappedLeafTag {
+			keepOn, err = m.visitLeaf(child, fn)
+		} else {
+			keepOn, err = m.visitTable(child, depth+1, fn)
+		}
+		if err != nil || !keepOn {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Range calls fn for every KeyVal pair in the MappedHamt, in the same order
+// as Range() of the Hamt it was written from, until fn returns false. The
+// keys are ByteSliceKeys and the values []bytes. A corrupt node ends Range
+// early; see Err.
+func (m *MappedHamt) Range(fn func(KeyI, interface{}) bool) {
+	var _, err = m.visitTable(m.root, 0, func(k, v []byte) bool {
+		return fn(ByteSliceKey(k), v)
+	})
+	if err != nil {
+		m.fail(err)
+	}
+}
+
+// All returns an iter.Seq2 of every KeyVal pair in the MappedHamt, in the
+// same order as Range().
+func (m *MappedHamt) All() iter.Seq2[KeyI, interface{}] {
+	return func(yield func(KeyI, interface{}) bool) {
+		m.Range(yield)
+	}
+}
+
+// Keys returns an iter.Seq of every key in the MappedHamt, in the same order
+// as Range().
+func (m *MappedHamt) Keys() iter.Seq[KeyI] {
+	return func(yield func(KeyI) bool) {
+		m.Range(func(k KeyI, _ interface{}) bool {
+			return yield(k)
+		})
+	}
+}
+
+// Values returns an iter.Seq of every value in the MappedHamt, in the same
+// order as Range().
+func (m *MappedHamt) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		m.Range(func(_ KeyI, v interface{}) bool {
+			return yield(v)
+		})
+	}
+}
+
+// String returns a string representation of the MappedHamt.
+func (m *MappedHamt) String() string {
+	return fmt.Sprintf("MappedHamt{ nentries: %d, size: %d }",
+		m.nentries, len(m.data))
+}
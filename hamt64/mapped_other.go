@@ -0,0 +1,17 @@
+//go:build !unix
+
+package hamt64
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first size bytes of f into memory, as there is no mmap.
+func mapFile(f *os.File, size int) ([]byte, func([]byte) error, error) {
+	var data = make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, nil, err
+	}
+	return data, func([]byte) error { return nil }, nil
+}
@@ -0,0 +1,216 @@
+package hamt64_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"iter"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numMappedKvs = 20 * 1024
+
+var _ hamt64.HamtReader = (*hamt64.MappedHamt)(nil)
+var _ hamt64.HamtReader = hamt64.Hamt(nil)
+
+// buildMappedHamt64 builds a Hamt from kvs with ByteSliceKey keys and []byte
+// values, as WriteMapped wants them.
+func buildMappedHamt64(kvs []hamt64.KeyVal) hamt64.Hamt {
+	var h = hamt64.New(Functional, TableOption)
+	for _, kv := range kvs {
+		var key = hamt64.ByteSliceKey(kv.Key.(hamt64.StringKey))
+		h, _ = h.Put(key, []byte(strconv.Itoa(kv.Val.(int))))
+	}
+	return h
+}
+
+func TestHamt64Mapped(t *testing.T) {
+	var name = "TestHamt64Mapped:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numMappedKvs]
+	var h = buildMappedHamt64(kvs)
+
+	var path = filepath.Join(t.TempDir(), "hamt.map")
+	var f, err = os.Create(path)
+	if err != nil {
+		t.Fatalf("%s: failed os.Create() => %s", name, err)
+	}
+	if err = hamt64.WriteMapped(f, h); err != nil {
+		t.Fatalf("%s: failed WriteMapped() => %s", name, err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatalf("%s: failed f.Close() => %s", name, err)
+	}
+
+	m, err := hamt64.OpenMapped(path)
+	if err != nil {
+		t.Fatalf("%s: failed OpenMapped() => %s", name, err)
+	}
+	defer m.Close()
+
+	if m.Nentries() != h.Nentries() {
+		t.Fatalf("%s: m.Nentries() %d != h.Nentries() %d",
+			name, m.Nentries(), h.Nentries())
+	}
+
+	for _, kv := range kvs {
+		var expected = []byte(strconv.Itoa(kv.Val.(int)))
+		var val, found = m.Get(hamt64.ByteSliceKey(kv.Key.(hamt64.StringKey)))
+		if !found || !bytes.Equal(val.([]byte), expected) {
+			t.Fatalf("%s: m.Get(%s) => %v, %t; expected %s",
+				name, kv.Key, val, found, expected)
+		}
+		// a StringKey hashes the same
+		if _, found = m.Get(kv.Key); !found {
+			t.Fatalf("%s: m.Get(StringKey(%s)) not found", name, kv.Key)
+		}
+	}
+
+	for _, kv := range KVS64[numMappedKvs : numMappedKvs+1024] {
+		var key = hamt64.ByteSliceKey(kv.Key.(hamt64.StringKey))
+		if val, found := m.Get(key); found {
+			t.Fatalf("%s: m.Get(%s) of a missing key => %v", name, key, val)
+		}
+	}
+	if _, found := m.Get(hamt64.Int64Key(1)); found {
+		t.Fatalf("%s: m.Get(Int64Key) found", name)
+	}
+
+	// the same pairs, in the same order
+	var next, stop = iter.Pull2(h.All())
+	defer stop()
+	var n uint
+	m.Range(func(k hamt64.KeyI, v interface{}) bool {
+		var hk, hv, ok = next()
+		if !ok || !k.Equals(hk) || !bytes.Equal(v.([]byte), hv.([]byte)) {
+			t.Fatalf("%s: m.Range() => %s, %s; h.Range() => %v, %v",
+				name, k, v, hk, hv)
+		}
+		n++
+		return true
+	})
+	if n != h.Nentries() {
+		t.Fatalf("%s: m.Range() visited %d of %d", name, n, h.Nentries())
+	}
+	if err = m.Err(); err != nil {
+		t.Fatalf("%s: m.Err() => %s", name, err)
+	}
+}
+
+func TestHamt64MappedErrors(t *testing.T) {
+	var name = "TestHamt64MappedErrors:" + hamt64.TableOptionName[TableOption]
+
+	var h = hamt64.New(Functional, TableOption)
+	h, _ = h.Put(hamt64.StringKey("a"), 1)
+
+	var buf bytes.Buffer
+	if err := hamt64.WriteMapped(&buf, h); err == nil {
+		t.Fatalf("%s: WriteMapped() of an int value succeeded", name)
+	}
+
+	h = hamt64.New(Functional, TableOption)
+	h, _ = h.Put(hamt64.Int32Key(1), "a")
+	buf.Reset()
+	if err := hamt64.WriteMapped(&buf, h); err == nil {
+		t.Fatalf("%s: WriteMapped() of an Int32Key succeeded", name)
+	}
+
+	h = hamt64.New(Functional, TableOption)
+	buf.Reset()
+	if err := hamt64.WriteMapped(&buf, h); err != nil {
+		t.Fatalf("%s: WriteMapped() of an empty Hamt => %s", name, err)
+	}
+	var data = buf.Bytes()
+
+	var m, err = hamt64.NewMappedHamt(data)
+	if err != nil {
+		t.Fatalf("%s: NewMappedHamt() of an empty Hamt => %s", name, err)
+	}
+	if !m.IsEmpty() {
+		t.Fatalf("%s: !m.IsEmpty()", name)
+	}
+	if _, found := m.Get(hamt64.StringKey("a")); found {
+		t.Fatalf("%s: m.Get() found a key in an empty Hamt", name)
+	}
+
+	for _, i := range []int{0, 8, 12, len(data) - 1} {
+		var bs = append([]byte(nil), data...)
+		bs[i] ^= 0xff
+		if _, err = hamt64.NewMappedHamt(bs); err == nil {
+			t.Fatalf("%s: NewMappedHamt() with byte %d corrupted succeeded",
+				name, i)
+		}
+	}
+	if _, err = hamt64.NewMappedHamt(data[:len(data)-1]); err == nil {
+		t.Fatalf("%s: NewMappedHamt() of truncated data succeeded", name)
+	}
+
+	var missing = filepath.Join(t.TempDir(), "missing")
+	if _, err = hamt64.OpenMapped(missing); err == nil {
+		t.Fatalf("%s: OpenMapped() of a missing file succeeded", name)
+	}
+}
+
+func TestHamt64MappedCorrupt(t *testing.T) {
+	var name = "TestHamt64MappedCorrupt:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:256]
+	var h = buildMappedHamt64(kvs)
+	var key = hamt64.ByteSliceKey("a key")
+	var val = []byte("the value of a key")
+	h, _ = h.Put(key, val)
+
+	var buf bytes.Buffer
+	if err := hamt64.WriteMapped(&buf, h); err != nil {
+		t.Fatalf("%s: failed WriteMapped() => %s", name, err)
+	}
+	var data = buf.Bytes()
+
+	// no corrupt byte crashes Get or Range
+	for i := range data {
+		var bs = append([]byte(nil), data...)
+		bs[i] ^= 0xff
+		var m, err = hamt64.NewMappedHamt(bs)
+		if err != nil {
+			continue
+		}
+		for _, kv := range kvs {
+			m.Get(kv.Key)
+		}
+		m.Range(func(hamt64.KeyI, interface{}) bool { return true })
+	}
+
+	// the first child offset of the root table, pointing past the nodes or
+	// back at the root
+	var root = binary.LittleEndian.Uint64(data[len(data)-24:])
+	var first = root + 1 + hamt64.IndexLimit/8
+	for _, off := range []uint64{uint64(len(data)) + 100, root} {
+		var bs = append([]byte(nil), data...)
+		binary.LittleEndian.PutUint64(bs[first:], off)
+		var m, err = hamt64.NewMappedHamt(bs)
+		if err != nil {
+			t.Fatalf("%s: failed NewMappedHamt() => %s", name, err)
+		}
+		var n int
+		m.Range(func(hamt64.KeyI, interface{}) bool {
+			n++
+			return true
+		})
+		if n != 0 || m.Err() == nil {
+			t.Fatalf("%s: m.Range() with a child offset %d => %d pairs, %v",
+				name, off, n, m.Err())
+		}
+	}
+
+	// a value length running past the nodes
+	var bs = append([]byte(nil), data...)
+	var voff = bytes.Index(bs, val)
+	binary.LittleEndian.PutUint32(bs[voff-4:], uint32(len(data)))
+	var m, _ = hamt64.NewMappedHamt(bs)
+	if _, found := m.Get(key); found || m.Err() == nil {
+		t.Fatalf("%s: m.Get() with a bad value length => %t, %v",
+			name, found, m.Err())
+	}
+}
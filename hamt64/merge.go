@@ -0,0 +1,303 @@
+package hamt64
+
+// Resolver is called by HamtFunctional.Union, Intersect, and Difference for
+// every key that exists in both Hamts with different values. The val argument
+// is the value from the Hamt the method was called on and otherVal is the
+// value from the other Hamt. The Resolver returns the value to store for the
+// key and whether the key is kept at all.
+//
+// A Resolver is not called for identical pairs: a key whose values are equal
+// by ==, or which is in a leaf or table the two Hamts share physically, as
+// happens when one Hamt is derived from the other. Union and Intersect keep
+// such a pair, and Difference removes it. So shared subtrees are never
+// visited, and the cost of the operation is proportional to the size of the
+// difference between the Hamts.
+type Resolver func(key KeyI, val, otherVal interface{}) (interface{}, bool)
+
+type mergeOp int
+
+const (
+	unionOp mergeOp = iota
+	intersectOp
+	differenceOp
+)
+
+// merger holds the state of a parallel walk of two tries. The result shares
+// every subtree, from either trie, that the operation leaves unchanged.
+type merger struct {
+	op         mergeOp
+	resolve    Resolver
+	nograde    bool
+	startFixed bool
+	added      uint
+	removed    uint
+}
+
+// Union returns a Hamt with every key of h and other. For keys in both the
+// value is decided by the resolve function; if resolve is nil the value from
+// other wins, just as if every KeyVal pair of other was Put into h.
+//
+// Union walks both tries in parallel and only descends where they differ, so
+// the cost is proportional to the size of the difference between h and other.
+// If nothing changes, h itself is returned.
+//
+// The result shares tables with both h and other. If other is a HamtTransient
+// it must not be modified afterwards.
+func (h *HamtFunctional) Union(other Hamt, resolve Resolver) Hamt {
+	if resolve == nil {
+		resolve = func(_ KeyI, _, otherVal interface{}) (interface{}, bool) {
+			return otherVal, true
+		}
+	}
+	return h.merge(unionOp, other, resolve)
+}
+
+// Intersect returns a Hamt with only the keys that are in both h and other.
+// The value of each key is decided by the resolve function; if resolve is nil
+// the value from h is kept.
+//
+// Like Union, Intersect only descends where the two tries differ. If nothing
+// changes, h itself is returned.
+func (h *HamtFunctional) Intersect(other Hamt, resolve Resolver) Hamt {
+	if resolve == nil {
+		resolve = func(_ KeyI, val, _ interface{}) (interface{}, bool) {
+			return val, true
+		}
+	}
+	return h.merge(intersectOp, other, resolve)
+}
+
+// Difference returns a Hamt with the keys of h that are not in other. For keys
+// in both, the resolve function may decide to keep the key anyway; if resolve
+// is nil every such key is removed.
+//
+// Like Union, Difference only descends where the two tries differ. If nothing
+// changes, h itself is returned.
+func (h *HamtFunctional) Difference(other Hamt, resolve Resolver) Hamt {
+	if resolve == nil {
+		resolve = func(_ KeyI, _, _ interface{}) (interface{}, bool) {
+			return nil, false
+		}
+	}
+	return h.merge(differenceOp, other, resolve)
+}
+
+func (h *HamtFunctional) merge(op mergeOp, other Hamt, resolve Resolver) Hamt {
+	var m = merger{
+		op:         op,
+		resolve:    resolve,
+		nograde:    h.nograde,
+		startFixed: h.startFixed,
+	}
+
+	var ob = hamtBaseOf(other)
+	var root = m.mergeTables(&h.root, &ob.root, 0)
+
+	if root == tableI(&h.root) {
+		return h
+	}
+
+	var nh = new(HamtFunctional)
+	nh.nograde = h.nograde
+	nh.startFixed = h.startFixed
+	nh.nentries = h.nentries + m.added - m.removed
+
+	// mergeTables() always returns a fixedTable at depth 0
+	nh.root.set(root.(*fixedTable))
+
+	return nh
+}
+
+// countKeyVals returns the number of KeyVal pairs in the subtree n.
+func countKeyVals(n nodeI) uint {
+	var count uint
+	if n == nil {
+		return count
+	}
+	n.visit(func(x nodeI) bool {
+		if l, isLeaf := x.(leafI); isLeaf {
+			switch y := l.(type) {
+			case *flatLeaf:
+				count++
+			case *collisionLeaf:
+				count += uint(len(y.kvs))
+			}
+		}
+		return true
+	})
+	return count
+}
+
+// mergeNodes merges the two nodes found at the same slot of the two tries.
+// If they are tables, they are tables at the given depth.
+func (m *merger) mergeNodes(a, b nodeI, depth uint) nodeI {
+	if a == b {
+		if m.op == differenceOp {
+			m.removed += countKeyVals(a)
+			return nil
+		}
+		return a
+	}
+
+	if a == nil {
+		if m.op == unionOp {
+			m.added += countKeyVals(b)
+			return b
+		}
+		return nil
+	}
+
+	if b == nil {
+		if m.op == intersectOp {
+			m.removed += countKeyVals(a)
+			return nil
+		}
+		return a
+	}
+
+	var la, aIsLeaf = a.(leafI)
+	var lb, bIsLeaf = b.(leafI)
+	if aIsLeaf && bIsLeaf && (la.Hash() == lb.Hash() || depth > maxDepth) {
+		return m.mergeLeafs(la, lb)
+	}
+
+	return m.mergeTables(a, b, depth)
+}
+
+// mergeTables merges a and b as tables at the given depth. Either one may be a
+// leaf, see child().
+func (m *merger) mergeTables(a, b nodeI, depth uint) nodeI {
+	var ents = make([]tableEntry, 0, IndexLimit)
+	var sameAsA, sameAsB = true, true
+
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var ca = child(a, idx, depth)
+		var cb = child(b, idx, depth)
+		if ca == nil && cb == nil {
+			continue
+		}
+
+		var n = m.mergeNodes(ca, cb, depth+1)
+
+		sameAsA = sameAsA && n == ca
+		sameAsB = sameAsB && n == cb
+
+		if n != nil {
+			ents = append(ents, tableEntry{idx, n})
+		}
+	}
+
+	if _, isTable := a.(tableI); isTable && sameAsA {
+		return a
+	}
+	if _, isTable := b.(tableI); isTable && sameAsB {
+		return b
+	}
+
+	if depth > 0 {
+		switch len(ents) {
+		case 0:
+			return nil
+		case 1:
+			// collapse a lone leaf into the parent table
+			if _, isLeaf := ents[0].node.(leafI); isLeaf {
+				return ents[0].node
+			}
+		}
+	}
+
+	return newTableFrom(a, depth, ents, m.nograde, m.startFixed)
+}
+
+// newTableFrom builds the table at the given depth holding ents, which is
+// replacing the node old. The kind of table follows the rules Put and Del use
+// for the nograde and startFixed table options; between the two thresholds of
+// HybridTables the kind of old is kept.
+func newTableFrom(
+	old nodeI,
+	depth uint,
+	ents []tableEntry,
+	nograde, startFixed bool,
+) tableI {
+	var hashPath HashVal // the root table, at depth 0, may be empty
+	var nents = uint(len(ents))
+	if nents > 0 {
+		hashPath = ents[0].node.Hash().hashPath(depth)
+	}
+
+	var useFixed bool
+	switch {
+	case depth == 0 || startFixed:
+		useFixed = true
+	case nograde:
+		useFixed = false
+	case nents >= UpgradeThreshold:
+		useFixed = true
+	case nents <= DowngradeThreshold:
+		useFixed = false
+	default:
+		_, useFixed = old.(*fixedTable)
+	}
+
+	if useFixed {
+		return upgradeToFixedTable(hashPath, depth, ents)
+	}
+	return downgradeToSparseTable(hashPath, depth, ents)
+}
+
+// mergeLeafs merges two leafs with the same HashVal.
+func (m *merger) mergeLeafs(a, b leafI) nodeI {
+	var akvs = a.keyVals()
+	var bkvs = b.keyVals()
+
+	var kvs = make([]KeyVal, 0, len(akvs)+len(bkvs))
+	var changed bool
+
+	for _, akv := range akvs {
+		var bval, inB = b.get(akv.Key)
+		if !inB {
+			if m.op == intersectOp {
+				m.removed++
+				changed = true
+			} else {
+				kvs = append(kvs, akv)
+			}
+			continue
+		}
+
+		// identical pairs are kept, or removed by Difference, unresolved
+		var val, keep = akv.Val, m.op != differenceOp
+		if !sameVal(akv.Val, bval) {
+			val, keep = m.resolve(akv.Key, akv.Val, bval)
+		}
+		if keep {
+			kvs = append(kvs, KeyVal{akv.Key, val})
+			changed = changed || !sameVal(val, akv.Val)
+		} else {
+			m.removed++
+			changed = true
+		}
+	}
+
+	if m.op == unionOp {
+		for _, bkv := range bkvs {
+			if _, inA := a.get(bkv.Key); !inA {
+				kvs = append(kvs, bkv)
+				m.added++
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return a
+	}
+
+	switch len(kvs) {
+	case 0:
+		return nil
+	case 1:
+		return newFlatLeaf(kvs[0].Key, kvs[0].Val)
+	}
+	return newCollisionLeaf(kvs)
+}
@@ -0,0 +1,227 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numMergeKvs = 64 * 1024
+
+// checkHamt64 verifies h holds exactly the KeyVal pairs in expected.
+func checkHamt64(
+	t *testing.T,
+	name string,
+	h hamt64.Hamt,
+	expected map[hamt64.StringKey]interface{},
+) {
+	t.Helper()
+
+	if h.Nentries() != uint(len(expected)) {
+		t.Fatalf("%s: h.Nentries(),%d != %d", name, h.Nentries(), len(expected))
+	}
+	if stats := h.Stats(); stats.KeyVals != uint(len(expected)) {
+		t.Fatalf("%s: stats.KeyVals,%d != %d",
+			name, stats.KeyVals, len(expected))
+	}
+	for k, v := range expected {
+		if val, found := h.Get(k); !found || val != v {
+			t.Fatalf("%s: h.Get(%q) => %v, %t; expected %v",
+				name, k, val, found, v)
+		}
+	}
+	h.Range(func(k hamt64.KeyI, v interface{}) bool {
+		if ev, found := expected[k.(hamt64.StringKey)]; !found || ev != v {
+			t.Fatalf("%s: unexpected KeyVal {%q, %v}", name, k, v)
+		}
+		return true
+	})
+}
+
+func buildMergeOperands(
+	t *testing.T,
+	name string,
+) (*hamt64.HamtFunctional, *hamt64.HamtFunctional) {
+	var kvs = KVS64[:numMergeKvs]
+
+	// a holds the first 3/4 of kvs; b holds the last 3/4 with negated values,
+	// and is built with FixedTables so the layouts differ.
+	var a, err = buildHamt64(name, kvs[:numMergeKvs*3/4], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var b hamt64.Hamt = hamt64.NewFunctional(hamt64.FixedTables)
+	for _, kv := range kvs[numMergeKvs/4:] {
+		b, _ = b.Put(kv.Key, -kv.Val.(int))
+	}
+
+	return a.(*hamt64.HamtFunctional), b.(*hamt64.HamtFunctional)
+}
+
+func TestHamt64Union(t *testing.T) {
+	var name = "TestHamt64Union:" + hamt64.TableOptionName[TableOption]
+	var a, b = buildMergeOperands(t, name)
+	var kvs = KVS64[:numMergeKvs]
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[:numMergeKvs/4] {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	for _, kv := range kvs[numMergeKvs/4:] {
+		expected[kv.Key.(hamt64.StringKey)] = -kv.Val.(int)
+	}
+	checkHamt64(t, name+":nil", a.Union(b, nil), expected)
+
+	// keep the value from a, and drop keys with an odd value
+	var keepA = func(_ hamt64.KeyI, val, _ interface{}) (interface{}, bool) {
+		return val, val.(int)%2 == 0
+	}
+	for _, kv := range kvs[numMergeKvs/4 : numMergeKvs*3/4] {
+		if kv.Val.(int)%2 == 0 {
+			expected[kv.Key.(hamt64.StringKey)] = kv.Val
+		} else {
+			delete(expected, kv.Key.(hamt64.StringKey))
+		}
+	}
+	checkHamt64(t, name+":keepA", a.Union(b, keepA), expected)
+
+	// a and b are unmodified
+	var aExpected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[:numMergeKvs*3/4] {
+		aExpected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	checkHamt64(t, name+":a", a, aExpected)
+}
+
+func TestHamt64Intersect(t *testing.T) {
+	var name = "TestHamt64Intersect:" + hamt64.TableOptionName[TableOption]
+	var a, b = buildMergeOperands(t, name)
+	var kvs = KVS64[:numMergeKvs]
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[numMergeKvs/4 : numMergeKvs*3/4] {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	checkHamt64(t, name+":nil", a.Intersect(b, nil), expected)
+
+	var empty = hamt64.NewFunctional(TableOption)
+	checkHamt64(t, name+":empty", a.Intersect(empty, nil), nil)
+}
+
+func TestHamt64Difference(t *testing.T) {
+	var name = "TestHamt64Difference:" + hamt64.TableOptionName[TableOption]
+	var a, b = buildMergeOperands(t, name)
+	var kvs = KVS64[:numMergeKvs]
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[:numMergeKvs/4] {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	checkHamt64(t, name+":nil", a.Difference(b, nil), expected)
+
+	// keep keys in both whose values differ; b holds negated values, so
+	// that is all of them.
+	var differs = func(_ hamt64.KeyI, val, oval interface{}) (interface{}, bool) {
+		return val, val != oval
+	}
+	for _, kv := range kvs[numMergeKvs/4 : numMergeKvs*3/4] {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	checkHamt64(t, name+":differs", a.Difference(b, differs), expected)
+}
+
+func TestHamt64MergeShared(t *testing.T) {
+	var name = "TestHamt64MergeShared:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numMergeKvs]
+
+	var a, err = buildHamt64(name, kvs, true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var h = a.(*hamt64.HamtFunctional)
+
+	if h.Union(h, nil) != hamt64.Hamt(h) {
+		t.Fatalf("%s: h.Union(h) did not return h", name)
+	}
+	if h.Intersect(h, nil) != hamt64.Hamt(h) {
+		t.Fatalf("%s: h.Intersect(h) did not return h", name)
+	}
+	if !h.Difference(h, nil).IsEmpty() {
+		t.Fatalf("%s: h.Difference(h) is not empty", name)
+	}
+
+	var key = hamt64.StringKey("a new key")
+	var h2, _ = h.Put(key, -1)
+
+	var u = h.Union(h2, nil)
+	if u.Nentries() != h.Nentries()+1 {
+		t.Fatalf("%s: u.Nentries(),%d != %d",
+			name, u.Nentries(), h.Nentries()+1)
+	}
+	if v, _ := u.Get(key); v != -1 {
+		t.Fatalf("%s: u.Get(%q) => %v", name, key, v)
+	}
+
+	var d = h2.(*hamt64.HamtFunctional).Difference(h, nil)
+	if d.Nentries() != 1 {
+		t.Fatalf("%s: d.Nentries(),%d != 1", name, d.Nentries())
+	}
+	if v, _ := d.Get(key); v != -1 {
+		t.Fatalf("%s: d.Get(%q) => %v", name, key, v)
+	}
+}
+
+// TestHamt64MergeSharedResolver checks that a Resolver is not called for
+// identical pairs, whether the two Hamts share them physically or not, and
+// that Difference removes them even when the Resolver would keep them.
+func TestHamt64MergeSharedResolver(t *testing.T) {
+	var name = "TestHamt64MergeSharedResolver:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numMergeKvs/4]
+
+	var a, err = buildHamt64(name, kvs, true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var h = a.(*hamt64.HamtFunctional)
+	var b, _ = buildHamt64(name, kvs, true, TableOption) // same contents
+
+	var ncalls int
+	var sum = func(
+		_ hamt64.KeyI,
+		val, otherVal interface{},
+	) (interface{}, bool) {
+		ncalls++
+		return val.(int) + otherVal.(int), true
+	}
+	var keep = func(_ hamt64.KeyI, val, _ interface{}) (interface{}, bool) {
+		ncalls++
+		return val, true
+	}
+	var same = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs {
+		same[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	var none = make(map[hamt64.StringKey]interface{})
+
+	for _, other := range []hamt64.Hamt{h, b} {
+		if u := h.Union(other, sum); u != hamt64.Hamt(h) {
+			t.Fatalf("%s: h.Union(h, sum) did not return h", name)
+		}
+		checkHamt64(t, name+":Intersect", h.Intersect(other, sum), same)
+		checkHamt64(t, name+":Difference", h.Difference(other, keep), none)
+	}
+	if ncalls != 0 {
+		t.Fatalf("%s: resolve called %d times; expected 0", name, ncalls)
+	}
+
+	// only the key that differs in a derived Hamt is resolved
+	var h2, _ = h.Put(kvs[0].Key, 1000)
+	var u = h2.(*hamt64.HamtFunctional).Union(h, sum)
+	same[kvs[0].Key.(hamt64.StringKey)] = 1000 + kvs[0].Val.(int)
+	checkHamt64(t, name+":Union derived", u, same)
+	if ncalls != 1 {
+		t.Fatalf("%s: resolve called %d times; expected 1", name, ncalls)
+	}
+}
@@ -1,6 +1,9 @@
 package hamt64
 
-import "fmt"
+import (
+	"fmt"
+	"sync/atomic"
+)
 
 // visitFn will be passed a value for every slot in the Hamt; this includes
 // leafs, tables, and nil.
@@ -21,6 +24,15 @@ type leafI interface {
 	keyVals() []KeyVal
 }
 
+// countedLeaf is implemented by the leafs of the Map and Set types. It lets
+// the code shared with Hamt, like calcStats, count their entries without
+// knowing their concrete type.
+type countedLeaf interface {
+	nodeI
+
+	nkeyvals() uint
+}
+
 type tableIterFunc func() nodeI
 
 type tableI interface {
@@ -41,6 +53,71 @@ type tableI interface {
 	remove(idx uint)
 
 	iter() tableIterFunc
+
+	owner() *editToken
+	setOwner(edit *editToken)
+
+	cachedDigest() *tableDigest
+	cacheDigest(td *tableDigest)
+
+	cachedStored() *storedTable
+	cacheStored(st *storedTable)
+}
+
+// editToken identifies the HamtTransient that owns a table. A HamtTransient
+// only modifies the tables stamped with its own editToken in-place; any other
+// table is copied, and the copy stamped, the first time it is modified. The
+// token is frozen by HamtTransient.Persistent().
+type editToken struct {
+	frozen bool
+}
+
+// claim makes sure edit owns every table in the path find() returned for hv.
+// A table it does not own is copied, the copy is stamped with edit, and the
+// copy replaces the original in both the parent table and the path. The
+// original table, which may be shared with a functional Hamt, Map, or Set, is
+// not touched.
+func (edit *editToken) claim(hv HashVal, path tableStack) {
+	var ts = *path.(*tableSlice)
+
+	// the root table is part of the transient, so it is always owned.
+	for depth := 1; depth < len(ts); depth++ {
+		if ts[depth].owner() == edit {
+			continue
+		}
+		var nt = ts[depth].copy()
+		nt.setOwner(edit)
+		ts[depth-1].replace(hv.Index(uint(depth-1)), nt)
+		ts[depth] = nt
+	}
+}
+
+// own stamps every table of the freshly created subtree t with edit.
+func (edit *editToken) own(t tableI) {
+	t.visit(func(n nodeI) bool {
+		if x, isTable := n.(tableI); isTable {
+			x.setOwner(edit)
+		}
+		return true
+	})
+}
+
+// tableAnnot holds the annotations a table caches: its digest, see
+// fingerprint.go, and where it is stored, see store.go. Most tables never get
+// any, so a table holds one pointer to a tableAnnot, allocated on first use,
+// rather than a pointer per annotation.
+type tableAnnot struct {
+	dig atomic.Pointer[tableDigest]
+	sto atomic.Pointer[storedTable]
+}
+
+// annotOf returns the tableAnnot p points to, allocating it if need be.
+func annotOf(p *atomic.Pointer[tableAnnot]) *tableAnnot {
+	if a := p.Load(); a != nil {
+		return a
+	}
+	p.CompareAndSwap(nil, new(tableAnnot))
+	return p.Load()
 }
 
 type tableEntry struct {
@@ -51,3 +128,17 @@ type tableEntry struct {
 func (ent tableEntry) String() string {
 	return fmt.Sprintf("tableEntry{idx:%d, node:%s}", ent.idx, ent.node.String())
 }
+
+// child returns the node found at idx when the node n is treated as a table at
+// the given depth. A leaf is treated as a table holding just that leaf.
+func child(n nodeI, idx, depth uint) nodeI {
+	switch x := n.(type) {
+	case tableI:
+		return x.get(idx)
+	case leafI:
+		if x.Hash().Index(depth) == idx {
+			return x
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package hamt64
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNodeNotFound is returned by NodeStore.Get for a Digest it does not hold.
+var ErrNodeNotFound = errors.New("node not found")
+
+// NodeStore is where a Store keeps the encoded tables and leafs of Hamts,
+// each under the Digest of its encoding. As the Digest of a node follows from
+// its bytes, Put of a Digest the NodeStore already holds does not need to
+// write anything.
+//
+// A NodeStore must be safe to use from several goroutines.
+type NodeStore interface {
+	Get(d Digest) ([]byte, error)
+	Put(d Digest, data []byte) error
+	Has(d Digest) (bool, error)
+	Delete(d Digest) error
+	Digests() ([]Digest, error)
+}
+
+// MemStore is a NodeStore that keeps the nodes in memory.
+type MemStore struct {
+	mu    sync.RWMutex
+	nodes map[Digest][]byte
+}
+
+// NewMemStore constructs an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{nodes: make(map[Digest][]byte)}
+}
+
+// Get returns the node stored under d, or ErrNodeNotFound.
+func (ms *MemStore) Get(d Digest) ([]byte, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var data, found = ms.nodes[d]
+	if !found {
+		return nil, errors.Wrapf(ErrNodeNotFound, "MemStore: %s", d)
+	}
+	return data, nil
+}
+
+// Put stores a copy of data under d.
+func (ms *MemStore) Put(d Digest, data []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, found := ms.nodes[d]; !found {
+		ms.nodes[d] = append([]byte(nil), data...)
+	}
+	return nil
+}
+
+// Has returns whether a node is stored under d.
+func (ms *MemStore) Has(d Digest) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var _, found = ms.nodes[d]
+	return found, nil
+}
+
+// Delete removes the node stored under d, if any.
+func (ms *MemStore) Delete(d Digest) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.nodes, d)
+	return nil
+}
+
+// Digests returns the Digest of every node in the MemStore.
+func (ms *MemStore) Digests() ([]Digest, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var ds = make([]Digest, 0, len(ms.nodes))
+	for d := range ms.nodes {
+		ds = append(ds, d)
+	}
+	return ds, nil
+}
+
+// DirStore is a NodeStore that keeps every node in a file of its own, named
+// by the hex string of its Digest, in a subdirectory named by the first two
+// hex digits, like git does.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore constructs a DirStore keeping its nodes under dir, which is
+// created if it does not exist.
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "NewDirStore")
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+func (ds *DirStore) path(d Digest) string {
+	var name = d.String()
+	return filepath.Join(ds.dir, name[:2], name[2:])
+}
+
+// Get returns the node stored under d, or ErrNodeNotFound.
+func (ds *DirStore) Get(d Digest) ([]byte, error) {
+	var data, err = os.ReadFile(ds.path(d))
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrNodeNotFound, "DirStore: %s", d)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "DirStore")
+	}
+	return data, nil
+}
+
+// Put stores data under d. The file is written under a temporary name and
+// renamed, so a crash never leaves a partial node behind.
+func (ds *DirStore) Put(d Digest, data []byte) error {
+	var path = ds.path(d)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	var dir = filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "DirStore")
+	}
+
+	var f, err = os.CreateTemp(dir, "tmp-")
+	if err != nil {
+		return errors.Wrap(err, "DirStore")
+	}
+	var tmp = f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "DirStore")
+	}
+
+	return nil
+}
+
+// Has returns whether a node is stored under d.
+func (ds *DirStore) Has(d Digest) (bool, error) {
+	var _, err = os.Stat(ds.path(d))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "DirStore")
+	}
+	return true, nil
+}
+
+// Delete removes the node stored under d, if any.
+func (ds *DirStore) Delete(d Digest) error {
+	var err = os.Remove(ds.path(d))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "DirStore")
+	}
+	return nil
+}
+
+// Digests returns the Digest of every node in the DirStore. Files that are
+// not named like a node, such as leftovers of an interrupted Put, are
+// ignored.
+func (ds *DirStore) Digests() ([]Digest, error) {
+	var subs, err = os.ReadDir(ds.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "DirStore")
+	}
+
+	var digs []Digest
+	for _, sub := range subs {
+		if !sub.IsDir() || len(sub.Name()) != 2 {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(ds.dir, sub.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "DirStore")
+		}
+		for _, f := range files {
+			var d Digest
+			var bs, err = hex.DecodeString(sub.Name() + f.Name())
+			if err != nil || len(bs) != len(d) {
+				continue
+			}
+			copy(d[:], bs)
+			digs = append(digs, d)
+		}
+	}
+
+	return digs, nil
+}
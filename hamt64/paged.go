@@ -0,0 +1,568 @@
+package hamt64
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// The page file of a PagedHamt is:
+//
+//	header     "HAMTPAGE" version:uint32 pageDepth:byte tblOpt:byte 0 0
+//	           dir:uint64
+//	pages      ...
+//	directory  nentries:uint64 npages*(off:uint64 size:uint64)
+//
+// with every integer little-endian. A page is every key whose HashVal has the
+// same first pageDepth indexes, as a Hamt in the Encode format; its number is
+// those indexes, HashVal.hashPath(pageDepth). The directory has the offset and
+// size of every page, with size 0 for an empty page, and dir is the offset of
+// the directory, or 0 if there is none yet.
+//
+// A page or directory is written into the first free extent of the file it
+// fits in, or at the end. Flush writes the directory after the pages, and
+// points the header at it last; only then do the extents of the pages and
+// directory it replaced become free. So a crash leaves the file as it was at
+// the last Flush, and free space at the end of the file is truncated.
+//
+// Every write of a page rewrites all of it, so a Put costs the size of its
+// page when the page is written back. The file holds up to two versions of
+// every page changed since the last Flush, plus the free extents that pages
+// of varying sizes leave between them.
+
+const pagedMagic = "HAMTPAGE"
+
+const pagedVersion = 1
+
+const pagedHeaderSize = len(pagedMagic) + 16
+
+// MaxPageDepth is the largest pageDepth of a PagedHamt. The directory of the
+// pages stays resident, and has IndexLimit^pageDepth entries.
+const MaxPageDepth = 4
+
+// page is one page of a PagedHamt. h is nil unless the page is in the cache.
+type page struct {
+	off, size uint64 // in the page file; size is 0 for an empty page
+	h         *HamtTransient
+	elem      *list.Element // in PagedHamt.lru, if h is not nil
+	dirty     bool
+	fresh     bool // written since the last Flush, so not in its directory
+}
+
+// extent is a span of the page file.
+type extent struct {
+	off, size uint64
+}
+
+// PagedStats are the cache counters of a PagedHamt.
+type PagedStats struct {
+	Hits      uint64 // page lookups that found the page in the cache
+	Misses    uint64 // page lookups that read the page from the file
+	Evictions uint64 // pages dropped from the cache to make room
+	Writes    uint64 // pages written to the file, by Flush or eviction
+	Cached    int    // pages in the cache now
+}
+
+// PagedHamt is a Hamt that keeps most of itself in a page file. The top
+// pageDepth levels of the trie, which every key shares with at most
+// IndexLimit^pageDepth others, stay resident as a directory of pages; the
+// tables below them are read into a cache of at most cacheSize pages when a
+// key needs them, and the least recently used page is dropped when the cache
+// is full, after writing it back if it was changed.
+//
+// Get, Put, Del and Range work as they do on a HamtTransient, but can fail
+// on reading or writing the page file. Flush writes every changed page, so
+// the file is complete; Close flushes too.
+//
+// A PagedHamt is safe to use from several goroutines; it serializes every
+// method on one lock.
+type PagedHamt struct {
+	mu        sync.Mutex
+	f         *os.File
+	reg       *Registry
+	pageDepth uint
+	tblOpt    int
+	cacheSize int
+	pages     []page
+	lru       *list.List // of the cached *page, most recently used first
+	nentries  uint
+	end       uint64   // of the page file, where the next page goes
+	dir       extent   // of the directory the header points to
+	free      []extent // not in use, by offset
+	pending   []extent // in use as of the last Flush, free after the next
+	changed   bool     // since the last directory was written
+	stats     PagedStats
+}
+
+// OpenPaged opens the page file at path, creating it if it does not exist. A
+// new PagedHamt gets the table option tblOpt, for its pages, and pageDepth,
+// which must be between 1 and MaxPageDepth; an existing one keeps those it
+// was created with. At most cacheSize pages are kept in memory. The keys and
+// values are encoded by the codecs of reg; if reg is nil DefaultRegistry is
+// used.
+func OpenPaged(
+	path string,
+	tblOpt int,
+	pageDepth uint,
+	cacheSize int,
+	reg *Registry,
+) (*PagedHamt, error) {
+	if reg == nil {
+		reg = DefaultRegistry
+	}
+	if cacheSize < 1 {
+		return nil, errors.Errorf("OpenPaged: cacheSize %d < 1", cacheSize)
+	}
+
+	var f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenPaged")
+	}
+
+	var ph = &PagedHamt{
+		f:         f,
+		reg:       reg,
+		pageDepth: pageDepth,
+		tblOpt:    tblOpt,
+		cacheSize: cacheSize,
+		lru:       list.New(),
+	}
+	if err = ph.open(); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "OpenPaged: %s", path)
+	}
+	return ph, nil
+}
+
+// open reads the header and the directory of the page file, or writes the
+// header of a new one.
+func (ph *PagedHamt) open() error {
+	var fi, err = ph.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var hdr [pagedHeaderSize]byte
+	if fi.Size() == 0 {
+		if ph.pageDepth < 1 || ph.pageDepth > MaxPageDepth {
+			return errors.Errorf("pageDepth %d not in [1, %d]",
+				ph.pageDepth, MaxPageDepth)
+		}
+		ph.pages = make([]page, 1<<(ph.pageDepth*NumIndexBits))
+		ph.end = uint64(pagedHeaderSize)
+		return ph.writeHeader(0)
+	}
+
+	if _, err = ph.f.ReadAt(hdr[:], 0); err != nil {
+		return err
+	}
+	if string(hdr[:len(pagedMagic)]) != pagedMagic {
+		return errors.New("bad magic")
+	}
+	var p = len(pagedMagic)
+	if v := binary.LittleEndian.Uint32(hdr[p:]); v != pagedVersion {
+		return errors.Errorf("unsupported version %d", v)
+	}
+	ph.pageDepth, ph.tblOpt = uint(hdr[p+4]), int(hdr[p+5])
+	if ph.pageDepth < 1 || ph.pageDepth > MaxPageDepth {
+		return errors.Errorf("bad pageDepth %d", ph.pageDepth)
+	}
+	var dir = binary.LittleEndian.Uint64(hdr[p+8:])
+
+	ph.pages = make([]page, 1<<(ph.pageDepth*NumIndexBits))
+	ph.end = uint64(fi.Size())
+
+	// Everything after the header that is not the directory or a page is
+	// free.
+	var used []extent
+	if dir != 0 {
+		ph.dir = extent{dir, uint64(8 + 16*len(ph.pages))}
+		// dir > ph.end first, so ph.end-dir can not wrap around
+		if dir < uint64(pagedHeaderSize) || dir > ph.end ||
+			ph.dir.size > ph.end-dir {
+			return errors.Errorf("bad directory offset %d", dir)
+		}
+		var buf = make([]byte, ph.dir.size)
+		if _, err = ph.f.ReadAt(buf, int64(dir)); err != nil {
+			return err
+		}
+		ph.nentries = uint(binary.LittleEndian.Uint64(buf))
+		for i := range ph.pages {
+			var pg = &ph.pages[i]
+			pg.off = binary.LittleEndian.Uint64(buf[8+16*i:])
+			pg.size = binary.LittleEndian.Uint64(buf[16+16*i:])
+			if pg.size == 0 {
+				continue
+			}
+			if pg.off < uint64(pagedHeaderSize) || pg.off > ph.end ||
+				pg.size > ph.end-pg.off {
+				return errors.Errorf("bad offset of page %d", i)
+			}
+			used = append(used, extent{pg.off, pg.size})
+		}
+		used = append(used, ph.dir)
+	}
+
+	sort.Slice(used, func(i, j int) bool { return used[i].off < used[j].off })
+	var off = uint64(pagedHeaderSize)
+	for _, ext := range used {
+		if ext.off < off {
+			return errors.Errorf("overlapping pages at offset %d", ext.off)
+		}
+		ph.release(extent{off, ext.off - off})
+		off = ext.off + ext.size
+	}
+	ph.release(extent{off, ph.end - off})
+
+	return nil
+}
+
+// alloc returns the offset of size bytes of the page file to write to: the
+// first free extent they fit in, or the end of the file.
+func (ph *PagedHamt) alloc(size uint64) uint64 {
+	for i, ext := range ph.free {
+		if ext.size < size {
+			continue
+		}
+		if ext.size == size {
+			ph.free = append(ph.free[:i], ph.free[i+1:]...)
+		} else {
+			ph.free[i] = extent{ext.off + size, ext.size - size}
+		}
+		return ext.off
+	}
+
+	var off = ph.end
+	ph.end += size
+	return off
+}
+
+// release adds ext to the free extents, merged with its neighbours. A free
+// extent at the end of the file is taken off the file instead.
+func (ph *PagedHamt) release(ext extent) {
+	if ext.size == 0 {
+		return
+	}
+
+	var i = sort.Search(len(ph.free), func(i int) bool {
+		return ph.free[i].off > ext.off
+	})
+	if i < len(ph.free) && ext.off+ext.size == ph.free[i].off {
+		ext.size += ph.free[i].size
+		ph.free = append(ph.free[:i], ph.free[i+1:]...)
+	}
+	if i > 0 && ph.free[i-1].off+ph.free[i-1].size == ext.off {
+		i--
+		ext = extent{ph.free[i].off, ph.free[i].size + ext.size}
+		ph.free = append(ph.free[:i], ph.free[i+1:]...)
+	}
+
+	if ext.off+ext.size == ph.end {
+		ph.end = ext.off
+		return
+	}
+	ph.free = append(ph.free, extent{})
+	copy(ph.free[i+1:], ph.free[i:])
+	ph.free[i] = ext
+}
+
+// retire releases ext, which a write has replaced, if the file as of the last
+// Flush does not use it; otherwise it is released by the next Flush.
+func (ph *PagedHamt) retire(ext extent, fresh bool) {
+	if fresh {
+		ph.release(ext)
+	} else if ext.size > 0 {
+		ph.pending = append(ph.pending, ext)
+	}
+}
+
+func (ph *PagedHamt) writeHeader(dir uint64) error {
+	var hdr = make([]byte, 0, pagedHeaderSize)
+	hdr = append(hdr, pagedMagic...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, pagedVersion)
+	hdr = append(hdr, byte(ph.pageDepth), byte(ph.tblOpt), 0, 0)
+	hdr = binary.LittleEndian.AppendUint64(hdr, dir)
+
+	var _, err = ph.f.WriteAt(hdr, 0)
+	return err
+}
+
+// page returns the page of the HashVal hv, read into the cache.
+func (ph *PagedHamt) page(hv HashVal) (*page, error) {
+	if ph.f == nil {
+		return nil, errors.New("PagedHamt is closed")
+	}
+
+	var pg = &ph.pages[hv.hashPath(ph.pageDepth)]
+
+	if pg.h != nil {
+		ph.stats.Hits++
+		ph.lru.MoveToFront(pg.elem)
+		return pg, nil
+	}
+	ph.stats.Misses++
+
+	for ph.lru.Len() >= ph.cacheSize {
+		if err := ph.evict(); err != nil {
+			return nil, err
+		}
+	}
+
+	if pg.size == 0 {
+		pg.h = NewTransient(ph.tblOpt)
+	} else {
+		var buf = make([]byte, pg.size)
+		if _, err := ph.f.ReadAt(buf, int64(pg.off)); err != nil {
+			return nil, errors.Wrapf(err, "failed to read page at %d", pg.off)
+		}
+		var h, err = Decode(bytes.NewReader(buf), false, ph.reg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "page at %d", pg.off)
+		}
+		pg.h = h.(*HamtTransient)
+	}
+	pg.elem = ph.lru.PushFront(pg)
+
+	return pg, nil
+}
+
+// evict drops the least recently used page from the cache, after writing it
+// if it is dirty.
+func (ph *PagedHamt) evict() error {
+	var elem = ph.lru.Back()
+	var pg = elem.Value.(*page)
+
+	if err := ph.writePage(pg); err != nil {
+		return err
+	}
+
+	ph.lru.Remove(elem)
+	pg.h, pg.elem = nil, nil
+	ph.stats.Evictions++
+
+	return nil
+}
+
+// writePage writes pg to the page file, if it is dirty, into a free extent
+// or at the end.
+func (ph *PagedHamt) writePage(pg *page) error {
+	if !pg.dirty {
+		return nil
+	}
+	ph.changed = true
+
+	var old = extent{pg.off, pg.size}
+	if pg.h.IsEmpty() {
+		ph.retire(old, pg.fresh)
+		pg.off, pg.size, pg.dirty, pg.fresh = 0, 0, false, false
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, pg.h, ph.reg); err != nil {
+		return err
+	}
+	var size = uint64(buf.Len())
+	var off = ph.alloc(size)
+	if _, err := ph.f.WriteAt(buf.Bytes(), int64(off)); err != nil {
+		ph.release(extent{off, size})
+		return errors.Wrap(err, "failed to write page")
+	}
+
+	ph.retire(old, pg.fresh)
+	pg.off, pg.size, pg.dirty, pg.fresh = off, size, false, true
+	ph.stats.Writes++
+
+	return nil
+}
+
+// Nentries returns the number of (key,value) pairs in the PagedHamt.
+func (ph *PagedHamt) Nentries() uint {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	return ph.nentries
+}
+
+// IsEmpty returns if the PagedHamt has no entries.
+func (ph *PagedHamt) IsEmpty() bool {
+	return ph.Nentries() == 0
+}
+
+// Stats returns the cache counters of the PagedHamt.
+func (ph *PagedHamt) Stats() PagedStats {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	var stats = ph.stats
+	stats.Cached = ph.lru.Len()
+	return stats
+}
+
+// Get retrieves the value related to the key, and whether it was found.
+func (ph *PagedHamt) Get(key KeyI) (interface{}, bool, error) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	var pg, err = ph.page(key.Hash())
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Get")
+	}
+
+	var val, found = pg.h.Get(key)
+	return val, found, nil
+}
+
+// Put stores the key,value pair, and returns if the key was added, as
+// opposed to replaced.
+func (ph *PagedHamt) Put(key KeyI, val interface{}) (bool, error) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	var pg, err = ph.page(key.Hash())
+	if err != nil {
+		return false, errors.Wrap(err, "Put")
+	}
+
+	var _, added = pg.h.Put(key, val)
+	pg.dirty = true
+	if added {
+		ph.nentries++
+	}
+	return added, nil
+}
+
+// Del deletes the key, and returns its value and whether it was found.
+func (ph *PagedHamt) Del(key KeyI) (interface{}, bool, error) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	var pg, err = ph.page(key.Hash())
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Del")
+	}
+
+	var _, val, deleted = pg.h.Del(key)
+	if deleted {
+		pg.dirty = true
+		ph.nentries--
+	}
+	return val, deleted, nil
+}
+
+// Range calls fn for every KeyVal pair, page by page, until fn returns false.
+// Each page is read into the cache in turn, so a Range over a PagedHamt
+// larger than the cache reads the whole page file. fn must not call the
+// methods of ph.
+func (ph *PagedHamt) Range(fn func(KeyI, interface{}) bool) error {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	var keepOn = true
+	for i := 0; keepOn && i < len(ph.pages); i++ {
+		var pg = &ph.pages[i]
+		if pg.h == nil && pg.size == 0 {
+			continue
+		}
+
+		// Any key of the page finds the page.
+		var pg2, err = ph.page(HashVal(i))
+		if err != nil {
+			return errors.Wrap(err, "Range")
+		}
+		pg2.h.Range(func(k KeyI, v interface{}) bool {
+			keepOn = fn(k, v)
+			return keepOn
+		})
+	}
+	return nil
+}
+
+// Flush writes every changed page, and a directory of the pages, to the page
+// file, and fsyncs it. The pages stay in the cache.
+func (ph *PagedHamt) Flush() error {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	return errors.Wrap(ph.flush(), "Flush")
+}
+
+func (ph *PagedHamt) flush() error {
+	if ph.f == nil {
+		return errors.New("PagedHamt is closed")
+	}
+
+	for e := ph.lru.Front(); e != nil; e = e.Next() {
+		if err := ph.writePage(e.Value.(*page)); err != nil {
+			return err
+		}
+	}
+
+	if !ph.changed {
+		return nil
+	}
+
+	var buf = make([]byte, 0, 8+16*len(ph.pages))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(ph.nentries))
+	for i := range ph.pages {
+		buf = binary.LittleEndian.AppendUint64(buf, ph.pages[i].off)
+		buf = binary.LittleEndian.AppendUint64(buf, ph.pages[i].size)
+	}
+
+	var dir = extent{ph.alloc(uint64(len(buf))), uint64(len(buf))}
+	var _, err = ph.f.WriteAt(buf, int64(dir.off))
+
+	// The directory must be on disk before the header points to it.
+	if err == nil {
+		err = ph.f.Sync()
+	}
+	if err != nil {
+		ph.release(dir)
+		return err
+	}
+	if err = ph.writeHeader(dir.off); err != nil {
+		return err
+	}
+	if err = ph.f.Sync(); err != nil {
+		return err
+	}
+
+	// The file no longer uses what the new directory replaced.
+	ph.retire(ph.dir, false)
+	ph.dir = dir
+	for _, ext := range ph.pending {
+		ph.release(ext)
+	}
+	ph.pending = ph.pending[:0]
+	for i := range ph.pages {
+		ph.pages[i].fresh = false
+	}
+	ph.changed = false
+
+	return ph.f.Truncate(int64(ph.end))
+}
+
+// Close flushes the PagedHamt and closes the page file. The PagedHamt can
+// not be used afterwards.
+func (ph *PagedHamt) Close() error {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	if ph.f == nil {
+		return nil
+	}
+	var err = ph.flush()
+	if cerr := ph.f.Close(); err == nil {
+		err = cerr
+	}
+	ph.f = nil
+	for e := ph.lru.Front(); e != nil; e = e.Next() {
+		e.Value.(*page).h = nil
+	}
+	ph.lru.Init()
+	return errors.Wrap(err, "Close")
+}
@@ -0,0 +1,303 @@
+package hamt64_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numPagedKvs = 4 * 1024
+
+// checkPaged64 checks that ph holds exactly the expected pairs, by Get and by
+// Range.
+func checkPaged64(
+	t *testing.T,
+	name string,
+	ph *hamt64.PagedHamt,
+	expected map[hamt64.StringKey]interface{},
+) {
+	if ph.Nentries() != uint(len(expected)) {
+		t.Fatalf("%s: ph.Nentries() %d != %d",
+			name, ph.Nentries(), len(expected))
+	}
+	for k, v := range expected {
+		var val, found, err = ph.Get(k)
+		if err != nil || !found || val != v {
+			t.Fatalf("%s: ph.Get(%s) => %v, %t, %v; expected %v",
+				name, k, val, found, err, v)
+		}
+	}
+
+	var n int
+	var err = ph.Range(func(k hamt64.KeyI, v interface{}) bool {
+		if expected[k.(hamt64.StringKey)] != v {
+			t.Fatalf("%s: ph.Range() => %s, %v; expected %v",
+				name, k, v, expected[k.(hamt64.StringKey)])
+		}
+		n++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("%s: failed ph.Range() => %s", name, err)
+	}
+	if n != len(expected) {
+		t.Fatalf("%s: ph.Range() visited %d of %d", name, n, len(expected))
+	}
+}
+
+func TestHamt64Paged(t *testing.T) {
+	var name = "TestHamt64Paged:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numPagedKvs]
+	var path = filepath.Join(t.TempDir(), "hamt.pages")
+	var cacheSize = 4
+
+	var ph, err = hamt64.OpenPaged(path, TableOption, 1, cacheSize, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenPaged() => %s", name, err)
+	}
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs {
+		var added, err = ph.Put(kv.Key, kv.Val)
+		if err != nil || !added {
+			t.Fatalf("%s: ph.Put(%s) => %t, %v", name, kv.Key, added, err)
+		}
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	for _, kv := range kvs[:len(kvs)/4] {
+		var val, deleted, err = ph.Del(kv.Key)
+		if err != nil || !deleted || val != kv.Val {
+			t.Fatalf("%s: ph.Del(%s) => %v, %t, %v",
+				name, kv.Key, val, deleted, err)
+		}
+		delete(expected, kv.Key.(hamt64.StringKey))
+	}
+	if _, deleted, _ := ph.Del(kvs[0].Key); deleted {
+		t.Fatalf("%s: ph.Del() of a deleted key => true", name)
+	}
+	checkPaged64(t, name, ph, expected)
+
+	var stats = ph.Stats()
+	if stats.Cached > cacheSize {
+		t.Fatalf("%s: %d pages cached; cacheSize %d",
+			name, stats.Cached, cacheSize)
+	}
+	if stats.Misses == 0 || stats.Hits == 0 || stats.Evictions == 0 ||
+		stats.Writes == 0 {
+		t.Fatalf("%s: ph.Stats() => %+v", name, stats)
+	}
+
+	// the page of a key just read is cached
+	ph.Get(kvs[len(kvs)-1].Key)
+	var before = ph.Stats()
+	ph.Get(kvs[len(kvs)-1].Key)
+	var after = ph.Stats()
+	if after.Hits != before.Hits+1 || after.Misses != before.Misses {
+		t.Fatalf("%s: two Get()s of the same key => %+v; was %+v",
+			name, after, before)
+	}
+
+	if err = ph.Flush(); err != nil {
+		t.Fatalf("%s: failed ph.Flush() => %s", name, err)
+	}
+	var fi, _ = os.Stat(path)
+	if err = ph.Flush(); err != nil {
+		t.Fatalf("%s: failed ph.Flush() => %s", name, err)
+	}
+	if fi2, _ := os.Stat(path); fi2.Size() != fi.Size() {
+		t.Fatalf("%s: Flush() without changes grew the file %d => %d",
+			name, fi.Size(), fi2.Size())
+	}
+
+	ph.Put(kvs[0].Key, -1)
+	expected[kvs[0].Key.(hamt64.StringKey)] = -1
+	if err = ph.Close(); err != nil {
+		t.Fatalf("%s: failed ph.Close() => %s", name, err)
+	}
+	if _, _, err = ph.Get(kvs[0].Key); err == nil {
+		t.Fatalf("%s: ph.Get() after ph.Close() succeeded", name)
+	}
+
+	// the pageDepth of an existing page file can not be changed
+	ph, err = hamt64.OpenPaged(path, TableOption, 2, 1, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenPaged() of existing => %s", name, err)
+	}
+	checkPaged64(t, name, ph, expected)
+	ph.Close()
+}
+
+func TestHamt64PagedErrors(t *testing.T) {
+	var name = "TestHamt64PagedErrors:" + hamt64.TableOptionName[TableOption]
+	var dir = t.TempDir()
+
+	for _, depth := range []uint{0, hamt64.MaxPageDepth + 1} {
+		var path = filepath.Join(dir, "depth.pages")
+		var _, err = hamt64.OpenPaged(path, TableOption, depth, 1, nil)
+		if err == nil {
+			t.Fatalf("%s: OpenPaged() with pageDepth %d succeeded",
+				name, depth)
+		}
+		os.Remove(path)
+	}
+
+	var path = filepath.Join(dir, "bad.pages")
+	os.WriteFile(path, []byte("not a page file at all"), 0644)
+	if _, err := hamt64.OpenPaged(path, TableOption, 1, 1, nil); err == nil {
+		t.Fatalf("%s: OpenPaged() of a bad file succeeded", name)
+	}
+}
+
+// TestHamt64PagedCorrupt checks that OpenPaged fails on a truncated page file,
+// and on one whose directory points past the end of the file.
+func TestHamt64PagedCorrupt(t *testing.T) {
+	var name = "TestHamt64PagedCorrupt:" + hamt64.TableOptionName[TableOption]
+	var dir = t.TempDir()
+	var path = filepath.Join(dir, "hamt.pages")
+
+	var ph, err = hamt64.OpenPaged(path, TableOption, 1, 4, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenPaged() => %s", name, err)
+	}
+	for _, kv := range KVS64[:numPagedKvs] {
+		ph.Put(kv.Key, kv.Val)
+	}
+	if err = ph.Close(); err != nil {
+		t.Fatalf("%s: failed ph.Close() => %s", name, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the header is "HAMTPAGE" version:uint32 4 bytes dir:uint64, and the
+	// directory nentries:uint64 npages*(off:uint64 size:uint64)
+	var dirOff = binary.LittleEndian.Uint64(data[16:])
+
+	var corrupt = func(what string, data []byte) {
+		var path = filepath.Join(dir, "corrupt.pages")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		var ph, err = hamt64.OpenPaged(path, TableOption, 1, 4, nil)
+		if err == nil {
+			ph.Close()
+			t.Fatalf("%s: OpenPaged() of a page file %s succeeded",
+				name, what)
+		}
+	}
+
+	corrupt("cut short in the header", data[:20])
+	corrupt("cut short before the directory", data[:dirOff-1])
+	corrupt("cut short in the directory", data[:dirOff+100])
+
+	for _, off := range []uint64{uint64(len(data)) + 1, 1 << 63} {
+		var bad = append([]byte(nil), data...)
+		binary.LittleEndian.PutUint64(bad[16:], off)
+		corrupt("with its directory past the end", bad)
+
+		bad = append([]byte(nil), data...)
+		for p := dirOff + 8; p < uint64(len(bad)); p += 16 {
+			if binary.LittleEndian.Uint64(bad[p+8:]) != 0 {
+				binary.LittleEndian.PutUint64(bad[p:], off)
+				break
+			}
+		}
+		corrupt("with a page past the end", bad)
+	}
+}
+
+func TestHamt64PagedReuse(t *testing.T) {
+	var name = "TestHamt64PagedReuse:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numPagedKvs]
+	var dir = t.TempDir()
+	var path = filepath.Join(dir, "hamt.pages")
+
+	// every page fits in the cache, so a Flush writes each page once
+	var ph, err = hamt64.OpenPaged(path, TableOption, 1, hamt64.IndexLimit,
+		nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenPaged() => %s", name, err)
+	}
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs {
+		ph.Put(kv.Key, kv.Val)
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	if err = ph.Flush(); err != nil {
+		t.Fatalf("%s: failed ph.Flush() => %s", name, err)
+	}
+	var fi, _ = os.Stat(path)
+	var size = fi.Size()
+
+	// rewriting every page again and again reuses the space of the old ones
+	for round := 1; round <= 20; round++ {
+		for _, kv := range kvs {
+			ph.Put(kv.Key, -round)
+			expected[kv.Key.(hamt64.StringKey)] = -round
+		}
+		if err = ph.Flush(); err != nil {
+			t.Fatalf("%s: failed ph.Flush() => %s", name, err)
+		}
+	}
+	if fi, _ = os.Stat(path); fi.Size() > 3*size {
+		t.Fatalf("%s: 20 rewrites grew the file from %d to %d",
+			name, size, fi.Size())
+	}
+	ph.Close()
+
+	// a crash after evictions wrote into the free space leaves the file as
+	// it was at the last Flush
+	ph, err = hamt64.OpenPaged(path, TableOption, 1, 4, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenPaged() => %s", name, err)
+	}
+	var flushed = make(map[hamt64.StringKey]interface{})
+	for k, v := range expected {
+		flushed[k] = v
+	}
+	for _, kv := range kvs[:len(kvs)/2] {
+		ph.Put(kv.Key, 0)
+		expected[kv.Key.(hamt64.StringKey)] = 0
+	}
+	for _, kv := range kvs[len(kvs)/2 : len(kvs)*3/4] {
+		ph.Del(kv.Key)
+		delete(expected, kv.Key.(hamt64.StringKey))
+	}
+	if ph.Stats().Writes == 0 {
+		t.Fatalf("%s: no page was written before the crash", name)
+	}
+	var data, _ = os.ReadFile(path)
+	var crashed = filepath.Join(dir, "crashed.pages")
+	if err = os.WriteFile(crashed, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cph, err := hamt64.OpenPaged(crashed, TableOption, 1, 4, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenPaged() after a crash => %s", name, err)
+	}
+	checkPaged64(t, name+" crashed", cph, flushed)
+	cph.Close()
+
+	if err = ph.Close(); err != nil {
+		t.Fatalf("%s: failed ph.Close() => %s", name, err)
+	}
+	ph, err = hamt64.OpenPaged(path, TableOption, 1, 4, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenPaged() => %s", name, err)
+	}
+	checkPaged64(t, name, ph, expected)
+
+	// deleting every key frees the whole file
+	for _, kv := range kvs {
+		ph.Del(kv.Key)
+	}
+	ph.Close()
+	if fi, _ = os.Stat(path); fi.Size() > size/2 {
+		t.Fatalf("%s: file of an empty PagedHamt is %d bytes",
+			name, fi.Size())
+	}
+}
@@ -0,0 +1,78 @@
+package hamt64
+
+// Scan calls fn for the KeyVal pairs of the Hamt in trie order, starting at
+// cursor, until it has called fn at least count times. It returns the cursor
+// to pass to the next call, or 0 once every pair has been visited. A scan
+// starts with cursor 0.
+//
+// The cursor is the position of the next leaf in trie order, which only
+// depends on the HashVal of its keys. So a scan can be resumed on a Hamt that
+// was modified since the last call, like a HamtFunctional derived from it:
+// every key present from the first call to the last is visited exactly once,
+// while keys added or deleted in between may or may not be. The pairs of one
+// leaf are never split between two calls, so fn may be called a few more than
+// count times.
+func Scan(
+	h Hamt,
+	cursor uint64,
+	count int,
+	fn func(KeyI, interface{}),
+) uint64 {
+	var s = scanner{cursor: cursor, count: max(count, 1), fn: fn}
+	s.scanNode(&hamtBaseOf(h).root, 0, 0)
+	return s.next
+}
+
+// scanner holds the state of one Scan call. next is the cursor to return; it
+// stays 0 until the scan stops short of the end.
+type scanner struct {
+	cursor uint64
+	count  int
+	n      int
+	next   uint64
+	fn     func(KeyI, interface{})
+}
+
+// trieOrder returns the position of hv in trie order: its DepthLimit indexes
+// read as one number, the index at depth 0 the most significant.
+func trieOrder(hv HashVal) uint64 {
+	var pos uint64
+	for depth := uint(0); depth < DepthLimit; depth++ {
+		pos = pos<<NumIndexBits | uint64(hv.Index(depth))
+	}
+	return pos
+}
+
+// scanNode scans the node n found at the given depth, whose slot starts at
+// the trie position start. It returns false once the scan is stopped.
+func (s *scanner) scanNode(n nodeI, depth uint, start uint64) bool {
+	if l, isLeaf := n.(leafI); isLeaf {
+		var pos = trieOrder(l.Hash())
+		if pos < s.cursor {
+			return true
+		}
+		if s.n >= s.count {
+			s.next = pos
+			return false
+		}
+		for _, kv := range l.keyVals() {
+			s.fn(kv.Key, kv.Val)
+			s.n++
+		}
+		return true
+	}
+
+	var t = n.(tableI)
+	var width = uint64(1) << ((maxDepth - depth) * NumIndexBits)
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var cstart = start + uint64(idx)*width
+		if cstart+width <= s.cursor {
+			continue
+		}
+		var c = t.get(idx)
+		if c != nil && !s.scanNode(c, depth+1, cstart) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numScanKvs = 20 * 1024
+
+func TestHamt64Scan(t *testing.T) {
+	var name = "TestHamt64Scan:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numScanKvs]
+
+	var h, err = buildHamt64(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var seen = make(map[hamt64.KeyI]int)
+	var n int
+	var visit = func(k hamt64.KeyI, v interface{}) {
+		seen[k]++
+		n++
+	}
+	var cursor uint64
+	var calls int
+	for {
+		n = 0
+		cursor = hamt64.Scan(h, cursor, 100, visit)
+		calls++
+		if cursor == 0 {
+			break
+		}
+		if n < 100 {
+			t.Fatalf("%s: Scan() visited %d < count pairs before the end",
+				name, n)
+		}
+	}
+	if len(seen) != len(kvs) {
+		t.Fatalf("%s: Scan() visited %d keys of %d", name, len(seen), len(kvs))
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("%s: Scan() visited %s %d times", name, k, n)
+		}
+	}
+	if calls < len(kvs)/100 {
+		t.Fatalf("%s: Scan() took %d calls", name, calls)
+	}
+
+	// resuming on a modified Hamt visits every key kept throughout once
+	var half = len(kvs) / 2
+	var fh = hamt64.Hamt(hamt64.Build(kvs[:half], TableOption))
+	seen = make(map[hamt64.KeyI]int)
+	cursor = 0
+	for i := 0; ; i++ {
+		cursor = hamt64.Scan(fh, cursor, 50, visit)
+		if cursor == 0 {
+			break
+		}
+		fh, _ = fh.Put(kvs[half+i].Key, kvs[half+i].Val)
+		if i < half/2 {
+			fh, _, _ = fh.Del(kvs[i].Key)
+		}
+	}
+	for _, kv := range kvs[half/2 : half] {
+		if seen[kv.Key] != 1 {
+			t.Fatalf("%s: Scan() of a changing Hamt visited %s %d times",
+				name, kv.Key, seen[kv.Key])
+		}
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("%s: Scan() visited %s %d times", name, k, n)
+		}
+	}
+
+	var empty = hamt64.NewFunctional(TableOption)
+	if c := hamt64.Scan(empty, 0, 10, func(hamt64.KeyI, interface{}) {
+		t.Fatalf("%s: Scan() of an empty Hamt called fn", name)
+	}); c != 0 {
+		t.Fatalf("%s: Scan() of an empty Hamt => %d", name, c)
+	}
+}
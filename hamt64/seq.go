@@ -0,0 +1,59 @@
+package hamt64
+
+import (
+	"iter"
+)
+
+// All returns an iter.Seq2 of every KeyVal pair in the Hamt, in the same
+// order as Range(). Breaking out of the range loop stops the traversal just
+// like returning false from the Range() callback does.
+//
+//	for k, v := range h.All() {
+//	    ...
+//	}
+func (h *hamtBase) All() iter.Seq2[KeyI, interface{}] {
+	return func(yield func(KeyI, interface{}) bool) {
+		h.Range(yield)
+	}
+}
+
+// Keys returns an iter.Seq of every key in the Hamt, in the same order as
+// Range().
+func (h *hamtBase) Keys() iter.Seq[KeyI] {
+	return func(yield func(KeyI) bool) {
+		h.Range(func(k KeyI, _ interface{}) bool {
+			return yield(k)
+		})
+	}
+}
+
+// Values returns an iter.Seq of every value in the Hamt, in the same order as
+// Range().
+func (h *hamtBase) Values() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		h.Range(func(_ KeyI, v interface{}) bool {
+			return yield(v)
+		})
+	}
+}
+
+// FromSeq2 constructs a Hamt from every key,value pair produced by seq. Later
+// pairs replace the values of earlier pairs with an equal key.
+//
+// The functional and tblOpt arguments are the same as for New(). The Hamt is
+// always built with the faster transient Put and made Persistent afterwards
+// if functional is true.
+func FromSeq2(
+	seq iter.Seq2[KeyI, interface{}],
+	functional bool,
+	tblOpt int,
+) Hamt {
+	var h = NewTransient(tblOpt)
+	for k, v := range seq {
+		h.Put(k, v)
+	}
+	if functional {
+		return h.Persistent()
+	}
+	return h
+}
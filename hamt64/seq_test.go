@@ -0,0 +1,103 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numSeqKvs = 100 * 1024
+
+func TestHamt64All(t *testing.T) {
+	var name = "TestHamt64All:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numSeqKvs]
+
+	var h, err = buildHamt64(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var n uint
+	for k, v := range h.All() {
+		var val, found = h.Get(k)
+		if !found || val != v {
+			t.Fatalf("%s: All() yielded (%s, %v) not in h", name, k, v)
+		}
+		n++
+	}
+	if n != h.Nentries() {
+		t.Fatalf("%s: All() yielded %d pairs; h.Nentries()=%d",
+			name, n, h.Nentries())
+	}
+
+	n = 0
+	for k := range h.Keys() {
+		if _, found := h.Get(k); !found {
+			t.Fatalf("%s: Keys() yielded %s not in h", name, k)
+		}
+		n++
+	}
+	if n != h.Nentries() {
+		t.Fatalf("%s: Keys() yielded %d keys; h.Nentries()=%d",
+			name, n, h.Nentries())
+	}
+
+	n = 0
+	for range h.Values() {
+		n++
+	}
+	if n != h.Nentries() {
+		t.Fatalf("%s: Values() yielded %d values; h.Nentries()=%d",
+			name, n, h.Nentries())
+	}
+}
+
+func TestHamt64AllBreak(t *testing.T) {
+	var name = "TestHamt64AllBreak:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numSeqKvs]
+
+	var h, err = buildHamt64(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	// A range loop panics if the Seq calls yield after the loop broke, so
+	// simply getting through this loop proves the walk stopped.
+	var n int
+	for range h.All() {
+		n++
+		if n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Fatalf("%s: n,%d != 10", name, n)
+	}
+}
+
+func TestHamt64FromSeq2(t *testing.T) {
+	var name = "TestHamt64FromSeq2:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numSeqKvs]
+
+	var h, err = buildHamt64(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var nh = hamt64.FromSeq2(h.All(), Functional, TableOption)
+	if nh.Nentries() != h.Nentries() {
+		t.Fatalf("%s: nh.Nentries(),%d != h.Nentries(),%d",
+			name, nh.Nentries(), h.Nentries())
+	}
+
+	for _, kv := range kvs {
+		var val, found = nh.Get(kv.Key)
+		if !found || val != kv.Val {
+			t.Fatalf("%s: nh.Get(%s) => %v, %t", name, kv.Key, val, found)
+		}
+	}
+
+	if _, isFunctional := nh.(*hamt64.HamtFunctional); isFunctional != Functional {
+		t.Fatalf("%s: FromSeq2 returned %T", name, nh)
+	}
+}
@@ -0,0 +1,71 @@
+package hamt64
+
+import (
+	"iter"
+)
+
+// Set defines the interface that both the SetFunctional and SetTransient data
+// structures implement. A Set is a Hamt without values; its leafs only hold
+// keys, so it does not waste a value slot per entry the way
+// h.Put(key, struct{}{}) does.
+//
+// A Set is built from the same fixedTable and sparseTable interior nodes as a
+// Hamt, so the HybridTables, SparseTables, and FixedTables options behave
+// exactly the same way.
+type Set interface {
+	IsEmpty() bool
+	Len() uint
+	ToFunctional() Set
+	ToTransient() Set
+	DeepCopy() Set
+	Contains(KeyI) bool
+	Add(KeyI) (Set, bool)
+	Remove(KeyI) (Set, bool)
+	String() string
+	LongString(string) string
+	Range(func(KeyI) bool)
+	All() iter.Seq[KeyI]
+	ToHamt(func(KeyI) interface{}) Hamt
+	Stats() *Stats
+	walk(visitFn) bool
+}
+
+// NewSet constructs a datastructure that implements the Set interface.
+//
+// When the functional argument is true it implements a SetFunctional data
+// structure. When the functional argument is false it implements a
+// SetTransient data structure.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+func NewSet(functional bool, tblOpt int) Set {
+	if functional {
+		return NewSetFunctional(tblOpt)
+	}
+	return NewSetTransient(tblOpt)
+}
+
+// KeySet constructs a Set of every key in the Hamt h. The Set has the same
+// table option as h, and it is a SetFunctional if h is a HamtFunctional,
+// otherwise it is a SetTransient.
+func KeySet(h Hamt) Set {
+	var functional bool
+	var tblOpt int
+	switch x := h.(type) {
+	case *HamtFunctional:
+		functional, tblOpt = true, x.tableOption()
+	case *HamtTransient:
+		functional, tblOpt = false, x.tableOption()
+	}
+
+	var s = NewSetTransient(tblOpt)
+	h.Range(func(k KeyI, _ interface{}) bool {
+		s.Add(k)
+		return true
+	})
+
+	if functional {
+		return s.ToFunctional()
+	}
+	return s
+}
@@ -0,0 +1,245 @@
+package hamt64
+
+import (
+	"fmt"
+	"iter"
+)
+
+// This is here as the Set base data structure. It mirrors hamtBase.
+type setBase struct {
+	root       fixedTable
+	nentries   uint
+	nograde    bool
+	startFixed bool
+	edit       *editToken // only used by SetTransient
+}
+
+func (s *setBase) init(tblOpt int) {
+	// boolean zero value is false
+	switch tblOpt {
+	case HybridTables:
+		s.nograde = false
+	case SparseTables:
+		s.nograde = true
+	case FixedTables:
+		s.nograde = true
+		s.startFixed = true
+	}
+}
+
+// tableOption returns the table option, HybridTables, SparseTables, xor
+// FixedTables, that init() was called with.
+func (s *setBase) tableOption() int {
+	switch {
+	case s.startFixed:
+		return FixedTables
+	case s.nograde:
+		return SparseTables
+	}
+	return HybridTables
+}
+
+// IsEmpty simply returns if the Set datastucture has no entries.
+func (s *setBase) IsEmpty() bool {
+	return s.nentries == 0
+}
+
+// Len returns the number of keys stored in the Set data structure.
+func (s *setBase) Len() uint {
+	return s.nentries
+}
+
+// set makes s the same as o; see fixedTable.set.
+func (s *setBase) set(o *setBase) {
+	s.root.set(&o.root)
+	s.nentries = o.nentries
+	s.nograde = o.nograde
+	s.startFixed = o.startFixed
+	s.edit = o.edit
+}
+
+// deepCopyTo makes ns a copy of s, with a copy of every table of s.
+func (s *setBase) deepCopyTo(ns *setBase) {
+	ns.root.set(s.root.deepCopy().(*fixedTable))
+	ns.nentries = s.nentries
+	ns.nograde = s.nograde
+	ns.startFixed = s.startFixed
+}
+
+func (s *setBase) find(hv HashVal) (tableStack, setLeafI, uint) {
+	var curTable tableI = &s.root
+
+	var path = newTableSlice() //conforms to tableStack interface
+	var leaf setLeafI
+	var idx uint
+
+DepthIter:
+	for depth := uint(0); depth <= maxDepth; depth++ {
+		path.push(curTable)
+		idx = hv.Index(depth)
+		var curNode = curTable.get(idx)
+
+		switch n := curNode.(type) {
+		case nil:
+			leaf = nil
+			break DepthIter
+		case setLeafI:
+			leaf = n
+			break DepthIter
+		case tableI:
+			curTable = n
+		}
+	}
+
+	return path, leaf, idx
+}
+
+// Contains returns true if the key is in the Set data structure.
+func (s *setBase) Contains(key KeyI) bool {
+	if s.IsEmpty() {
+		return false
+	}
+
+	var hv = key.Hash()
+	var curTable tableI = &s.root
+
+	for depth := uint(0); depth <= maxDepth; depth++ {
+		var idx = hv.Index(depth)
+		var curNode = curTable.get(idx) //nodeI
+
+		switch n := curNode.(type) {
+		case nil:
+			return false
+		case setLeafI:
+			return n.contains(key)
+		case tableI:
+			curTable = n
+		}
+	}
+
+	return false
+}
+
+// createTable is the Set version of createFixedTable and createSparseTable.
+// Which kind of table is created depends on the table option of the Set.
+func (s *setBase) createTable(
+	depth uint,
+	leaf1 setLeafI,
+	leaf2 *setFlatLeaf,
+) tableI {
+	_ = assertOn && assertf(depth > 0, "createTable(): depth,%d < 1", depth)
+
+	var hashPath = leaf1.Hash().hashPath(depth)
+
+	var retTable tableI
+	if s.startFixed {
+		var ft = new(fixedTable)
+		ft.hashPath = hashPath
+		ft.depth = depth
+		retTable = ft
+	} else {
+		var st = new(sparseTable)
+		st.hashPath = hashPath
+		st.depth = depth
+		st.nodes = make([]nodeI, 0, sparseTableInitCap)
+		retTable = st
+	}
+
+	var idx1 = leaf1.Hash().Index(depth)
+	var idx2 = leaf2.Hash().Index(depth)
+	if idx1 != idx2 {
+		retTable.insert(idx1, leaf1)
+		retTable.insert(idx2, leaf2)
+	} else { //idx1 == idx2
+		var node nodeI
+		if depth == maxDepth {
+			node = newSetCollisionLeaf(append(leaf1.keys(), leaf2.keys()...))
+		} else {
+			node = s.createTable(depth+1, leaf1, leaf2)
+		}
+		retTable.insert(idx1, node)
+	}
+
+	return retTable
+}
+
+// String returns a string representation of the setBase stastructure.
+func (s *setBase) String() string {
+	return fmt.Sprintf(
+		"setBase{ nentries: %d, root: %s }",
+		s.nentries,
+		s.root.String(),
+	)
+}
+
+// LongString returns a complete recusive listing of the entire setBase
+// data structure.
+func (s *setBase) LongString(indent string) string {
+	var str string
+
+	str = indent +
+		fmt.Sprintf("setBase{ nentries: %d, root:\n", s.nentries)
+	str += indent + s.root.LongString(indent, 0)
+	str += indent + "} //setBase"
+
+	return str
+}
+
+// walk traverses the Trie in pre-order traversal. For a Trie this is also a
+// in-order traversal of all leaf nodes.
+//
+// walk returns false if the traversal stopped early.
+func (s *setBase) walk(fn visitFn) bool {
+	return s.root.visit(fn)
+}
+
+// Range executes the given function for every key in the Set. Keys are
+// visited in a seeminly random order; see Hamt.Range().
+func (s *setBase) Range(fn func(KeyI) bool) {
+	var visitLeafs = func(n nodeI) bool {
+		var keepOn = true
+
+		if x, isLeaf := n.(setLeafI); isLeaf {
+			for _, k := range x.keys() {
+				if !fn(k) {
+					keepOn = false
+					break //for
+				}
+			}
+		}
+
+		return keepOn
+	}
+
+	s.walk(visitLeafs)
+}
+
+// All returns an iter.Seq of every key in the Set, in the same order as
+// Range().
+func (s *setBase) All() iter.Seq[KeyI] {
+	return func(yield func(KeyI) bool) {
+		s.Range(yield)
+	}
+}
+
+// toHamt constructs a HamtTransient with every key of the Set. The value of
+// each key is the result of calling val on it; if val is nil every value is
+// nil. The HamtTransient has the same table option as the Set.
+func (s *setBase) toHamt(val func(KeyI) interface{}) *HamtTransient {
+	var h = NewTransient(s.tableOption())
+	s.Range(func(k KeyI) bool {
+		var v interface{}
+		if val != nil {
+			v = val(k)
+		}
+		h.Put(k, v)
+		return true
+	})
+	return h
+}
+
+// Stats walks the Set in a pre-order traversal and populates a Stats data
+// struture which it returns.
+func (s *setBase) Stats() *Stats {
+	return calcStats(&s.root)
+}
@@ -0,0 +1,222 @@
+package hamt64
+
+// SetFunctional is the Set counterpart of HamtFunctional. Add() and Remove()
+// are copy-on-write, the original SetFunctional isn't modified and a slightly
+// modified copy is returned. So sharing this data structure between threads is
+// safe.
+type SetFunctional struct {
+	setBase
+}
+
+// NewSetFunctional constructs a new SetFunctional data structure.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+func NewSetFunctional(tblOpt int) *SetFunctional {
+	var s = new(SetFunctional)
+
+	s.setBase.init(tblOpt)
+
+	return s
+}
+
+// ToFunctional does nothing to a SetFunctional pointer. This method
+// only here for conformance with the Set interface.
+func (s *SetFunctional) ToFunctional() Set {
+	return s
+}
+
+// ToTransient returns a SetTransient with the contents of the SetFunctional,
+// in constant time. Like HamtFunctional.ToTransient, the two share every
+// table, and the SetTransient copies a table the first time it modifies it,
+// so modifying it never changes the SetFunctional.
+func (s *SetFunctional) ToTransient() Set {
+	var ns = new(SetTransient)
+	ns.set(&s.setBase)
+	ns.edit = new(editToken)
+	return ns
+}
+
+// DeepCopy copies the SetFunctional data structure and every table it
+// contains recursively.
+func (s *SetFunctional) DeepCopy() Set {
+	var ns = new(SetFunctional)
+	s.setBase.deepCopyTo(&ns.setBase)
+	return ns
+}
+
+// persist() is ONLY called on a fresh copy of the current Set.
+// Hence, modifying it is allowed.
+func (s *SetFunctional) persist(oldTable, newTable tableI, path tableStack) {
+	_ = assertOn && assert(path.len() != 0,
+		"path.len()==0; This case should be handled directly in Add & Remove.")
+
+	var depth = uint(path.len()) //guaranteed depth > 0
+	var parentDepth = depth - 1
+
+	var parentIdx = oldTable.Hash().Index(parentDepth)
+
+	var oldParent = path.pop()
+
+	var newParent tableI
+	if path.len() == 0 {
+		s.root.set(oldParent.(*fixedTable))
+		newParent = &s.root
+	} else {
+		newParent = oldParent.copy()
+	}
+
+	if newTable == nil {
+		newParent.remove(parentIdx)
+	} else {
+		newParent.replace(parentIdx, newTable)
+	}
+
+	if path.len() > 0 {
+		s.persist(oldParent, newParent, path)
+	}
+}
+
+// Add stores the key in the SetFunctional data structure. It returns a bool
+// indicating if the key was added (true) or was already in the Set (false).
+//
+// If the key was added the returned Set is a new SetFunctional data structure
+// containing the modification, otherwise it is the original SetFunctional.
+func (s *SetFunctional) Add(key KeyI) (Set, bool) {
+	var hv = key.Hash()
+
+	var path, leaf, idx = s.find(hv)
+
+	if leaf != nil && leaf.Hash() == hv && leaf.contains(key) {
+		return s, false
+	}
+
+	var ns = new(SetFunctional)
+	ns.set(&s.setBase)
+
+	var curTable = path.pop()
+	var depth = uint(path.len())
+
+	var node nodeI
+	if leaf != nil {
+		if leaf.Hash() == hv {
+			node, _ = leaf.add(key)
+		} else {
+			node = ns.createTable(depth+1, leaf, newSetFlatLeaf(key))
+		}
+	}
+
+	if curTable == &s.root {
+		//copying all s.root into ns.root already done in *ns = *s
+		if leaf == nil {
+			ns.root.insert(idx, newSetFlatLeaf(key))
+		} else {
+			ns.root.replace(idx, node)
+		}
+	} else {
+		var newTable tableI
+
+		if leaf == nil {
+			if !ns.nograde && (curTable.nentries()+1) == UpgradeThreshold {
+				newTable = upgradeToFixedTable(
+					curTable.Hash(), depth, curTable.entries())
+			} else {
+				newTable = curTable.copy()
+			}
+
+			newTable.insert(idx, newSetFlatLeaf(key))
+		} else {
+			newTable = curTable.copy()
+			newTable.replace(idx, node)
+		}
+
+		ns.persist(curTable, newTable, path)
+	}
+
+	ns.nentries++
+
+	return ns, true
+}
+
+// Remove deletes the key from the SetFunctional data structure. It returns a
+// bool indicating if the key was found and removed.
+//
+// If the key was removed the returned Set is a new SetFunctional data
+// structure containing the modification, otherwise it is the original
+// SetFunctional.
+func (s *SetFunctional) Remove(key KeyI) (Set, bool) {
+	if s.IsEmpty() {
+		return s, false
+	}
+
+	var hv = key.Hash()
+	var path, leaf, idx = s.find(hv)
+
+	if leaf == nil {
+		return s, false
+	}
+
+	var newLeaf, removed = leaf.remove(key)
+
+	if !removed {
+		return s, false
+	}
+
+	var curTable = path.pop()
+	var depth = uint(path.len())
+
+	var ns = new(SetFunctional)
+	ns.set(&s.setBase)
+
+	ns.nentries--
+
+	if curTable == &s.root {
+		//copying all s.root into ns.root already done in *ns = *s
+		if newLeaf == nil { //leaf was a setFlatLeaf
+			ns.root.remove(idx)
+		} else { //leaf was a setCollisionLeaf
+			ns.root.replace(idx, newLeaf)
+		}
+	} else {
+		var newTable = curTable.copy()
+
+		if newLeaf == nil { //leaf was a setFlatLeaf
+			newTable.remove(idx)
+
+			// Side-Effects of removing a key from the table
+			var nents = newTable.nentries()
+			switch {
+			case nents == 0:
+				newTable = nil
+			case !s.nograde && nents == DowngradeThreshold:
+				newTable = downgradeToSparseTable(
+					newTable.Hash(), depth, newTable.entries())
+			}
+		} else { //leaf was a setCollisionLeaf
+			newTable.replace(idx, newLeaf)
+		}
+
+		ns.persist(curTable, newTable, path)
+	}
+
+	return ns, true
+}
+
+// ToHamt constructs a HamtFunctional with every key of the SetFunctional. The
+// value of each key is the result of calling val on it; if val is nil every
+// value is nil.
+func (s *SetFunctional) ToHamt(val func(KeyI) interface{}) Hamt {
+	return s.setBase.toHamt(val).ToFunctional()
+}
+
+// String returns a simple string representation of the SetFunctional data
+// structure.
+func (s *SetFunctional) String() string {
+	return "SetFunctional{" + s.setBase.String() + "}"
+}
+
+// LongString returns a complete recusive listing of the entire SetFunctional
+// data structure.
+func (s *SetFunctional) LongString(indent string) string {
+	return "SetFunctional{\n" + indent + s.setBase.LongString(indent) + "\n}"
+}
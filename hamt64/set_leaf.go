@@ -0,0 +1,145 @@
+package hamt64
+
+import (
+	"fmt"
+	"strings"
+)
+
+// setLeafI is the Set version of leafI. Set leafs only hold keys, there is no
+// value slot.
+type setLeafI interface {
+	nodeI
+
+	contains(key KeyI) bool
+	add(key KeyI) (setLeafI, bool)
+	remove(key KeyI) (setLeafI, bool)
+	keys() []KeyI
+}
+
+// implements nodeI
+// implements setLeafI
+type setFlatLeaf struct {
+	key KeyI
+}
+
+func newSetFlatLeaf(key KeyI) *setFlatLeaf {
+	var fl = new(setFlatLeaf)
+	fl.key = key
+	return fl
+}
+
+func (l *setFlatLeaf) Hash() HashVal {
+	return l.key.Hash()
+}
+
+func (l *setFlatLeaf) String() string {
+	return fmt.Sprintf("setFlatLeaf{key: %s}", l.key)
+}
+
+func (l *setFlatLeaf) contains(key KeyI) bool {
+	return l.key.Equals(key)
+}
+
+// add maintains the functional behavior that any modification returns a new
+// leaf and the original remains unaltered. If the key is already in the leaf
+// there is nothing to modify, so the original leaf is returned.
+func (l *setFlatLeaf) add(key KeyI) (setLeafI, bool) {
+	if l.key.Equals(key) {
+		return l, false
+	}
+	return newSetCollisionLeaf([]KeyI{l.key, key}), true
+}
+
+func (l *setFlatLeaf) remove(key KeyI) (setLeafI, bool) {
+	if l.key.Equals(key) {
+		return nil, true //found
+	}
+	return l, false //not found
+}
+
+func (l *setFlatLeaf) keys() []KeyI {
+	return []KeyI{l.key}
+}
+
+func (l *setFlatLeaf) nkeyvals() uint {
+	return 1
+}
+
+func (l *setFlatLeaf) visit(fn visitFn) bool {
+	return fn(l)
+}
+
+// implements nodeI
+// implements setLeafI
+type setCollisionLeaf struct {
+	ks []KeyI
+}
+
+func newSetCollisionLeaf(ks []KeyI) *setCollisionLeaf {
+	var leaf = new(setCollisionLeaf)
+	leaf.ks = append(leaf.ks, ks...)
+	return leaf
+}
+
+func (l *setCollisionLeaf) Hash() HashVal {
+	return l.ks[0].Hash()
+}
+
+func (l *setCollisionLeaf) String() string {
+	var kstrs = make([]string, len(l.ks))
+	for i := 0; i < len(l.ks); i++ {
+		kstrs[i] = fmt.Sprintf("%q", l.ks[i])
+	}
+	var jkstr = strings.Join(kstrs, ",")
+
+	return fmt.Sprintf("setCollisionLeaf{hash:%s, ks:[]KeyI{%s}}",
+		l.ks[0].Hash(), jkstr)
+}
+
+func (l *setCollisionLeaf) contains(key KeyI) bool {
+	for _, k := range l.ks {
+		if k.Equals(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *setCollisionLeaf) add(key KeyI) (setLeafI, bool) {
+	if l.contains(key) {
+		return l, false
+	}
+	var nl = new(setCollisionLeaf)
+	nl.ks = make([]KeyI, len(l.ks)+1)
+	copy(nl.ks, l.ks)
+	nl.ks[len(l.ks)] = key
+	return nl, true
+}
+
+func (l *setCollisionLeaf) remove(key KeyI) (setLeafI, bool) {
+	for i, k := range l.ks {
+		if k.Equals(key) {
+			if len(l.ks) == 2 {
+				return newSetFlatLeaf(l.ks[1-i]), true
+			}
+			var nl = newSetCollisionLeaf(l.ks)
+			nl.ks = append(nl.ks[:i], nl.ks[i+1:]...)
+			return nl, true
+		}
+	}
+	return l, false
+}
+
+func (l *setCollisionLeaf) keys() []KeyI {
+	var r = make([]KeyI, 0, len(l.ks))
+	r = append(r, l.ks...)
+	return r
+}
+
+func (l *setCollisionLeaf) nkeyvals() uint {
+	return uint(len(l.ks))
+}
+
+func (l *setCollisionLeaf) visit(fn visitFn) bool {
+	return fn(l)
+}
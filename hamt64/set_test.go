@@ -0,0 +1,188 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numSetKvs = 100 * 1024
+
+func TestSet64AddContainsRemove(t *testing.T) {
+	var name = "TestSet64AddContainsRemove:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numSetKvs]
+
+	var s = hamt64.NewSet(Functional, TableOption)
+	for _, kv := range kvs {
+		var added bool
+		s, added = s.Add(kv.Key)
+		if !added {
+			t.Fatalf("%s: failed to s.Add(%s)", name, kv.Key)
+		}
+	}
+
+	if s.Len() != uint(len(kvs)) {
+		t.Fatalf("%s: s.Len(),%d != %d", name, s.Len(), len(kvs))
+	}
+	if stats := s.Stats(); stats.KeyVals != uint(len(kvs)) {
+		t.Fatalf("%s: stats.KeyVals,%d != %d", name, stats.KeyVals, len(kvs))
+	}
+
+	var s0 = s
+	var added bool
+	s, added = s.Add(kvs[0].Key)
+	if added || s.Len() != uint(len(kvs)) {
+		t.Fatalf("%s: s.Add(%s) of existing key modified the Set",
+			name, kvs[0].Key)
+	}
+	if Functional && s != s0 {
+		t.Fatalf("%s: s.Add(%s) of existing key returned a new Set",
+			name, kvs[0].Key)
+	}
+
+	for _, kv := range kvs {
+		if !s.Contains(kv.Key) {
+			t.Fatalf("%s: !s.Contains(%s)", name, kv.Key)
+		}
+	}
+	if s.Contains(hamt64.StringKey("not a key")) {
+		t.Fatalf("%s: s.Contains(\"not a key\")", name)
+	}
+
+	var n uint
+	for k := range s.All() {
+		if !s.Contains(k) {
+			t.Fatalf("%s: s.All() yielded %s not in s", name, k)
+		}
+		n++
+	}
+	if n != s.Len() {
+		t.Fatalf("%s: s.All() yielded %d keys; s.Len()=%d", name, n, s.Len())
+	}
+
+	for _, kv := range kvs {
+		var removed bool
+		s, removed = s.Remove(kv.Key)
+		if !removed {
+			t.Fatalf("%s: failed to s.Remove(%s)", name, kv.Key)
+		}
+		if s.Contains(kv.Key) {
+			t.Fatalf("%s: s.Contains(%s) after s.Remove()", name, kv.Key)
+		}
+	}
+	if !s.IsEmpty() {
+		t.Fatalf("%s: !s.IsEmpty() after removing every key", name)
+	}
+}
+
+func TestSet64Persistent(t *testing.T) {
+	var name = "TestSet64Persistent:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numSetKvs]
+
+	var s0 = hamt64.NewSet(true, TableOption)
+	for _, kv := range kvs {
+		s0, _ = s0.Add(kv.Key)
+	}
+
+	var s1 = s0
+	for _, kv := range kvs[:len(kvs)/2] {
+		s1, _ = s1.Remove(kv.Key)
+	}
+
+	if s0.Len() != uint(len(kvs)) || s1.Len() != uint(len(kvs)-len(kvs)/2) {
+		t.Fatalf("%s: s0.Len(),%d s1.Len(),%d", name, s0.Len(), s1.Len())
+	}
+	for i, kv := range kvs {
+		if !s0.Contains(kv.Key) {
+			t.Fatalf("%s: !s0.Contains(%s) after deriving s1", name, kv.Key)
+		}
+		if s1.Contains(kv.Key) != (i >= len(kvs)/2) {
+			t.Fatalf("%s: s1.Contains(%s) wrong", name, kv.Key)
+		}
+	}
+}
+
+// TestSet64ToTransientIsolated checks that writes through the SetTransient of
+// a SetFunctional, before and after ToFunctional, leave it unchanged.
+func TestSet64ToTransientIsolated(t *testing.T) {
+	var name = "TestSet64ToTransientIsolated:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numSetKvs/4]
+	var half = len(kvs) / 2
+
+	var s0 = hamt64.NewSet(true, TableOption)
+	for _, kv := range kvs[:half] {
+		s0, _ = s0.Add(kv.Key)
+	}
+
+	var checkS0 = func(when string) {
+		if s0.Len() != uint(half) {
+			t.Fatalf("%s: s0.Len(),%d != %d %s", name, s0.Len(), half, when)
+		}
+		for i, kv := range kvs {
+			if s0.Contains(kv.Key) != (i < half) {
+				t.Fatalf("%s: s0.Contains(%s) wrong %s", name, kv.Key, when)
+			}
+		}
+	}
+
+	// remove half the keys, and add as many again
+	var ts = s0.ToTransient()
+	for _, kv := range kvs[:half/2] {
+		ts.Remove(kv.Key)
+	}
+	for _, kv := range kvs[half:] {
+		ts.Add(kv.Key)
+	}
+	checkS0("after writes through s0.ToTransient()")
+
+	var s1 = ts.ToFunctional()
+
+	// the transient keeps working after ToFunctional, without touching s1
+	for _, kv := range kvs {
+		ts.Remove(kv.Key)
+	}
+	checkS0("after writes through ts after ToFunctional()")
+	if s1.Len() != uint(len(kvs)-half/2) || !ts.IsEmpty() {
+		t.Fatalf("%s: s1.Len(),%d ts.Len(),%d", name, s1.Len(), ts.Len())
+	}
+	for i, kv := range kvs {
+		if s1.Contains(kv.Key) != (i >= half/2) {
+			t.Fatalf("%s: s1.Contains(%s) wrong", name, kv.Key)
+		}
+	}
+}
+
+func TestSet64KeySet(t *testing.T) {
+	var name = "TestSet64KeySet:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numSetKvs]
+
+	var h, err = buildHamt64(name, kvs, Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var s = hamt64.KeySet(h)
+	if s.Len() != h.Nentries() {
+		t.Fatalf("%s: s.Len(),%d != h.Nentries(),%d",
+			name, s.Len(), h.Nentries())
+	}
+	if _, isFunctional := s.(*hamt64.SetFunctional); isFunctional != Functional {
+		t.Fatalf("%s: KeySet() returned %T", name, s)
+	}
+
+	var nh = s.ToHamt(func(k hamt64.KeyI) interface{} {
+		var v, _ = h.Get(k)
+		return v
+	})
+	if nh.Nentries() != h.Nentries() {
+		t.Fatalf("%s: nh.Nentries(),%d != h.Nentries(),%d",
+			name, nh.Nentries(), h.Nentries())
+	}
+	for _, kv := range kvs {
+		if v, found := nh.Get(kv.Key); !found || v != kv.Val {
+			t.Fatalf("%s: nh.Get(%s) => %v, %t", name, kv.Key, v, found)
+		}
+	}
+}
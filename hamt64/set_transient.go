@@ -0,0 +1,192 @@
+package hamt64
+
+// SetTransient is the Set counterpart of HamtTransient. All modifications are
+// done in-place. So sharing this datastruture between threads is NOT safe
+// unless you were to implement a locking stategy CORRECTLY.
+type SetTransient struct {
+	setBase
+}
+
+// NewSetTransient constructs a new SetTransient data structure.
+//
+// The tblOpt argument is the table option defined by the constants
+// HybridTables, SparseTables, xor FixedTables.
+func NewSetTransient(tblOpt int) *SetTransient {
+	var s = new(SetTransient)
+
+	s.setBase.init(tblOpt)
+
+	return s
+}
+
+// ToFunctional returns a SetFunctional with the contents of the SetTransient
+// underneath the Set interface, in constant time. Like
+// HamtTransient.ToFunctional, the SetFunctional shares every table, and the
+// SetTransient stays usable: it takes a new editToken, and copies a shared
+// table the first time it modifies it.
+func (s *SetTransient) ToFunctional() Set {
+	var ns = new(SetFunctional)
+	ns.set(&s.setBase)
+	ns.edit = nil
+	s.edit = new(editToken)
+	return ns
+}
+
+// ToTransient does nothing to a SetTransient pointer. This method
+// only here for conformance with the Set interface.
+func (s *SetTransient) ToTransient() Set {
+	return s
+}
+
+// DeepCopy copies the SetTransient data structure and every table it
+// contains recursively.
+func (s *SetTransient) DeepCopy() Set {
+	var ns = new(SetTransient)
+	s.setBase.deepCopyTo(&ns.setBase)
+	ns.edit = new(editToken)
+	ns.edit.own(&ns.root)
+	return ns
+}
+
+// editable makes sure the SetTransient owns every table in the path find()
+// returned for hv, so they can be modified in-place; see editToken.claim.
+func (s *SetTransient) editable(hv HashVal, path tableStack) {
+	if s.edit == nil {
+		s.edit = new(editToken)
+	}
+	s.edit.claim(hv, path)
+}
+
+// Add stores the key in the SetTransient data structure. It returns a bool
+// indicating if the key was added (true) or was already in the Set (false).
+// Either way it returns the original SetTransient data structure.
+func (s *SetTransient) Add(key KeyI) (Set, bool) {
+	var hv = key.Hash()
+	var path, leaf, idx = s.find(hv)
+
+	if leaf != nil && leaf.Hash() == hv && leaf.contains(key) {
+		return s, false
+	}
+
+	s.editable(hv, path)
+
+	var curTable = path.pop()
+	var depth = uint(path.len())
+	var added bool
+
+	if leaf == nil {
+		//check if upgrading allowed & if it is required
+		if !s.nograde && curTable != &s.root &&
+			(curTable.nentries()+1) == UpgradeThreshold {
+			var newTable = upgradeToFixedTable(
+				curTable.Hash(), depth, curTable.entries())
+			newTable.setOwner(s.edit)
+
+			var parentTable = path.peek()
+			var parentIdx = hv.Index(depth - 1)
+			parentTable.replace(parentIdx, newTable)
+
+			curTable = newTable
+		}
+		curTable.insert(idx, newSetFlatLeaf(key))
+		added = true
+	} else {
+		if leaf.Hash() == hv {
+			var newLeaf setLeafI
+			newLeaf, added = leaf.add(key)
+			if added {
+				curTable.replace(idx, newLeaf)
+			}
+		} else {
+			var t = s.createTable(depth+1, leaf, newSetFlatLeaf(key))
+			s.edit.own(t)
+			curTable.replace(idx, t)
+			added = true
+		}
+	}
+
+	if added {
+		s.nentries++
+	}
+
+	return s, added
+}
+
+// Remove deletes the key from the SetTransient data structure. It returns a
+// bool indicating if the key was found and removed. Either way it returns the
+// original SetTransient data structure.
+func (s *SetTransient) Remove(key KeyI) (Set, bool) {
+	if s.IsEmpty() {
+		return s, false
+	}
+
+	var hv = key.Hash()
+	var path, leaf, idx = s.find(hv)
+
+	if leaf == nil {
+		return s, false
+	}
+
+	var newLeaf, removed = leaf.remove(key)
+
+	if !removed {
+		return s, false
+	}
+
+	s.editable(hv, path)
+
+	var curTable = path.pop()
+	var depth = uint(path.len())
+
+	s.nentries--
+
+	if newLeaf != nil { //leaf was a setCollisionLeaf
+		curTable.replace(idx, newLeaf)
+	} else { //leaf was a setFlatLeaf
+		curTable.remove(idx)
+
+		// Side-Effects of removing a key from the table
+		if curTable != &s.root {
+			switch {
+			// if no entries left in table need to colapse down to parent
+			case curTable.nentries() == 1:
+				var lastNode = curTable.entries()[0].node
+				if _, isLeaf := lastNode.(setLeafI); isLeaf {
+					var parentTable = path.peek()
+					var parentIdx = hv.Index(depth - 1)
+					parentTable.replace(parentIdx, lastNode)
+				}
+
+				// else check if downgrade allowed and required
+			case !s.nograde && curTable.nentries() == DowngradeThreshold:
+				var newTable = downgradeToSparseTable(
+					curTable.Hash(), depth, curTable.entries())
+				newTable.setOwner(s.edit)
+				var parentTable = path.peek()
+				var parentIdx = hv.Index(depth - 1)
+				parentTable.replace(parentIdx, newTable)
+			}
+		}
+	}
+
+	return s, true
+}
+
+// ToHamt constructs a HamtTransient with every key of the SetTransient. The
+// value of each key is the result of calling val on it; if val is nil every
+// value is nil.
+func (s *SetTransient) ToHamt(val func(KeyI) interface{}) Hamt {
+	return s.setBase.toHamt(val)
+}
+
+// String returns a simple string representation of the SetTransient data
+// structure.
+func (s *SetTransient) String() string {
+	return "SetTransient{" + s.setBase.String() + "}"
+}
+
+// LongString returns a complete recusive listing of the entire SetTransient
+// data structure.
+func (s *SetTransient) LongString(indent string) string {
+	return "SetTransient{\n" + indent + s.setBase.LongString(indent) + "\n}"
+}
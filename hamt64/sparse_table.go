@@ -3,18 +3,21 @@ package hamt64
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 )
 
 // sparseTableInitCap constant sets the default capacity of a new
 // sparseTable.
 const sparseTableInitCap int = 2
 
-// New sparseTable layout size == 44
+// New sparseTable layout size == 60
 type sparseTable struct {
-	nodes    []nodeI // 24
-	depth    uint    // 8; amd64 cpu
-	hashPath HashVal // 8
-	nodeMap  bitmap  // 4
+	nodes    []nodeI                    // 24
+	depth    uint                       // 8; amd64 cpu
+	hashPath HashVal                    // 8
+	edit     *editToken                 // 8
+	annot    atomic.Pointer[tableAnnot] // 8; see node.go
+	nodeMap  bitmap                     // 4
 }
 
 func (t *sparseTable) copy() tableI {
@@ -103,6 +106,7 @@ func downgradeToSparseTable(
 ) *sparseTable {
 	var nt = new(sparseTable)
 	nt.hashPath = hashPath
+	nt.depth = depth
 	//nt.nodeMap = 0
 	nt.nodes = make([]nodeI, len(ents), len(ents)+1)
 
@@ -266,3 +270,33 @@ func (t *sparseTable) iter() tableIterFunc {
 		return nil
 	}
 }
+
+func (t *sparseTable) owner() *editToken {
+	return t.edit
+}
+
+func (t *sparseTable) setOwner(edit *editToken) {
+	t.edit = edit
+}
+
+func (t *sparseTable) cachedDigest() *tableDigest {
+	if a := t.annot.Load(); a != nil {
+		return a.dig.Load()
+	}
+	return nil
+}
+
+func (t *sparseTable) cacheDigest(td *tableDigest) {
+	annotOf(&t.annot).dig.Store(td)
+}
+
+func (t *sparseTable) cachedStored() *storedTable {
+	if a := t.annot.Load(); a != nil {
+		return a.sto.Load()
+	}
+	return nil
+}
+
+func (t *sparseTable) cacheStored(st *storedTable) {
+	annotOf(&t.annot).sto.Store(st)
+}
@@ -0,0 +1,494 @@
+package hamt64
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A Store saves every table and leaf of a HamtFunctional as a node of its own
+// in a NodeStore, under the SHA-256 Digest of the node's encoding:
+//
+//	root   'R' tblOpt:byte nentries:uvarint table:Digest
+//	table  'T' bitmap:[bitmapSize]uint32 children:[popcount(bitmap)]Digest
+//	leaf   'L' n:uvarint n*(kcodec:chunk key:chunk vcodec:chunk val:chunk)
+//
+// where a chunk is a uvarint length followed by that many bytes, as in the
+// Encode format, and the codecs are named by the Registry of the Store. The
+// children of a table are in index order.
+//
+// A table encodes the Digests of its children, so the Digest of the root
+// covers the whole Hamt, and two versions of a HamtFunctional have the same
+// node for every table they share.
+
+const (
+	storeRootTag  = 'R'
+	storeTableTag = 'T'
+	storeLeafTag  = 'L'
+)
+
+// storedTable records, in a table, that the Store s has saved the table under
+// the Digest sum, during the GC generation gen.
+type storedTable struct {
+	s   *Store
+	gen uint64
+	sum Digest
+}
+
+// Store saves HamtFunctionals to a NodeStore, and loads them back, one node
+// per table and leaf. Saving a HamtFunctional derived from one the Store
+// already saved or loaded only writes the tables Put and Del copied, and the
+// leafs they changed; every other table is skipped without being visited.
+//
+// Every saved version stays loadable by its root Digest until GC removes it.
+// A Store is safe to use from several goroutines.
+type Store struct {
+	mu  sync.Mutex
+	ns  NodeStore
+	reg *Registry
+	gen uint64 // incremented by GC, which invalidates the storedTables
+}
+
+// NewStore constructs a Store on the NodeStore ns. Keys and values are
+// encoded by the codecs of reg; if reg is nil DefaultRegistry is used.
+func NewStore(ns NodeStore, reg *Registry) *Store {
+	if reg == nil {
+		reg = DefaultRegistry
+	}
+	return &Store{ns: ns, reg: reg}
+}
+
+// Save writes every node of h that is not in the NodeStore yet, and returns
+// the Digest of the root node, which Load takes.
+func (s *Store) Save(h *HamtFunctional) (Digest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The root table is embedded in the HamtFunctional, which Put and Del
+	// copy by value, so it is never marked as stored.
+	var tsum, err = s.saveTable(&h.root, false)
+	if err != nil {
+		return Digest{}, errors.Wrap(err, "Save")
+	}
+
+	var data = []byte{storeRootTag, byte(h.tableOption())}
+	data = binary.AppendUvarint(data, uint64(h.nentries))
+	data = append(data, tsum[:]...)
+
+	sum, err := s.put(data)
+	if err != nil {
+		return Digest{}, errors.Wrap(err, "Save")
+	}
+	return sum, nil
+}
+
+// put stores data, if the NodeStore does not have it yet, and returns its
+// Digest.
+func (s *Store) put(data []byte) (Digest, error) {
+	var sum = Digest(sha256.Sum256(data))
+
+	var has, err = s.ns.Has(sum)
+	if err == nil && !has {
+		err = s.ns.Put(sum, data)
+	}
+	return sum, err
+}
+
+// saveTable saves t and the nodes under it. If cache is true and t is not
+// owned by a live HamtTransient, t is marked as stored so the next Save can
+// skip it.
+func (s *Store) saveTable(t tableI, cache bool) (Digest, error) {
+	if st := t.cachedStored(); st != nil && st.s == s && st.gen == s.gen {
+		return st.sum, nil
+	}
+
+	var bm bitmap
+	var sums = make([]Digest, 0, IndexLimit)
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var n = t.get(idx)
+		if n == nil {
+			continue
+		}
+
+		var sum Digest
+		var err error
+		switch x := n.(type) {
+		case tableI:
+			sum, err = s.saveTable(x, true)
+		case leafI:
+			sum, err = s.saveLeaf(x)
+		}
+		if err != nil {
+			return Digest{}, err
+		}
+
+		bm.Set(idx)
+		sums = append(sums, sum)
+	}
+
+	var data = make([]byte, 0, 1+len(bm)*4+len(sums)*len(Digest{}))
+	data = append(data, storeTableTag)
+	for _, word := range bm {
+		data = binary.LittleEndian.AppendUint32(data, word)
+	}
+	for _, sum := range sums {
+		data = append(data, sum[:]...)
+	}
+
+	var sum, err = s.put(data)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	if cache && (t.owner() == nil || t.owner().frozen) {
+		t.cacheStored(&storedTable{s, s.gen, sum})
+	}
+
+	return sum, nil
+}
+
+func (s *Store) saveLeaf(l leafI) (Digest, error) {
+	var kvs = l.keyVals()
+
+	var data = []byte{storeLeafTag}
+	data = binary.AppendUvarint(data, uint64(len(kvs)))
+	for _, kv := range kvs {
+		for _, v := range []interface{}{kv.Key, kv.Val} {
+			var c, err = s.reg.lookupType(v)
+			if err != nil {
+				return Digest{}, errors.Wrapf(err, "key %v", kv.Key)
+			}
+			bs, err := c.enc(v)
+			if err != nil {
+				return Digest{}, errors.Wrapf(err, "key %v", kv.Key)
+			}
+			data = appendChunk(data, []byte(c.name))
+			data = appendChunk(data, bs)
+		}
+	}
+
+	return s.put(data)
+}
+
+// get returns the node stored under sum, after checking that it is.
+func (s *Store) get(sum Digest) ([]byte, error) {
+	var data, err = s.ns.Get(sum)
+	if err != nil {
+		return nil, err
+	}
+	if Digest(sha256.Sum256(data)) != sum {
+		return nil, errors.Errorf("node %s is corrupt", sum)
+	}
+	if len(data) == 0 {
+		return nil, errors.Errorf("node %s is empty", sum)
+	}
+	return data, nil
+}
+
+// parseRoot returns the fields of a root node.
+func parseRoot(data []byte) (
+	tblOpt int,
+	nentries uint64,
+	tsum Digest,
+	err error,
+) {
+	if data[0] != storeRootTag || len(data) < 2 {
+		return 0, 0, tsum, errors.New("not a root node")
+	}
+	tblOpt = int(data[1])
+	if tblOpt != HybridTables && tblOpt != SparseTables &&
+		tblOpt != FixedTables {
+		return 0, 0, tsum, errors.Errorf("bad table option %d", tblOpt)
+	}
+	var n int
+	nentries, n = binary.Uvarint(data[2:])
+	if n <= 0 || len(data[2+n:]) != len(tsum) {
+		return 0, 0, tsum, errors.New("bad root node")
+	}
+	copy(tsum[:], data[2+n:])
+	return tblOpt, nentries, tsum, nil
+}
+
+// parseTable returns the indexes and the Digests of the children of a table
+// node.
+func parseTable(data []byte) ([]uint, []Digest, error) {
+	var bm bitmap
+	var p = 1
+	if len(data) < p+len(bm)*4 {
+		return nil, nil, errors.New("bad table node")
+	}
+	for i := range bm {
+		bm[i] = binary.LittleEndian.Uint32(data[p:])
+		p += 4
+	}
+
+	var idxs []uint
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		if bm.IsSet(idx) {
+			idxs = append(idxs, idx)
+		}
+	}
+	if len(data[p:]) != len(idxs)*len(Digest{}) {
+		return nil, nil, errors.New("bad table node")
+	}
+
+	var sums = make([]Digest, len(idxs))
+	for i := range sums {
+		p += copy(sums[i][:], data[p:])
+	}
+	return idxs, sums, nil
+}
+
+// Load reconstructs the HamtFunctional saved under the root Digest root. The
+// tables of the result are marked as stored, so saving a HamtFunctional
+// derived from it only writes what changed.
+func (s *Store) Load(root Digest) (*HamtFunctional, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var data, err = s.get(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "Load")
+	}
+	tblOpt, nentries, tsum, err := parseRoot(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Load: %s", root)
+	}
+
+	var h = NewFunctional(tblOpt)
+	var l = loader{s: s, nograde: h.nograde, startFixed: h.startFixed}
+
+	ents, err := l.loadEntries(tsum, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "Load")
+	}
+	for _, ent := range ents {
+		h.root.insert(ent.idx, ent.node)
+	}
+	h.nentries = l.nentries
+
+	if uint64(h.nentries) != nentries {
+		return nil, errors.Errorf("Load: %s: loaded %d entries; expected %d",
+			root, h.nentries, nentries)
+	}
+
+	return h, nil
+}
+
+// loader holds the state of loading the nodes of one HamtFunctional.
+type loader struct {
+	s          *Store
+	nograde    bool
+	startFixed bool
+	nentries   uint
+}
+
+// loadEntries loads the children of the table node sum at the given depth.
+func (l *loader) loadEntries(sum Digest, depth uint) ([]tableEntry, error) {
+	var data, err = l.s.get(sum)
+	if err != nil {
+		return nil, err
+	}
+	if data[0] != storeTableTag {
+		return nil, errors.Errorf("node %s is not a table", sum)
+	}
+	idxs, sums, err := parseTable(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "node %s", sum)
+	}
+
+	var ents = make([]tableEntry, len(idxs))
+	for i, idx := range idxs {
+		var n, err = l.loadNode(sums[i], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		ents[i] = tableEntry{idx, n}
+	}
+
+	return ents, nil
+}
+
+// loadNode loads the node sum; if it is a table, it is a table at the given
+// depth.
+func (l *loader) loadNode(sum Digest, depth uint) (nodeI, error) {
+	var data, err = l.s.get(sum)
+	if err != nil {
+		return nil, err
+	}
+
+	switch data[0] {
+	case storeTableTag:
+		if depth > maxDepth {
+			return nil, errors.Errorf("node %s: table too deep", sum)
+		}
+		var ents, err = l.loadEntries(sum, depth)
+		if err != nil {
+			return nil, err
+		}
+		if len(ents) == 0 {
+			return nil, errors.Errorf("node %s: empty table", sum)
+		}
+		var t = newTableFrom(nil, depth, ents, l.nograde, l.startFixed)
+		t.cacheStored(&storedTable{l.s, l.s.gen, sum})
+		return t, nil
+	case storeLeafTag:
+		var leaf, err = l.loadLeaf(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "node %s", sum)
+		}
+		return leaf, nil
+	}
+
+	return nil, errors.Errorf("node %s: unknown tag %q", sum, data[0])
+}
+
+func (l *loader) loadLeaf(data []byte) (leafI, error) {
+	var r = bytes.NewReader(data[1:])
+
+	var n, err = binary.ReadUvarint(r)
+	if err != nil || n == 0 {
+		return nil, errors.New("bad leaf node")
+	}
+
+	var leaf leafI
+	for i := uint64(0); i < n; i++ {
+		var kv [2]interface{}
+		for j := range kv {
+			var name, err = readNodeChunk(r)
+			if err != nil {
+				return nil, err
+			}
+			c, err := l.s.reg.lookupName(string(name))
+			if err != nil {
+				return nil, err
+			}
+			bs, err := readNodeChunk(r)
+			if err != nil {
+				return nil, err
+			}
+			kv[j], err = c.dec(bs)
+			if err != nil {
+				return nil, errors.Wrapf(err, "codec %q", c.name)
+			}
+		}
+
+		var key, isKey = kv[0].(KeyI)
+		if !isKey {
+			return nil, errors.Errorf("decoded key of type %T is not a KeyI",
+				kv[0])
+		}
+
+		if leaf == nil {
+			leaf = newFlatLeaf(key, kv[1])
+		} else {
+			if key.Hash() != leaf.Hash() {
+				return nil, errors.New("leaf keys with different HashVals")
+			}
+			var added bool
+			leaf, added = leaf.put(key, kv[1])
+			if !added {
+				return nil, errors.Errorf("duplicate key %v in leaf", key)
+			}
+		}
+		l.nentries++
+	}
+
+	if r.Len() != 0 {
+		return nil, errors.New("bad leaf node")
+	}
+	return leaf, nil
+}
+
+// readNodeChunk reads a chunk of a node.
+func readNodeChunk(r *bytes.Reader) ([]byte, error) {
+	var n, err = binary.ReadUvarint(r)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var bs = make([]byte, n)
+	r.Read(bs)
+	return bs, nil
+}
+
+// GC removes every node of the NodeStore that is not reachable from one of
+// the roots, and returns how many it removed. Every root must be in the
+// NodeStore; if one is missing, or a node under one of them is, GC removes
+// nothing and returns an error.
+//
+// GC is a mark-and-sweep over the whole NodeStore, and holds up every Save
+// and Load of the Store until it is done. The first Save after a GC visits
+// every table again, to check that its node was not removed.
+func (s *Store) GC(roots ...Digest) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var marked = make(map[Digest]bool)
+	for _, root := range roots {
+		if err := s.mark(root, marked); err != nil {
+			return 0, errors.Wrap(err, "GC")
+		}
+	}
+
+	var sums, err = s.ns.Digests()
+	if err != nil {
+		return 0, errors.Wrap(err, "GC")
+	}
+
+	// Tables marked as stored may be about to be removed.
+	s.gen++
+
+	var removed int
+	for _, sum := range sums {
+		if marked[sum] {
+			continue
+		}
+		if err = s.ns.Delete(sum); err != nil {
+			return removed, errors.Wrap(err, "GC")
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// mark adds sum, and every node under it, to marked.
+func (s *Store) mark(sum Digest, marked map[Digest]bool) error {
+	if marked[sum] {
+		return nil
+	}
+
+	var data, err = s.get(sum)
+	if err != nil {
+		return err
+	}
+	marked[sum] = true
+
+	switch data[0] {
+	case storeRootTag:
+		var _, _, tsum, err = parseRoot(data)
+		if err != nil {
+			return errors.Wrapf(err, "node %s", sum)
+		}
+		return s.mark(tsum, marked)
+	case storeTableTag:
+		var _, sums, err = parseTable(data)
+		if err != nil {
+			return errors.Wrapf(err, "node %s", sum)
+		}
+		for _, csum := range sums {
+			if err = s.mark(csum, marked); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
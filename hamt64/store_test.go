@@ -0,0 +1,210 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numStoreKvs = 20 * 1024
+
+// countingStore is a NodeStore that counts the nodes written to it.
+type countingStore struct {
+	hamt64.NodeStore
+	puts int
+}
+
+func (cs *countingStore) Put(d hamt64.Digest, data []byte) error {
+	cs.puts++
+	return cs.NodeStore.Put(d, data)
+}
+
+func buildStoreHamt64(
+	t *testing.T,
+	name string,
+	kvs []hamt64.KeyVal,
+) *hamt64.HamtFunctional {
+	var h, err = buildHamt64(name, kvs, true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	return h.(*hamt64.HamtFunctional)
+}
+
+func TestHamt64Store(t *testing.T) {
+	var name = "TestHamt64Store:" + hamt64.TableOptionName[TableOption]
+
+	var ds, err = hamt64.NewDirStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("%s: failed NewDirStore() => %s", name, err)
+	}
+
+	// DirStore fsyncs every node, so it gets fewer
+	for _, x := range []struct {
+		ns   hamt64.NodeStore
+		nkvs int
+	}{
+		{hamt64.NewMemStore(), numStoreKvs},
+		{ds, 1024},
+	} {
+		var kvs = KVS64[:x.nkvs]
+		var h = buildStoreHamt64(t, name, kvs)
+		var ns = x.ns
+		var s = hamt64.NewStore(ns, nil)
+
+		var root, err = s.Save(h)
+		if err != nil {
+			t.Fatalf("%s: failed Save() => %s", name, err)
+		}
+
+		// a second Store on the same NodeStore has nothing cached
+		lh, err := hamt64.NewStore(ns, nil).Load(root)
+		if err != nil {
+			t.Fatalf("%s: failed Load() => %s", name, err)
+		}
+		if !hamt64.Equal(h, lh, nil) {
+			t.Fatalf("%s: !Equal(h, Load(Save(h)))", name)
+		}
+
+		// the loaded Hamt works like any other
+		nh, _ := lh.Put(kvs[0].Key, -1)
+		if val, _ := nh.Get(kvs[0].Key); val != -1 {
+			t.Fatalf("%s: Get() after Put() on a loaded Hamt => %v",
+				name, val)
+		}
+	}
+}
+
+func TestHamt64StoreIncremental(t *testing.T) {
+	var name = "TestHamt64StoreIncremental:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numStoreKvs]
+	var h = buildStoreHamt64(t, name, kvs)
+
+	var cs = &countingStore{NodeStore: hamt64.NewMemStore()}
+	var s = hamt64.NewStore(cs, nil)
+
+	var root1, err = s.Save(h)
+	if err != nil {
+		t.Fatalf("%s: failed Save() => %s", name, err)
+	}
+	var full = cs.puts
+
+	var nh, _ = h.Put(kvs[0].Key, -1)
+	cs.puts = 0
+	root2, err := s.Save(nh.(*hamt64.HamtFunctional))
+	if err != nil {
+		t.Fatalf("%s: failed Save() => %s", name, err)
+	}
+
+	// the root node, the root table, the copied tables, and the new leaf
+	var stats = h.Stats()
+	if cs.puts > int(stats.MaxDepth)+3 {
+		t.Fatalf("%s: Save() after one Put() wrote %d nodes of %d",
+			name, cs.puts, full)
+	}
+
+	// saving it again writes nothing
+	cs.puts = 0
+	if _, err = s.Save(nh.(*hamt64.HamtFunctional)); err != nil {
+		t.Fatalf("%s: failed Save() => %s", name, err)
+	}
+	if cs.puts != 0 {
+		t.Fatalf("%s: second Save() wrote %d nodes", name, cs.puts)
+	}
+
+	// the old version is still there
+	h1, err := s.Load(root1)
+	if err != nil {
+		t.Fatalf("%s: failed Load(root1) => %s", name, err)
+	}
+	if !hamt64.Equal(h, h1, nil) {
+		t.Fatalf("%s: !Equal(h, Load(root1))", name)
+	}
+	h2, err := s.Load(root2)
+	if err != nil {
+		t.Fatalf("%s: failed Load(root2) => %s", name, err)
+	}
+	if !hamt64.Equal(nh, h2, nil) {
+		t.Fatalf("%s: !Equal(nh, Load(root2))", name)
+	}
+
+	// a loaded Hamt saves incrementally too
+	var lh, _ = h2.Put(kvs[1].Key, -1)
+	cs.puts = 0
+	if _, err = s.Save(lh.(*hamt64.HamtFunctional)); err != nil {
+		t.Fatalf("%s: failed Save() => %s", name, err)
+	}
+	if cs.puts > int(stats.MaxDepth)+3 {
+		t.Fatalf("%s: Save() after Load() and Put() wrote %d nodes of %d",
+			name, cs.puts, full)
+	}
+}
+
+func TestHamt64StoreGC(t *testing.T) {
+	var name = "TestHamt64StoreGC:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numStoreKvs]
+	var half = len(kvs) / 2
+	var h1 = buildStoreHamt64(t, name, kvs[:half])
+
+	var ms = hamt64.NewMemStore()
+	var s = hamt64.NewStore(ms, nil)
+
+	var root1, err = s.Save(h1)
+	if err != nil {
+		t.Fatalf("%s: failed Save() => %s", name, err)
+	}
+
+	var h2 hamt64.Hamt = h1
+	for _, kv := range kvs[half:] {
+		h2, _ = h2.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range kvs[:half/2] {
+		h2, _, _ = h2.Del(kv.Key)
+	}
+	root2, err := s.Save(h2.(*hamt64.HamtFunctional))
+	if err != nil {
+		t.Fatalf("%s: failed Save() => %s", name, err)
+	}
+
+	if _, err = s.GC(root2, hamt64.Digest{}); err == nil {
+		t.Fatalf("%s: GC() with a missing root succeeded", name)
+	}
+	if _, err = s.Load(root1); err != nil {
+		t.Fatalf("%s: failed GC() removed nodes => %s", name, err)
+	}
+
+	removed, err := s.GC(root2)
+	if err != nil {
+		t.Fatalf("%s: failed GC() => %s", name, err)
+	}
+	if removed == 0 {
+		t.Fatalf("%s: GC() removed nothing", name)
+	}
+	if _, err = s.Load(root1); err == nil {
+		t.Fatalf("%s: Load() of a collected root succeeded", name)
+	}
+	if _, err = s.GC(root2); err != nil {
+		t.Fatalf("%s: failed second GC() => %s", name, err)
+	}
+
+	// h1 shares tables with h2, some of which were collected
+	root1, err = s.Save(h1)
+	if err != nil {
+		t.Fatalf("%s: failed Save() after GC() => %s", name, err)
+	}
+	l1, err := s.Load(root1)
+	if err != nil {
+		t.Fatalf("%s: failed Load() after GC() => %s", name, err)
+	}
+	if !hamt64.Equal(h1, l1, nil) {
+		t.Fatalf("%s: !Equal(h1, Load(root1)) after GC()", name)
+	}
+	l2, err := s.Load(root2)
+	if err != nil {
+		t.Fatalf("%s: failed Load(root2) after GC() => %s", name, err)
+	}
+	if !hamt64.Equal(h2, l2, nil) {
+		t.Fatalf("%s: !Equal(h2, Load(root2)) after GC()", name)
+	}
+}
@@ -0,0 +1,631 @@
+package hamt64
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// The Sync protocol runs between an initiator and a responder, which take
+// turns writing a message and reading the answer. The first message of each
+// side starts with a header:
+//
+//	magic     "HSYN"
+//	version   byte; syncVersion
+//
+// The initiator compares the two tries top-down, in rounds. Both sides keep
+// the list of positions, the slots of the tries found to differ, in the same
+// order; the first round has just the root tables. For every position the
+// initiator writes one record, then an end record:
+//
+//	'T' n:uvarint {idx:byte digest:32 bytes}*n  its node is a table; the
+//	                                            digests of its n children
+//	'L'                                         its node is a leaf or nil
+//	'Z' npositions:uvarint
+//
+// and the responder answers every position with one record, then an end
+// record:
+//
+//	'T' n:uvarint idx:byte*n     both nodes are tables; the indexes of the
+//	                             n children whose digests differ
+//	'L' n:uvarint 'E'-record*n   the pairs under its node
+//	'Z' npositions:uvarint
+//
+// The children listed in 'T' answers are the positions of the next round.
+// Once there are none left, the initiator writes the changes the responder
+// has to make and the Fingerprint of its own result:
+//
+//	'P' and 'D' records, as written by WriteDelta
+//	'Z' nrecords:uvarint fingerprint:32 bytes
+//
+// and the responder answers with the Fingerprint of its result:
+//
+//	'Z' fingerprint:32 bytes
+//
+// The 'C' and 'E' records and the chunks are those of Encode; the codec ids
+// of each direction are numbered for the whole session.
+
+const syncMagic = "HSYN"
+
+const syncVersion = 1
+
+const (
+	syncTableTag = 'T'
+	syncLeafTag  = 'L'
+)
+
+// SyncStats counts what one side of a Sync transferred. Rounds is the number
+// of rounds of table comparisons, at most one per level of the tries. The
+// tables are those whose child digests were sent by the initiator, and the
+// entries every pair, or deleted key, sent by either side.
+type SyncStats struct {
+	Rounds          int
+	TablesSent      int
+	TablesReceived  int
+	EntriesSent     int
+	EntriesReceived int
+}
+
+// syncer holds the state of one side of a Sync.
+type syncer struct {
+	e       encoder
+	d       decoder
+	resolve Resolver
+	stats   SyncStats
+	local   []deltaOp // the changes to make to our Hamt
+	remote  []deltaOp // the changes the responder has to make
+}
+
+// Sync makes the contents of h the same as those of the Hamt of the other
+// side of rw, which calls Sync too; one of them with initiator true and the
+// other with initiator false. Keys and values are exchanged with the codecs
+// of DefaultRegistry.
+//
+// The layout of a trie only depends on the HashVals of its keys, so the two
+// sides compare the digests of their tables top-down and only descend into
+// the tables that differ. The pairs under a differing slot are sent once one
+// side has no more than a leaf there. So the cost of a Sync is proportional to
+// the size of the difference times the depth of the tries, not to their size.
+// The digests are those of a Fingerprint, calculated by a Digester shared by
+// every Sync, WriteDelta and ApplyDelta call; table digests cached by
+// HamtFunctionals make repeated Syncs of a replica cheap.
+//
+// The result is the union of the two Hamts. For a key with different values
+// on the two sides, the initiator calls resolve with its own value as val and
+// the value of the responder as otherVal, and sends the outcome to the
+// responder, so both sides end up the same whatever resolve does. If resolve
+// is nil the value of the initiator wins. A key deleted on one side only is
+// put back by Sync, as the other side can not tell it from a new key; to
+// propagate deletes, store a tombstone value and let resolve pick it.
+//
+// Sync returns the new contents, like Put does: a HamtFunctional h is not
+// modified, a HamtTransient h is modified in place. The two sides confirm
+// they have the same Fingerprint at the end. After an error the state of rw
+// is unknown, and the other side may wait for a message that never comes, so
+// rw should be closed.
+func Sync(
+	h Hamt,
+	rw io.ReadWriter,
+	initiator bool,
+	resolve Resolver,
+) (Hamt, SyncStats, error) {
+	if resolve == nil {
+		resolve = func(_ KeyI, val, _ interface{}) (interface{}, bool) {
+			return val, true
+		}
+	}
+
+	var s = syncer{
+		e: encoder{
+			op:  "failed to encode",
+			w:   bufio.NewWriter(rw),
+			reg: DefaultRegistry,
+			ids: make(map[*codec]uint64),
+		},
+		d:       decoder{reg: DefaultRegistry},
+		resolve: resolve,
+	}
+	if br, ok := rw.(byteReader); ok {
+		s.d.r = br
+	} else {
+		s.d.r = bufio.NewReader(rw)
+	}
+
+	// Neither side flushes its header on its own, so the two never write at
+	// the same time.
+	s.e.buf = append(s.e.buf[:0], syncMagic...)
+	s.e.buf = append(s.e.buf, syncVersion)
+	s.e.write(s.e.buf)
+
+	var nh Hamt
+	var err error
+	if initiator {
+		nh, err = s.initiate(h)
+	} else {
+		nh, err = s.respond(h)
+	}
+	if err != nil {
+		return nil, s.stats, errors.Wrap(err, "Sync")
+	}
+	return nh, s.stats, nil
+}
+
+// initiate runs the initiator side of a Sync of h.
+func (s *syncer) initiate(h Hamt) (Hamt, error) {
+	var nodes = []nodeI{&hamtBaseOf(h).root}
+	var header = true
+
+	for len(nodes) > 0 {
+		s.stats.Rounds++
+		for _, n := range nodes {
+			if t, isTable := n.(tableI); isTable {
+				s.writeDigests(t)
+				s.stats.TablesSent++
+			} else {
+				s.e.write([]byte{syncLeafTag})
+			}
+		}
+		if err := s.writeEnd(len(nodes)); err != nil {
+			return nil, err
+		}
+
+		if header {
+			if err := s.readHeader(); err != nil {
+				return nil, err
+			}
+			header = false
+		}
+
+		var next []nodeI
+		for _, n := range nodes {
+			var tag, err = s.readTag()
+			if err != nil {
+				return nil, err
+			}
+
+			switch tag {
+			case syncTableTag:
+				var t, isTable = n.(tableI)
+				if !isTable {
+					return nil, errors.New(
+						"table answer for a position without a table")
+				}
+				idxs, err := s.readIndexes()
+				if err != nil {
+					return nil, err
+				}
+				for _, idx := range idxs {
+					next = append(next, t.get(idx))
+				}
+			case syncLeafTag:
+				theirs, err := s.readPairs()
+				if err != nil {
+					return nil, err
+				}
+				s.reconcile(n, theirs)
+			default:
+				return nil, errors.Errorf("unexpected record tag %q", tag)
+			}
+		}
+		if err := s.readEnd(len(nodes)); err != nil {
+			return nil, err
+		}
+
+		nodes = next
+	}
+
+	var nh = applyDeltaOps(h, s.local)
+	var fp = fingerprintOf(nh, deltaDigester)
+
+	for _, op := range s.remote {
+		if op.del {
+			s.e.writeDelete(op.key)
+		} else {
+			s.e.writeEntry(deltaPutTag, op.key, op.val)
+		}
+	}
+	s.stats.EntriesSent += len(s.remote)
+	s.e.buf = append(s.e.buf[:0], endTag)
+	s.e.buf = binary.AppendUvarint(s.e.buf, uint64(len(s.remote)))
+	s.e.buf = append(s.e.buf, fp[:]...)
+	s.e.write(s.e.buf)
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+
+	if tag, err := s.readTag(); err != nil {
+		return nil, err
+	} else if tag != endTag {
+		return nil, errors.Errorf("unexpected record tag %q", tag)
+	}
+	var rfp, err = s.readDigest()
+	if err != nil {
+		return nil, err
+	}
+	if rfp != fp {
+		return nil, errors.Errorf(
+			"responder has Fingerprint %s; initiator has %s", rfp, fp)
+	}
+
+	return nh, nil
+}
+
+// respond runs the responder side of a Sync of h.
+func (s *syncer) respond(h Hamt) (Hamt, error) {
+	if err := s.readHeader(); err != nil {
+		return nil, err
+	}
+
+	var nodes = []nodeI{&hamtBaseOf(h).root}
+
+	for len(nodes) > 0 {
+		s.stats.Rounds++
+
+		// Read the whole message before answering, so the two sides never
+		// write at the same time.
+		var digests = make([][]*Digest, len(nodes))
+		for i := range nodes {
+			var tag, err = s.readTag()
+			if err != nil {
+				return nil, err
+			}
+
+			switch tag {
+			case syncTableTag:
+				digests[i], err = s.readDigests()
+				if err != nil {
+					return nil, err
+				}
+				s.stats.TablesReceived++
+			case syncLeafTag:
+			default:
+				return nil, errors.Errorf("unexpected record tag %q", tag)
+			}
+		}
+		if err := s.readEnd(len(nodes)); err != nil {
+			return nil, err
+		}
+
+		var next []nodeI
+		for i, n := range nodes {
+			var t, isTable = n.(tableI)
+			if digests[i] == nil || !isTable {
+				s.writePairs(n)
+				continue
+			}
+
+			var idxs []uint
+			for idx := uint(0); idx < IndexLimit; idx++ {
+				var c = t.get(idx)
+				switch {
+				case c == nil && digests[i][idx] == nil:
+					continue
+				case c != nil && digests[i][idx] != nil &&
+					deltaDigester.digestNode(c).sum == *digests[i][idx]:
+					continue
+				}
+				idxs = append(idxs, idx)
+				next = append(next, c)
+			}
+
+			s.e.buf = append(s.e.buf[:0], syncTableTag)
+			s.e.buf = binary.AppendUvarint(s.e.buf, uint64(len(idxs)))
+			for _, idx := range idxs {
+				s.e.buf = append(s.e.buf, byte(idx))
+			}
+			s.e.write(s.e.buf)
+		}
+		if err := s.writeEnd(len(nodes)); err != nil {
+			return nil, err
+		}
+
+		nodes = next
+	}
+
+	var ops []deltaOp
+	var ifp Digest
+	for done := false; !done; {
+		var tag, err = s.readTag()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case deltaPutTag, deltaDelTag:
+			var op deltaOp
+			op, err = s.d.readDeltaOp(tag == deltaDelTag)
+			ops = append(ops, op)
+		case endTag:
+			var n uint64
+			n, err = binary.ReadUvarint(s.d.r)
+			if err != nil {
+				return nil, noEOF(err)
+			}
+			if n != uint64(len(ops)) {
+				return nil, errors.Errorf("read %d records; trailer says %d",
+					len(ops), n)
+			}
+			ifp, err = s.readDigest()
+			done = true
+		default:
+			err = errors.Errorf("unexpected record tag %q", tag)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.stats.EntriesReceived += len(ops)
+
+	var nh = applyDeltaOps(h, ops)
+	var fp = fingerprintOf(nh, deltaDigester)
+
+	s.e.buf = append(s.e.buf[:0], endTag)
+	s.e.buf = append(s.e.buf, fp[:]...)
+	s.e.write(s.e.buf)
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+
+	if fp != ifp {
+		return nil, errors.Errorf(
+			"responder has Fingerprint %s; initiator has %s", fp, ifp)
+	}
+	return nh, nil
+}
+
+// reconcile decides the pairs under a position where the initiator has the
+// node n and the responder has the pairs theirs. The changes are added to
+// s.local and s.remote.
+func (s *syncer) reconcile(n nodeI, theirs []KeyVal) {
+	// theirs by key; a map can not hold every KeyI, like a ByteSliceKey
+	var th = NewTransient(HybridTables)
+	for _, kv := range theirs {
+		th.Put(kv.Key, kv.Val)
+	}
+
+	for _, kv := range appendKeyVals(nil, n) {
+		var tval, found = th.Get(kv.Key)
+		if !found {
+			s.remote = append(s.remote, deltaOp{key: kv.Key, val: kv.Val})
+			continue
+		}
+		th.Del(kv.Key)
+		if sameValue(kv.Val, tval) {
+			continue
+		}
+
+		var val, keep = s.resolve(kv.Key, kv.Val, tval)
+		if !keep {
+			s.local = append(s.local, deltaOp{key: kv.Key, del: true})
+			s.remote = append(s.remote, deltaOp{key: kv.Key, del: true})
+			continue
+		}
+		if !sameValue(val, kv.Val) {
+			s.local = append(s.local, deltaOp{key: kv.Key, val: val})
+		}
+		if !sameValue(val, tval) {
+			s.remote = append(s.remote, deltaOp{key: kv.Key, val: val})
+		}
+	}
+
+	th.Range(func(k KeyI, v interface{}) bool {
+		s.local = append(s.local, deltaOp{key: k, val: v})
+		return true
+	})
+}
+
+// sameValue reports whether the values a and b are equal by the ValDigestFunc
+// that the digests compared by Sync use.
+func sameValue(a, b interface{}) bool {
+	return bytes.Equal(deltaDigester.valDigest(a), deltaDigester.valDigest(b))
+}
+
+// appendKeyVals appends the KeyVal pairs of the subtree n, which may be nil,
+// to kvs.
+func appendKeyVals(kvs []KeyVal, n nodeI) []KeyVal {
+	if n == nil {
+		return kvs
+	}
+	n.visit(func(x nodeI) bool {
+		if l, isLeaf := x.(leafI); isLeaf {
+			kvs = append(kvs, l.keyVals()...)
+		}
+		return true
+	})
+	return kvs
+}
+
+// writeDigests writes a 'T' record with the digests of the children of t.
+func (s *syncer) writeDigests(t tableI) {
+	s.e.buf = append(s.e.buf[:0], syncTableTag)
+	s.e.buf = binary.AppendUvarint(s.e.buf, uint64(t.nentries()))
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		if c := t.get(idx); c != nil {
+			var td = deltaDigester.digestNode(c)
+			s.e.buf = append(s.e.buf, byte(idx))
+			s.e.buf = append(s.e.buf, td.sum[:]...)
+		}
+	}
+	s.e.write(s.e.buf)
+}
+
+// writePairs writes an 'L' record with the pairs of the subtree n.
+func (s *syncer) writePairs(n nodeI) {
+	var kvs = appendKeyVals(nil, n)
+
+	s.e.buf = append(s.e.buf[:0], syncLeafTag)
+	s.e.buf = binary.AppendUvarint(s.e.buf, uint64(len(kvs)))
+	s.e.write(s.e.buf)
+
+	for _, kv := range kvs {
+		s.e.writeEntry(entryTag, kv.Key, kv.Val)
+	}
+	s.stats.EntriesSent += len(kvs)
+}
+
+// writeEnd writes the end record of a round and flushes the message.
+func (s *syncer) writeEnd(npositions int) error {
+	s.e.buf = append(s.e.buf[:0], endTag)
+	s.e.buf = binary.AppendUvarint(s.e.buf, uint64(npositions))
+	s.e.write(s.e.buf)
+	return s.flush()
+}
+
+func (s *syncer) flush() error {
+	if s.e.err != nil {
+		return s.e.err
+	}
+	return s.e.w.Flush()
+}
+
+func (s *syncer) readHeader() error {
+	var hdr [len(syncMagic) + 1]byte
+	if _, err := io.ReadFull(s.d.r, hdr[:]); err != nil {
+		return errors.Wrap(err, "failed to read header")
+	}
+	if string(hdr[:len(syncMagic)]) != syncMagic {
+		return errors.Errorf("bad magic %q", hdr[:len(syncMagic)])
+	}
+	if version := hdr[len(syncMagic)]; version != syncVersion {
+		return errors.Errorf("unsupported version %d", version)
+	}
+	return nil
+}
+
+// readTag returns the tag of the next record other than a 'C' record; those
+// are read on the way.
+func (s *syncer) readTag() (byte, error) {
+	for {
+		var tag, err = s.d.r.ReadByte()
+		if err != nil {
+			return 0, errors.Wrap(noEOF(err), "failed to read tag")
+		}
+		if tag != codecTag {
+			return tag, nil
+		}
+		if err = s.d.readCodec(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readEnd reads the end record of a round of npositions.
+func (s *syncer) readEnd(npositions int) error {
+	var tag, err = s.readTag()
+	if err != nil {
+		return err
+	}
+	if tag != endTag {
+		return errors.Errorf("unexpected record tag %q", tag)
+	}
+	n, err := binary.ReadUvarint(s.d.r)
+	if err != nil {
+		return errors.Wrap(noEOF(err), "bad end record")
+	}
+	if n != uint64(npositions) {
+		return errors.Errorf("read %d positions; trailer says %d",
+			npositions, n)
+	}
+	return nil
+}
+
+// readCount reads the uvarint count of a record, which may not exceed max.
+func (s *syncer) readCount(max uint64) (uint64, error) {
+	var n, err = binary.ReadUvarint(s.d.r)
+	if err != nil {
+		return 0, noEOF(err)
+	}
+	if n > max {
+		return 0, errors.Errorf("record count %d exceeds %d", n, max)
+	}
+	return n, nil
+}
+
+// readIndex reads a child index.
+func (s *syncer) readIndex() (uint, error) {
+	var b, err = s.d.r.ReadByte()
+	if err != nil {
+		return 0, noEOF(err)
+	}
+	if uint(b) >= IndexLimit {
+		return 0, errors.Errorf("index %d out of range", b)
+	}
+	return uint(b), nil
+}
+
+func (s *syncer) readDigest() (Digest, error) {
+	var sum Digest
+	if _, err := io.ReadFull(s.d.r, sum[:]); err != nil {
+		return sum, noEOF(err)
+	}
+	return sum, nil
+}
+
+// readDigests reads the rest of a 'T' record of the initiator. The digest of
+// an index without a child is nil.
+func (s *syncer) readDigests() ([]*Digest, error) {
+	var n, err = s.readCount(uint64(IndexLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	var digests = make([]*Digest, IndexLimit)
+	for i := uint64(0); i < n; i++ {
+		var idx, err = s.readIndex()
+		if err != nil {
+			return nil, err
+		}
+		sum, err := s.readDigest()
+		if err != nil {
+			return nil, err
+		}
+		digests[idx] = &sum
+	}
+	return digests, nil
+}
+
+// readIndexes reads the rest of a 'T' record of the responder.
+func (s *syncer) readIndexes() ([]uint, error) {
+	var n, err = s.readCount(uint64(IndexLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	var idxs = make([]uint, n)
+	for i := range idxs {
+		if idxs[i], err = s.readIndex(); err != nil {
+			return nil, err
+		}
+	}
+	return idxs, nil
+}
+
+// readPairs reads the rest of an 'L' record of the responder.
+func (s *syncer) readPairs() ([]KeyVal, error) {
+	var n, err = s.readCount(maxChunkLen)
+	if err != nil {
+		return nil, err
+	}
+
+	var kvs []KeyVal
+	for i := uint64(0); i < n; i++ {
+		var tag, err = s.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if tag != entryTag {
+			return nil, errors.Errorf("unexpected record tag %q", tag)
+		}
+		op, err := s.d.readDeltaOp(false)
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, KeyVal{op.key, op.val})
+	}
+	s.stats.EntriesReceived += len(kvs)
+	return kvs, nil
+}
@@ -0,0 +1,197 @@
+package hamt64_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numSyncKvs = 20 * 1024
+
+// syncPipe runs a Sync between the initiator a and the responder b over a
+// net.Pipe, and returns the results of both sides.
+func syncPipe(
+	a, b hamt64.Hamt,
+	resolve hamt64.Resolver,
+) (ra, rb hamt64.Hamt, sa, sb hamt64.SyncStats, erra, errb error) {
+	var ca, cb = net.Pipe()
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		rb, sb, errb = hamt64.Sync(b, cb, false, resolve)
+		if errb != nil {
+			cb.Close()
+		}
+	}()
+	ra, sa, erra = hamt64.Sync(a, ca, true, resolve)
+	if erra != nil {
+		ca.Close()
+	}
+	<-done
+	ca.Close()
+	cb.Close()
+	return
+}
+
+func TestHamt64Sync(t *testing.T) {
+	var name = "TestHamt64Sync:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numSyncKvs]
+	var n = len(kvs) - 40
+
+	var base, err = buildHamt64(name, kvs[:n], Functional, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	// replica a adds keys and changes values
+	var a = base.ToFunctional()
+	if !Functional {
+		a = base.DeepCopy()
+	}
+	for _, kv := range kvs[n : n+20] {
+		a, _ = a.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range kvs[10:20] {
+		a, _ = a.Put(kv.Key, -1)
+	}
+
+	// replica b, with another layout, adds other keys and changes some of
+	// the same values
+	var b = hamt64.Hamt(hamt64.Build(kvs[:n], (TableOption+1)%3))
+	for _, kv := range kvs[n+20:] {
+		b, _ = b.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range kvs[15:25] {
+		b, _ = b.Put(kv.Key, -2)
+	}
+	for _, kv := range kvs[30:35] {
+		a, _ = a.Put(kv.Key, -3)
+		b, _ = b.Put(kv.Key, -3)
+	}
+
+	// conflicts keep the lower value, except that kvs[15] is dropped
+	var nresolved int
+	var resolve = func(
+		k hamt64.KeyI,
+		val, otherVal interface{},
+	) (interface{}, bool) {
+		nresolved++
+		if k.Equals(kvs[15].Key) {
+			return nil, false
+		}
+		return min(val.(int), otherVal.(int)), true
+	}
+
+	var expected = hamt64.NewTransient(TableOption)
+	for _, kv := range kvs {
+		expected.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range kvs[10:20] {
+		expected.Put(kv.Key, -1)
+	}
+	for _, kv := range kvs[15:25] {
+		expected.Put(kv.Key, -2)
+	}
+	for _, kv := range kvs[30:35] {
+		expected.Put(kv.Key, -3)
+	}
+	expected.Del(kvs[15].Key)
+
+	var ra, rb, sa, sb, erra, errb = syncPipe(a, b, resolve)
+	if erra != nil || errb != nil {
+		t.Fatalf("%s: failed Sync() => %v; %v", name, erra, errb)
+	}
+	if !hamt64.Equal(ra, expected, nil) {
+		t.Fatalf("%s: initiator result is not the union", name)
+	}
+	if !hamt64.Equal(rb, expected, nil) {
+		t.Fatalf("%s: responder result is not the union", name)
+	}
+	if nresolved != 15 {
+		t.Fatalf("%s: resolve called %d times; expected 15", name, nresolved)
+	}
+	if Functional && a.Nentries() != uint(n+20) {
+		t.Fatalf("%s: Sync() modified the HamtFunctional initiator", name)
+	}
+	if b.Nentries() != uint(n+20) {
+		t.Fatalf("%s: Sync() modified the HamtFunctional responder", name)
+	}
+
+	// Only the paths to the 55 differing keys were compared, and only the
+	// pairs under them sent.
+	if sa.Rounds > int(hamt64.DepthLimit)+1 || sa.Rounds != sb.Rounds {
+		t.Fatalf("%s: Sync() took %d and %d rounds", name, sa.Rounds,
+			sb.Rounds)
+	}
+	if sa.TablesSent != sb.TablesReceived || sa.TablesSent > 55*4 {
+		t.Fatalf("%s: Sync() sent %d tables; responder received %d",
+			name, sa.TablesSent, sb.TablesReceived)
+	}
+	if sa.EntriesSent != sb.EntriesReceived ||
+		sb.EntriesSent != sa.EntriesReceived {
+		t.Fatalf("%s: Sync() stats do not match: %+v; %+v", name, sa, sb)
+	}
+	if sa.EntriesSent+sa.EntriesReceived > 2*55 {
+		t.Fatalf("%s: Sync() transferred %d entries for 55 differing keys",
+			name, sa.EntriesSent+sa.EntriesReceived)
+	}
+
+	// the synced replicas are in sync
+	_, _, sa, sb, erra, errb = syncPipe(rb, ra, nil)
+	if erra != nil || errb != nil {
+		t.Fatalf("%s: failed second Sync() => %v; %v", name, erra, errb)
+	}
+	var none = hamt64.SyncStats{Rounds: 1, TablesSent: 1}
+	if sa != none || sb.Rounds != 1 || sb.EntriesSent != 0 {
+		t.Fatalf("%s: Sync() of equal replicas => %+v; %+v", name, sa, sb)
+	}
+
+	// an empty responder receives everything, without a resolve
+	var empty = hamt64.NewTransient(TableOption)
+	_, rb, _, sb, erra, errb = syncPipe(ra, empty, nil)
+	if erra != nil || errb != nil {
+		t.Fatalf("%s: failed Sync() to empty => %v; %v", name, erra, errb)
+	}
+	if rb != hamt64.Hamt(empty) || !hamt64.Equal(empty, expected, nil) {
+		t.Fatalf("%s: Sync() to an empty HamtTransient failed", name)
+	}
+	if sb.EntriesReceived != int(expected.Nentries()) {
+		t.Fatalf("%s: Sync() to empty received %d entries; expected %d",
+			name, sb.EntriesReceived, expected.Nentries())
+	}
+}
+
+func TestHamt64SyncErrors(t *testing.T) {
+	var name = "TestHamt64SyncErrors:" + hamt64.TableOptionName[TableOption]
+	var a = hamt64.Build(KVS64[:100], TableOption)
+	var b = hamt64.Build(KVS64[50:150], TableOption)
+
+	// the other side is not a Sync
+	for _, initiator := range []bool{true, false} {
+		var ca, cb = net.Pipe()
+		go func() {
+			go io.Copy(io.Discard, cb)
+			cb.Write([]byte("HAMT\x01"))
+			cb.Close()
+		}()
+		var _, _, err = hamt64.Sync(a, ca, initiator, nil)
+		ca.Close()
+		if err == nil {
+			t.Fatalf("%s: Sync(initiator=%t) with a bad header succeeded",
+				name, initiator)
+		}
+	}
+
+	// a value without a codec
+	var c, _ = b.Put(KVS64[200].Key, struct{}{})
+	var _, _, _, _, erra, errb = syncPipe(a, c, nil)
+	if erra == nil || errb == nil {
+		t.Fatalf("%s: Sync() of a value without a codec => %v; %v",
+			name, erra, errb)
+	}
+	if a.Nentries() != 100 {
+		t.Fatalf("%s: failed Sync() modified the initiator", name)
+	}
+}
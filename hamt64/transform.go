@@ -0,0 +1,192 @@
+package hamt64
+
+// filterer holds the state of a Filter walk of a trie.
+type filterer struct {
+	pred       func(KeyI, interface{}) bool
+	nograde    bool
+	startFixed bool
+	removed    uint
+}
+
+// Filter returns a Hamt with only the (key,value) pairs of the HamtFunctional
+// for which pred returns true.
+//
+// Filter walks the existing tables and shares every table and leaf whose
+// pairs are all kept with h. Tables that lose entries are rebuilt following
+// the same table option rules as Del. If every pair is kept, h itself is
+// returned.
+func (h *HamtFunctional) Filter(pred func(KeyI, interface{}) bool) Hamt {
+	var f = filterer{
+		pred:       pred,
+		nograde:    h.nograde,
+		startFixed: h.startFixed,
+	}
+
+	var root = f.filterTable(&h.root, 0)
+
+	if root == nodeI(&h.root) {
+		return h
+	}
+
+	var nh = new(HamtFunctional)
+	nh.nograde = h.nograde
+	nh.startFixed = h.startFixed
+	nh.nentries = h.nentries - f.removed
+
+	// filterTable() always returns a fixedTable at depth 0
+	nh.root.set(root.(*fixedTable))
+
+	return nh
+}
+
+// filterTable filters the table t at the given depth.
+func (f *filterer) filterTable(t tableI, depth uint) nodeI {
+	var ents = make([]tableEntry, 0, t.nentries())
+	var same = true
+
+	// t.get() rather than t.entries() as the latter calls Hash() for
+	// sparseTables.
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var n = t.get(idx)
+		if n == nil {
+			continue
+		}
+
+		var nn nodeI
+		switch x := n.(type) {
+		case tableI:
+			nn = f.filterTable(x, depth+1)
+		case leafI:
+			nn = f.filterLeaf(x)
+		}
+
+		same = same && nn == n
+
+		if nn != nil {
+			ents = append(ents, tableEntry{idx, nn})
+		}
+	}
+
+	if same {
+		return t
+	}
+
+	if depth > 0 {
+		switch len(ents) {
+		case 0:
+			return nil
+		case 1:
+			// collapse a lone leaf into the parent table
+			if _, isLeaf := ents[0].node.(leafI); isLeaf {
+				return ents[0].node
+			}
+		}
+	}
+
+	return newTableFrom(t, depth, ents, f.nograde, f.startFixed)
+}
+
+// filterLeaf filters the pairs of the leaf l.
+func (f *filterer) filterLeaf(l leafI) nodeI {
+	var kvs = l.keyVals()
+	var keep = make([]KeyVal, 0, len(kvs))
+
+	for _, kv := range kvs {
+		if f.pred(kv.Key, kv.Val) {
+			keep = append(keep, kv)
+		} else {
+			f.removed++
+		}
+	}
+
+	switch len(keep) {
+	case len(kvs):
+		return l
+	case 0:
+		return nil
+	case 1:
+		return newFlatLeaf(keep[0].Key, keep[0].Val)
+	}
+	return newCollisionLeaf(keep)
+}
+
+// MapValues returns a Hamt with the same keys as the HamtFunctional, where the
+// value of each key is the result of calling fn on the key and its value.
+//
+// The result has exactly the same table layout as h, so no key is hashed.
+// Every table and leaf where fn returns the same values, see Diff() for what
+// counts as the same, is shared with h. If fn changes no value at all, h
+// itself is returned.
+func (h *HamtFunctional) MapValues(
+	fn func(KeyI, interface{}) interface{},
+) Hamt {
+	var root = mapValuesTable(&h.root, fn)
+
+	if root == tableI(&h.root) {
+		return h
+	}
+
+	var nh = new(HamtFunctional)
+	nh.nograde = h.nograde
+	nh.startFixed = h.startFixed
+	nh.nentries = h.nentries
+	nh.root.set(root.(*fixedTable))
+
+	return nh
+}
+
+// mapValuesTable returns t, if fn changes none of the values in it, or a copy
+// of t with the changed leafs and tables replaced.
+func mapValuesTable(t tableI, fn func(KeyI, interface{}) interface{}) tableI {
+	var nt tableI
+
+	for idx := uint(0); idx < IndexLimit; idx++ {
+		var n = t.get(idx)
+		if n == nil {
+			continue
+		}
+
+		var nn nodeI
+		switch x := n.(type) {
+		case tableI:
+			nn = mapValuesTable(x, fn)
+		case leafI:
+			nn = mapValuesLeaf(x, fn)
+		}
+
+		if nn != n {
+			if nt == nil {
+				nt = t.copy()
+			}
+			nt.replace(idx, nn)
+		}
+	}
+
+	if nt == nil {
+		return t
+	}
+	return nt
+}
+
+// mapValuesLeaf returns l, if fn changes none of its values, or a leaf of the
+// same kind with the new values.
+func mapValuesLeaf(l leafI, fn func(KeyI, interface{}) interface{}) leafI {
+	var kvs = l.keyVals()
+	var nkvs = make([]KeyVal, len(kvs))
+	var changed bool
+
+	for i, kv := range kvs {
+		var val = fn(kv.Key, kv.Val)
+		changed = changed || !sameVal(val, kv.Val)
+		nkvs[i] = KeyVal{kv.Key, val}
+	}
+
+	if !changed {
+		return l
+	}
+
+	if _, isFlat := l.(*flatLeaf); isFlat {
+		return newFlatLeaf(nkvs[0].Key, nkvs[0].Val)
+	}
+	return newCollisionLeaf(nkvs)
+}
@@ -0,0 +1,130 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numTransformKvs = 20 * 1024
+
+// hashCountKey is a StringKey that counts the calls to Hash().
+type hashCountKey string
+
+var hashCount int
+
+func (k hashCountKey) Hash() hamt64.HashVal {
+	hashCount++
+	return hamt64.StringKey(k).Hash()
+}
+
+func (k hashCountKey) Equals(K hamt64.KeyI) bool {
+	var o, ok = K.(hashCountKey)
+	return ok && k == o
+}
+
+func TestHamt64Filter(t *testing.T) {
+	var name = "TestHamt64Filter:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numTransformKvs]
+
+	var h, err = buildHamt64(name, kvs, true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var hf = h.(*hamt64.HamtFunctional)
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	var fexpected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+		if kv.Val.(int)%3 == 0 {
+			fexpected[kv.Key.(hamt64.StringKey)] = kv.Val
+		}
+	}
+
+	var fh = hf.Filter(func(_ hamt64.KeyI, v interface{}) bool {
+		return v.(int)%3 == 0
+	})
+
+	checkHamt64(t, name+" Filter", fh, fexpected)
+	checkHamt64(t, name+" original", h, expected)
+
+	// the result must keep working with single Put and Del calls
+	fh, _ = fh.Put(kvs[1].Key, kvs[1].Val)
+	fh, _, _ = fh.Del(kvs[0].Key)
+	fexpected[kvs[1].Key.(hamt64.StringKey)] = kvs[1].Val
+	delete(fexpected, kvs[0].Key.(hamt64.StringKey))
+	checkHamt64(t, name+" Put&Del", fh, fexpected)
+
+	var all = hf.Filter(func(hamt64.KeyI, interface{}) bool { return true })
+	if all != h {
+		t.Fatalf("%s: Filter(true) did not return the original Hamt", name)
+	}
+
+	var none = hf.Filter(func(hamt64.KeyI, interface{}) bool { return false })
+	checkHamt64(t, name+" Filter(false)", none, nil)
+}
+
+func TestHamt64MapValues(t *testing.T) {
+	var name = "TestHamt64MapValues:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numTransformKvs]
+
+	var h, err = buildHamt64(name, kvs, true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var hf = h.(*hamt64.HamtFunctional)
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	var mexpected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+		mexpected[kv.Key.(hamt64.StringKey)] = 2 * kv.Val.(int)
+	}
+
+	var mh = hf.MapValues(func(_ hamt64.KeyI, v interface{}) interface{} {
+		return 2 * v.(int)
+	})
+
+	checkHamt64(t, name+" MapValues", mh, mexpected)
+	checkHamt64(t, name+" original", h, expected)
+
+	if *mh.Stats() != *h.Stats() {
+		t.Fatalf("%s: MapValues changed the table layout;\n%+v\n%+v",
+			name, mh.Stats(), h.Stats())
+	}
+
+	var same = hf.MapValues(func(_ hamt64.KeyI, v interface{}) interface{} {
+		return v
+	})
+	if same != h {
+		t.Fatalf("%s: MapValues(identity) did not return the original Hamt",
+			name)
+	}
+}
+
+func TestHamt64MapValuesNoHash(t *testing.T) {
+	var name = "TestHamt64MapValuesNoHash:" +
+		hamt64.TableOptionName[TableOption]
+
+	var h = hamt64.Hamt(hamt64.NewFunctional(TableOption))
+	for i, kv := range KVS64[:numTransformKvs] {
+		h, _ = h.Put(hashCountKey(kv.Key.(hamt64.StringKey)), i)
+	}
+
+	hashCount = 0
+	var mh = h.(*hamt64.HamtFunctional).MapValues(
+		func(_ hamt64.KeyI, v interface{}) interface{} {
+			return v.(int) + 1
+		})
+	if hashCount != 0 {
+		t.Fatalf("%s: MapValues called Hash() %d times", name, hashCount)
+	}
+
+	for k := range h.Keys() {
+		var v, _ = h.Get(k)
+		if mv, _ := mh.Get(k); mv != v.(int)+1 {
+			t.Fatalf("%s: mh.Get(%s) => %v; want %d", name, k, mv, v.(int)+1)
+		}
+	}
+}
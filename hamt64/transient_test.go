@@ -0,0 +1,146 @@
+package hamt64_test
+
+import (
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numEditKvs = 20 * 1024
+
+func TestHamt64ToTransientIsolated(t *testing.T) {
+	var name = "TestHamt64ToTransientIsolated:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numEditKvs]
+	var half = len(kvs) / 2
+
+	var h, err = buildHamt64(name, kvs[:half], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[:half] {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+
+	// replace, delete, and add a quarter of the keys each
+	var th = h.ToTransient()
+	var texpected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[half/2 : half] {
+		texpected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	for _, kv := range kvs[half/2 : half/2+half/4] {
+		th.Put(kv.Key, -kv.Val.(int))
+		texpected[kv.Key.(hamt64.StringKey)] = -kv.Val.(int)
+	}
+	for _, kv := range kvs[:half/2] {
+		th.Del(kv.Key)
+	}
+	for _, kv := range kvs[half : half+half/2] {
+		th.Put(kv.Key, kv.Val)
+		texpected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+
+	checkHamt64(t, name+" transient", th, texpected)
+	checkHamt64(t, name+" original", h, expected)
+
+	// a second transient of the same HamtFunctional sees none of it
+	var th2 = h.ToTransient()
+	checkHamt64(t, name+" second transient", th2, expected)
+	th2.Put(kvs[0].Key, "th2")
+	checkHamt64(t, name+" transient after th2.Put", th, texpected)
+	checkHamt64(t, name+" original after th2.Put", h, expected)
+}
+
+func TestHamt64Persistent(t *testing.T) {
+	var name = "TestHamt64Persistent:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numEditKvs]
+	var half = len(kvs) / 2
+
+	var h, err = buildHamt64(name, kvs[:half], true, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+
+	var th = h.ToTransient().(*hamt64.HamtTransient)
+	for _, kv := range kvs[half:] {
+		th.Put(kv.Key, kv.Val)
+	}
+
+	var ph = th.Persistent()
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	checkHamt64(t, name+" persistent", ph, expected)
+
+	// Get and the like still work on the invalidated transient
+	checkHamt64(t, name+" invalidated transient", th, expected)
+
+	var panicked = func(fn func()) (p bool) {
+		defer func() {
+			p = recover() != nil
+		}()
+		fn()
+		return
+	}
+	if !panicked(func() { th.Put(kvs[0].Key, 0) }) {
+		t.Fatalf("%s: Put after Persistent() did not panic", name)
+	}
+	if !panicked(func() { th.Del(kvs[0].Key) }) {
+		t.Fatalf("%s: Del after Persistent() did not panic", name)
+	}
+
+	// the persistent result can be made transient again without affecting it
+	var th2 = ph.ToTransient()
+	for _, kv := range kvs[:half] {
+		th2.Del(kv.Key)
+	}
+	checkHamt64(t, name+" persistent after th2.Del", ph, expected)
+	if th2.Nentries() != uint(len(kvs)-half) {
+		t.Fatalf("%s: th2.Nentries(),%d != %d",
+			name, th2.Nentries(), len(kvs)-half)
+	}
+}
+
+func TestHamt64TransientToFunctional(t *testing.T) {
+	var name = "TestHamt64TransientToFunctional:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numEditKvs]
+	var half = len(kvs) / 2
+
+	var th, err = buildHamt64(name, kvs[:half], false, TableOption)
+	if err != nil {
+		t.Fatalf("%s: failed buildHamt64() => %s", name, err)
+	}
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[:half] {
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+
+	var h = th.ToFunctional()
+	if _, isFunctional := h.(*hamt64.HamtFunctional); !isFunctional {
+		t.Fatalf("%s: ToFunctional() => %T", name, h)
+	}
+
+	// the transient stays usable, and its writes do not reach h
+	var texpected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[half/2:] {
+		texpected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	for _, kv := range kvs[:half/2] {
+		th.Del(kv.Key)
+	}
+	for _, kv := range kvs[half:] {
+		th.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range kvs[half/2 : half] {
+		th.Put(kv.Key, -kv.Val.(int))
+		texpected[kv.Key.(hamt64.StringKey)] = -kv.Val.(int)
+	}
+
+	checkHamt64(t, name+" transient", th, texpected)
+	checkHamt64(t, name+" functional", h, expected)
+}
@@ -0,0 +1,394 @@
+package hamt64
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/pstuifzand/go-hamt/internal/wal"
+)
+
+// A Durable keeps its state in a directory of snapshots and write-ahead logs,
+// in the layout of package internal/wal:
+//
+//	snapshot-<seq>  the Hamt, in the Encode format, as it was before the
+//	                first record of log <seq>
+//	wal-<seq>       the Puts and Dels since the snapshot, in order
+//
+// The payload of a log record is a 'P' followed by a key and a value, or a
+// 'D' followed by a key, each a codec name chunk and a chunk, as in the
+// Encode format.
+//
+// A record that runs to the end of the last log, but is cut short or does not
+// match its crc, is taken to be left by a crash in the middle of a write, and
+// OpenDurable truncates the log before it. A bad record anywhere else is
+// corruption; OpenDurable fails, and leaves the log as it is.
+
+const (
+	walPutTag = 'P'
+	walDelTag = 'D'
+)
+
+// SyncPolicy is how often a Durable fsyncs its log: after every n records.
+// SyncNever leaves it to the operating system, and to Durable.Sync.
+type SyncPolicy int
+
+const (
+	// SyncNever does not fsync the log except for Sync, Checkpoint and Close.
+	SyncNever SyncPolicy = 0
+	// SyncAlways fsyncs the log after every record, before the Put or Del
+	// is applied.
+	SyncAlways SyncPolicy = 1
+)
+
+// SyncEvery returns the SyncPolicy to fsync the log after every n records. A
+// crash can lose the last n-1 of them.
+func SyncEvery(n int) SyncPolicy {
+	return SyncPolicy(n)
+}
+
+// Durable wraps a HamtTransient with a write-ahead log, so it survives a
+// crash of the process: every Put and Del is appended to the log before it is
+// applied, and OpenDurable rebuilds the Hamt from the latest snapshot and the
+// log. Checkpoint writes a new snapshot and starts a new log.
+//
+// If writing or fsyncing the log fails, the log may hold part of a record,
+// or may have lost records the Durable applied, so every later Put, Del, Sync
+// and Checkpoint fails too. The Durable must be closed and opened again,
+// which recovers what the log holds.
+//
+// A Durable is safe to use from several goroutines; it serializes every
+// method on one lock.
+type Durable struct {
+	mu       sync.Mutex
+	dir      wal.Dir
+	h        *HamtTransient
+	reg      *Registry
+	policy   SyncPolicy
+	log      *wal.Log
+	seq      uint64 // of log
+	unsynced int    // records written to log since the last fsync
+	buf      []byte
+}
+
+// OpenDurable opens the Durable in dir, creating dir if need be. A new
+// Durable starts out empty, with the table option tblOpt; an existing one
+// keeps the table option of its snapshot. The keys and values are encoded by
+// the codecs of reg; if reg is nil DefaultRegistry is used.
+func OpenDurable(
+	dir string,
+	tblOpt int,
+	policy SyncPolicy,
+	reg *Registry,
+) (*Durable, error) {
+	if reg == nil {
+		reg = DefaultRegistry
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "OpenDurable")
+	}
+
+	var d = &Durable{
+		dir:    wal.Dir{Path: dir, LogPrefix: "wal"},
+		reg:    reg,
+		policy: policy,
+	}
+	if err := d.recover(tblOpt); err != nil {
+		return nil, errors.Wrap(err, "OpenDurable")
+	}
+	return d, nil
+}
+
+// recover loads the latest snapshot, replays the logs after it, and opens
+// the last log for appending.
+func (d *Durable) recover(tblOpt int) error {
+	var snap, logs, err = d.dir.Recover()
+	if err != nil {
+		return err
+	}
+
+	d.seq = 1
+	d.h = NewTransient(tblOpt)
+	if snap > 0 {
+		d.seq = snap
+		if err = d.loadSnapshot(d.seq); err != nil {
+			return err
+		}
+	}
+
+	for i, seq := range logs {
+		var last = i == len(logs)-1
+		if err = d.dir.Replay(seq, last, d.apply); err != nil {
+			return err
+		}
+	}
+
+	if len(logs) > 0 {
+		d.seq = logs[len(logs)-1]
+	}
+	d.log, err = d.dir.OpenLog(d.seq)
+	return err
+}
+
+func (d *Durable) loadSnapshot(seq uint64) error {
+	var f, err = os.Open(d.dir.SnapshotPath(seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h, err := Decode(f, false, d.reg)
+	if err != nil {
+		return errors.Wrapf(err, "snapshot %016x", seq)
+	}
+	d.h = h.(*HamtTransient)
+	return nil
+}
+
+// apply decodes a record payload and applies it to the Hamt.
+func (d *Durable) apply(payload []byte) error {
+	if len(payload) == 0 {
+		return errors.New("empty record")
+	}
+
+	var vals [2]interface{}
+	var nvals = 1
+	if payload[0] == walPutTag {
+		nvals = 2
+	} else if payload[0] != walDelTag {
+		return errors.Errorf("unknown record tag %q", payload[0])
+	}
+
+	var p = payload[1:]
+	for i := 0; i < nvals; i++ {
+		var name, bs []byte
+		var err error
+		if name, p, err = cutChunk(p); err != nil {
+			return err
+		}
+		if bs, p, err = cutChunk(p); err != nil {
+			return err
+		}
+		c, err := d.reg.lookupName(string(name))
+		if err != nil {
+			return err
+		}
+		if vals[i], err = c.dec(bs); err != nil {
+			return errors.Wrapf(err, "codec %q", c.name)
+		}
+	}
+	if len(p) != 0 {
+		return errors.New("trailing bytes in record")
+	}
+
+	var key, isKey = vals[0].(KeyI)
+	if !isKey {
+		return errors.Errorf("decoded key of type %T is not a KeyI", vals[0])
+	}
+
+	if payload[0] == walPutTag {
+		d.h.Put(key, vals[1])
+	} else {
+		d.h.Del(key)
+	}
+	return nil
+}
+
+// cutChunk returns the chunk at the start of p, and the rest of p.
+func cutChunk(p []byte) ([]byte, []byte, error) {
+	var n, w = binary.Uvarint(p)
+	if w <= 0 || n > uint64(len(p)-w) {
+		return nil, nil, errors.New("bad chunk")
+	}
+	p = p[w:]
+	return p[:n], p[n:], nil
+}
+
+// appendKV appends the codec name chunk and the chunk of v to buf.
+func (d *Durable) appendKV(buf []byte, v interface{}) ([]byte, error) {
+	var c, err = d.reg.lookupType(v)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := c.enc(v)
+	if err != nil {
+		return nil, err
+	}
+	buf = appendChunk(buf, []byte(c.name))
+	return appendChunk(buf, bs), nil
+}
+
+// usable returns an error if the Durable is closed or failed.
+func (d *Durable) usable() error {
+	if d.log == nil {
+		return errors.New("Durable is closed")
+	}
+	return d.log.Err()
+}
+
+// logRecord appends a record to the log, and fsyncs it if the SyncPolicy
+// says so.
+func (d *Durable) logRecord(tag byte, key KeyI, val interface{}) error {
+	if err := d.usable(); err != nil {
+		return err
+	}
+
+	var buf = append(d.buf[:0], make([]byte, wal.HeaderSize)...)
+	buf = append(buf, tag)
+	var err error
+	if buf, err = d.appendKV(buf, key); err != nil {
+		return err
+	}
+	if tag == walPutTag {
+		if buf, err = d.appendKV(buf, val); err != nil {
+			return err
+		}
+	}
+	d.buf = buf
+
+	if err = d.log.Append(buf); err != nil {
+		return err
+	}
+
+	d.unsynced++
+	if d.policy > 0 && d.unsynced >= int(d.policy) {
+		return d.sync()
+	}
+	return nil
+}
+
+func (d *Durable) sync() error {
+	if d.unsynced == 0 {
+		return nil
+	}
+	if err := d.log.Sync(); err != nil {
+		return err
+	}
+	d.unsynced = 0
+	return nil
+}
+
+// Get retrieves the value of the key.
+func (d *Durable) Get(key KeyI) (interface{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.h.Get(key)
+}
+
+// Nentries returns the number of (key,value) pairs.
+func (d *Durable) Nentries() uint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.h.Nentries()
+}
+
+// Range calls fn for every KeyVal pair, until fn returns false. fn must not
+// call the methods of d.
+func (d *Durable) Range(fn func(KeyI, interface{}) bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.h.Range(fn)
+}
+
+// Put logs, then applies, the Put of the key and value. It returns if the key
+// was added, as opposed to replaced. If writing the log fails, nothing is
+// applied.
+func (d *Durable) Put(key KeyI, val interface{}) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.logRecord(walPutTag, key, val); err != nil {
+		return false, errors.Wrap(err, "Put")
+	}
+	var _, added = d.h.Put(key, val)
+	return added, nil
+}
+
+// Del logs, then applies, the Del of the key, if it exists. It returns the
+// value that was deleted and if it was. If writing the log fails, nothing is
+// applied.
+func (d *Durable) Del(key KeyI) (interface{}, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, found := d.h.Get(key); !found {
+		return nil, false, nil
+	}
+	if err := d.logRecord(walDelTag, key, nil); err != nil {
+		return nil, false, errors.Wrap(err, "Del")
+	}
+	var _, val, deleted = d.h.Del(key)
+	return val, deleted, nil
+}
+
+// Sync fsyncs the log, whatever the SyncPolicy.
+func (d *Durable) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.usable(); err != nil {
+		return errors.Wrap(err, "Sync")
+	}
+	return errors.Wrap(d.sync(), "Sync")
+}
+
+// Checkpoint writes a snapshot of the Hamt and starts a new log, then removes
+// the old snapshot and log. It holds up every other method until it is done.
+//
+// The new log is created before the snapshot, which only appears, by a
+// rename, once it is complete. So a crash in the middle of a Checkpoint
+// leaves the old snapshot and the logs after it, or the new snapshot and the
+// new log, to recover from; and if the Checkpoint fails the Durable goes on
+// with the old ones.
+func (d *Durable) Checkpoint() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.usable(); err != nil {
+		return errors.Wrap(err, "Checkpoint")
+	}
+
+	var seq = d.seq + 1
+	var log, err = d.dir.OpenLog(seq)
+	if err != nil {
+		return errors.Wrap(err, "Checkpoint")
+	}
+
+	err = d.dir.WriteSnapshot(seq, func(w io.Writer) error {
+		return Encode(w, d.h, d.reg)
+	})
+	if err != nil {
+		log.Close()
+		os.Remove(d.dir.LogPath(seq))
+		return errors.Wrap(err, "Checkpoint")
+	}
+
+	// Everything in the old log is in the snapshot now.
+	d.log.Close()
+	d.log, d.seq, d.unsynced = log, seq, 0
+	d.dir.RemoveBefore(seq)
+
+	return nil
+}
+
+// Close fsyncs and closes the log. The Durable can not be used afterwards.
+// Closing a failed Durable does not fsync, and returns no error.
+func (d *Durable) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.log == nil {
+		return nil
+	}
+	var err error
+	if d.log.Err() == nil {
+		err = d.sync()
+	}
+	if cerr := d.log.Close(); err == nil {
+		err = cerr
+	}
+	d.log = nil
+	return errors.Wrap(err, "Close")
+}
@@ -0,0 +1,89 @@
+package hamt64_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+// fillDisk makes every later write to the open file path fail with ENOSPC,
+// by putting /dev/full in the place of its descriptor.
+func fillDisk(t *testing.T, path string) {
+	var full, err = os.OpenFile("/dev/full", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("no /dev/full: %s", err)
+	}
+	defer full.Close()
+
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("no /proc/self/fd: %s", err)
+	}
+	for _, ent := range fds {
+		var link = filepath.Join("/proc/self/fd", ent.Name())
+		if target, _ := os.Readlink(link); target != path {
+			continue
+		}
+		var fd, _ = strconv.Atoi(ent.Name())
+		if err = syscall.Dup3(int(full.Fd()), fd, 0); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	t.Fatalf("%s is not open", path)
+}
+
+func TestHamt64DurableFailed(t *testing.T) {
+	var name = "TestHamt64DurableFailed:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:16]
+	var dir = t.TempDir()
+
+	var d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncAlways, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+	}
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[:8] {
+		d.Put(kv.Key, kv.Val)
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+
+	fillDisk(t, walFiles(t, dir)[0])
+	if _, err = d.Put(kvs[8].Key, kvs[8].Val); err == nil {
+		t.Fatalf("%s: d.Put() to a full disk succeeded", name)
+	}
+	checkDurable64(t, name, d, expected)
+
+	// the Durable stays failed
+	if _, err = d.Put(kvs[9].Key, kvs[9].Val); err == nil {
+		t.Fatalf("%s: d.Put() after a failed d.Put() succeeded", name)
+	}
+	if _, _, err = d.Del(kvs[0].Key); err == nil {
+		t.Fatalf("%s: d.Del() after a failed d.Put() succeeded", name)
+	}
+	if err = d.Sync(); err == nil {
+		t.Fatalf("%s: d.Sync() after a failed d.Put() succeeded", name)
+	}
+	if err = d.Checkpoint(); err == nil {
+		t.Fatalf("%s: d.Checkpoint() after a failed d.Put() succeeded", name)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatalf("%s: failed d.Close() => %s", name, err)
+	}
+
+	// opening it again recovers what the log holds
+	d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncAlways, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+	}
+	checkDurable64(t, name, d, expected)
+	if _, err = d.Put(kvs[8].Key, kvs[8].Val); err != nil {
+		t.Fatalf("%s: failed d.Put() => %s", name, err)
+	}
+	d.Close()
+}
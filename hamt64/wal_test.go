@@ -0,0 +1,332 @@
+package hamt64_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+var numDurableKvs = 4 * 1024
+
+// checkDurable64 checks that d holds exactly the expected pairs.
+func checkDurable64(
+	t *testing.T,
+	name string,
+	d *hamt64.Durable,
+	expected map[hamt64.StringKey]interface{},
+) {
+	if d.Nentries() != uint(len(expected)) {
+		t.Fatalf("%s: d.Nentries() %d != %d", name, d.Nentries(), len(expected))
+	}
+	for k, v := range expected {
+		if val, found := d.Get(k); !found || val != v {
+			t.Fatalf("%s: d.Get(%s) => %v, %t; expected %v",
+				name, k, val, found, v)
+		}
+	}
+}
+
+// walFiles returns the names of the logs in dir.
+func walFiles(t *testing.T, dir string) []string {
+	var names, err = filepath.Glob(filepath.Join(dir, "wal-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestHamt64Durable(t *testing.T) {
+	var name = "TestHamt64Durable:" + hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numDurableKvs]
+	var dir = t.TempDir()
+
+	var d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncNever, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+	}
+
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs {
+		if _, err = d.Put(kv.Key, kv.Val); err != nil {
+			t.Fatalf("%s: failed d.Put() => %s", name, err)
+		}
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	for _, kv := range kvs[:len(kvs)/4] {
+		if _, _, err = d.Del(kv.Key); err != nil {
+			t.Fatalf("%s: failed d.Del() => %s", name, err)
+		}
+		delete(expected, kv.Key.(hamt64.StringKey))
+	}
+	if _, deleted, _ := d.Del(kvs[0].Key); deleted {
+		t.Fatalf("%s: d.Del() of a deleted key => true", name)
+	}
+	checkDurable64(t, name, d, expected)
+
+	if err = d.Close(); err != nil {
+		t.Fatalf("%s: failed d.Close() => %s", name, err)
+	}
+	if _, err = d.Put(kvs[0].Key, 1); err == nil {
+		t.Fatalf("%s: d.Put() after d.Close() succeeded", name)
+	}
+
+	// the table option of a new Durable is ignored for an existing one
+	d, err = hamt64.OpenDurable(dir, (TableOption+1)%3, hamt64.SyncAlways, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenDurable() of existing => %s", name, err)
+	}
+	checkDurable64(t, name, d, expected)
+
+	if _, err = d.Put(kvs[0].Key, -1); err != nil {
+		t.Fatalf("%s: failed d.Put() => %s", name, err)
+	}
+	expected[kvs[0].Key.(hamt64.StringKey)] = -1
+	d.Close()
+
+	d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncNever, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+	}
+	checkDurable64(t, name, d, expected)
+	d.Close()
+}
+
+func TestHamt64DurableTornTail(t *testing.T) {
+	var name = "TestHamt64DurableTornTail:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:16]
+	var dir = t.TempDir()
+
+	var d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncEvery(4), nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+	}
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs {
+		d.Put(kv.Key, kv.Val)
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	d.Close()
+
+	var logs = walFiles(t, dir)
+	if len(logs) != 1 {
+		t.Fatalf("%s: %d logs; expected 1", name, len(logs))
+	}
+	var data, _ = os.ReadFile(logs[0])
+
+	for _, torn := range []struct {
+		desc string
+		data []byte
+		lost bool // the last Put
+	}{
+		{"a partial header", append(append([]byte(nil), data...), 7, 0),
+			false},
+		{"a partial record", data[:len(data)-3], true},
+		{"a bad crc", append(append([]byte(nil), data[:len(data)-1]...),
+			data[len(data)-1]^0xff), true},
+	} {
+		if err = os.WriteFile(logs[0], torn.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncAlways, nil)
+		if err != nil {
+			t.Fatalf("%s: failed OpenDurable() with %s => %s",
+				name, torn.desc, err)
+		}
+
+		var exp = expected
+		if torn.lost {
+			exp = make(map[hamt64.StringKey]interface{})
+			for _, kv := range kvs[:len(kvs)-1] {
+				exp[kv.Key.(hamt64.StringKey)] = kv.Val
+			}
+		}
+		checkDurable64(t, name+" "+torn.desc, d, exp)
+
+		// the log was truncated, so new records are readable
+		d.Put(hamt64.StringKey("new"), 1)
+		d.Close()
+		d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncAlways, nil)
+		if err != nil {
+			t.Fatalf("%s: failed OpenDurable() after %s => %s",
+				name, torn.desc, err)
+		}
+		if val, found := d.Get(hamt64.StringKey("new")); !found || val != 1 {
+			t.Fatalf("%s: Put() after %s was lost", name, torn.desc)
+		}
+		d.Close()
+	}
+}
+
+func TestHamt64DurableCheckpoint(t *testing.T) {
+	var name = "TestHamt64DurableCheckpoint:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numDurableKvs]
+	var half = len(kvs) / 2
+	var dir = t.TempDir()
+
+	var d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncNever, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+	}
+	var expected = make(map[hamt64.StringKey]interface{})
+	for _, kv := range kvs[:half] {
+		d.Put(kv.Key, kv.Val)
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+
+	var before = walFiles(t, dir)
+	if err = d.Checkpoint(); err != nil {
+		t.Fatalf("%s: failed d.Checkpoint() => %s", name, err)
+	}
+	var after = walFiles(t, dir)
+	if len(after) != 1 || after[0] == before[0] {
+		t.Fatalf("%s: logs %v after d.Checkpoint(); were %v",
+			name, after, before)
+	}
+	if fi, _ := os.Stat(after[0]); fi.Size() != 0 {
+		t.Fatalf("%s: new log is %d bytes", name, fi.Size())
+	}
+
+	for _, kv := range kvs[half:] {
+		d.Put(kv.Key, kv.Val)
+		expected[kv.Key.(hamt64.StringKey)] = kv.Val
+	}
+	d.Del(kvs[0].Key)
+	delete(expected, kvs[0].Key.(hamt64.StringKey))
+
+	// no Close; a crash with the log in the page cache
+	d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncNever, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+	}
+	checkDurable64(t, name, d, expected)
+
+	if err = d.Checkpoint(); err != nil {
+		t.Fatalf("%s: failed d.Checkpoint() => %s", name, err)
+	}
+	d.Close()
+
+	var snaps, _ = filepath.Glob(filepath.Join(dir, "snapshot-*"))
+	if len(snaps) != 1 || len(walFiles(t, dir)) != 1 {
+		t.Fatalf("%s: %d snapshots and %d logs after d.Checkpoint()",
+			name, len(snaps), len(walFiles(t, dir)))
+	}
+
+	d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncNever, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+	}
+	checkDurable64(t, name, d, expected)
+	d.Close()
+}
+
+func TestHamt64DurableCorrupt(t *testing.T) {
+	var name = "TestHamt64DurableCorrupt:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:16]
+	var dir = t.TempDir()
+
+	var d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncNever, nil)
+	if err != nil {
+		t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+	}
+	for _, kv := range kvs {
+		d.Put(kv.Key, kv.Val)
+	}
+	d.Close()
+
+	var logs = walFiles(t, dir)
+	var data, _ = os.ReadFile(logs[0])
+	var size = int(binary.LittleEndian.Uint32(data))
+
+	// a bad first record is not a torn write
+	for _, corrupt := range []struct {
+		desc string
+		off  int
+	}{
+		{"a bad crc", 8 + size - 1},
+		{"a bad length", 0},
+	} {
+		var bad = append([]byte(nil), data...)
+		bad[corrupt.off] ^= 1
+		if err = os.WriteFile(logs[0], bad, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncNever, nil)
+		if err == nil {
+			d.Close()
+			t.Fatalf("%s: OpenDurable() with %s succeeded",
+				name, corrupt.desc)
+		}
+		if fi, _ := os.Stat(logs[0]); fi.Size() != int64(len(bad)) {
+			t.Fatalf("%s: OpenDurable() with %s truncated the log to %d",
+				name, corrupt.desc, fi.Size())
+		}
+	}
+}
+
+func TestHamt64DurableCheckpointFailed(t *testing.T) {
+	var name = "TestHamt64DurableCheckpointFailed:" +
+		hamt64.TableOptionName[TableOption]
+	var kvs = KVS64[:numDurableKvs]
+	var half = len(kvs) / 2
+
+	// a directory in the way of the new snapshot or log makes creating it
+	// fail
+	for _, obstacle := range []string{
+		"snapshot-0000000000000002.tmp",
+		"wal-0000000000000002",
+	} {
+		var dir = t.TempDir()
+		var d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncNever,
+			nil)
+		if err != nil {
+			t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+		}
+		var expected = make(map[hamt64.StringKey]interface{})
+		for _, kv := range kvs[:half] {
+			d.Put(kv.Key, kv.Val)
+			expected[kv.Key.(hamt64.StringKey)] = kv.Val
+		}
+
+		var before = walFiles(t, dir)
+		if err = os.Mkdir(filepath.Join(dir, obstacle), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = d.Checkpoint(); err == nil {
+			t.Fatalf("%s: d.Checkpoint() with %s in the way succeeded",
+				name, obstacle)
+		}
+		os.Remove(filepath.Join(dir, obstacle))
+		var after = walFiles(t, dir)
+		if len(after) != 1 || after[0] != before[0] {
+			t.Fatalf("%s: logs %v after a failed d.Checkpoint(); were %v",
+				name, after, before)
+		}
+
+		// the Durable goes on with the old snapshot and log
+		for _, kv := range kvs[half:] {
+			if _, err = d.Put(kv.Key, kv.Val); err != nil {
+				t.Fatalf("%s: failed d.Put() => %s", name, err)
+			}
+			expected[kv.Key.(hamt64.StringKey)] = kv.Val
+		}
+		d.Close()
+
+		d, err = hamt64.OpenDurable(dir, TableOption, hamt64.SyncNever, nil)
+		if err != nil {
+			t.Fatalf("%s: failed OpenDurable() => %s", name, err)
+		}
+		checkDurable64(t, name+" "+obstacle, d, expected)
+		if err = d.Checkpoint(); err != nil {
+			t.Fatalf("%s: failed d.Checkpoint() => %s", name, err)
+		}
+		d.Close()
+	}
+}
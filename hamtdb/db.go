@@ -0,0 +1,253 @@
+/*
+Package hamtdb is an embedded key-value store built on hamt64.HamtFunctional.
+
+One writer at a time runs an Update transaction, which commits a new
+HamtFunctional root atomically; any number of readers run View on the root
+that was current when they started, without locks, and never see a
+transaction half done. A transaction that fails is rolled back by dropping
+it; the root it started from is untouched.
+
+The state is kept in a directory of snapshots and logs, in the layout of
+package internal/wal, which hamt64.Durable uses too:
+
+	snapshot-<seq>  the root, in the hamt64.Encode format, as it was before
+	                the first record of log <seq>
+	log-<seq>       the committed transactions since the snapshot, in order
+
+The payload of a log record is the delta of one transaction, written by
+hamt64.WriteDelta, so recovery checks that each one is applied to the root it
+was made from. Keys and values are encoded by the codecs of
+hamt64.DefaultRegistry.
+*/
+package hamtdb
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/pstuifzand/go-hamt/hamt64"
+	"github.com/pstuifzand/go-hamt/internal/wal"
+)
+
+// ErrClosed is returned by the methods of a DB after Close.
+var ErrClosed = errors.New("hamtdb: DB is closed")
+
+// DB is a key-value store in a directory. It is safe to use from several
+// goroutines.
+type DB struct {
+	dir  wal.Dir
+	root atomic.Pointer[hamt64.HamtFunctional] // nil once closed
+
+	mu  sync.Mutex // serializes Updates, and guards the fields below
+	log *wal.Log
+	seq uint64 // of log
+	buf bytes.Buffer
+
+	ckptMu sync.Mutex // serializes Checkpoints
+}
+
+// Open opens the DB in dir, creating dir if need be. It loads the latest
+// snapshot and replays the logs after it. A record that runs to the end of
+// the last log, but is cut short or fails its crc, is taken to be a commit
+// torn by a crash, which was never acknowledged, and is truncated. A bad
+// record anywhere else is corruption, and Open fails.
+func Open(dir string) (*DB, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "hamtdb.Open")
+	}
+
+	var db = &DB{dir: wal.Dir{Path: dir, LogPrefix: "log"}}
+	if err := db.recover(); err != nil {
+		return nil, errors.Wrap(err, "hamtdb.Open")
+	}
+	return db, nil
+}
+
+// recover loads the latest snapshot, replays the logs after it, and opens
+// the last log for appending.
+func (db *DB) recover() error {
+	var snap, logs, err = db.dir.Recover()
+	if err != nil {
+		return err
+	}
+
+	db.seq = 1
+	var root = hamt64.NewFunctional(hamt64.HybridTables)
+	if snap > 0 {
+		db.seq = snap
+		if root, err = db.loadSnapshot(db.seq); err != nil {
+			return err
+		}
+	}
+
+	// A whole record that does not apply is not a torn write.
+	var apply = func(delta []byte) error {
+		var h, err = hamt64.ApplyDelta(root, bytes.NewReader(delta))
+		if err != nil {
+			return err
+		}
+		root = h.(*hamt64.HamtFunctional)
+		return nil
+	}
+	for i, seq := range logs {
+		var last = i == len(logs)-1
+		if err = db.dir.Replay(seq, last, apply); err != nil {
+			return err
+		}
+	}
+
+	if len(logs) > 0 {
+		db.seq = logs[len(logs)-1]
+	}
+	if db.log, err = db.dir.OpenLog(db.seq); err != nil {
+		return err
+	}
+
+	db.root.Store(root)
+	return nil
+}
+
+func (db *DB) loadSnapshot(seq uint64) (*hamt64.HamtFunctional, error) {
+	var f, err = os.Open(db.dir.SnapshotPath(seq))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h, err := hamt64.Decode(f, true, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "snapshot %016x", seq)
+	}
+	return h.(*hamt64.HamtFunctional), nil
+}
+
+// View calls fn with the current root. The root is a HamtFunctional, so fn
+// can not change it, and later Updates do not change it either; fn sees the
+// same consistent snapshot however long it runs. View takes no locks, so it
+// neither waits for nor holds up Updates. It returns the error of fn.
+func (db *DB) View(fn func(snapshot hamt64.Hamt) error) error {
+	var root = db.root.Load()
+	if root == nil {
+		return ErrClosed
+	}
+	return fn(root)
+}
+
+// Update calls fn with a new Tx on the current root. If fn returns nil the
+// changes made through the Tx are appended to the log, fsynced, and then
+// committed as the new root in one atomic step. If fn returns an error, or
+// panics, or the log can not be written, the Tx is rolled back: the root is
+// left as it was.
+//
+// If writing or fsyncing the log fails, the log may hold part of the commit,
+// so every later Update and Checkpoint fails too, until the DB is closed and
+// opened again.
+//
+// Updates run one at a time. Views started before the commit keep seeing the
+// old root.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var root = db.root.Load()
+	if root == nil {
+		return ErrClosed
+	}
+	if err := db.log.Err(); err != nil {
+		return errors.Wrap(err, "hamtdb.Update")
+	}
+
+	var tx = &Tx{h: root.ToTransient().(*hamt64.HamtTransient)}
+	defer func() { tx.h = nil }()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if !tx.changed {
+		return nil
+	}
+
+	var next = tx.h.Persistent()
+	if err := db.logCommit(root, next); err != nil {
+		return errors.Wrap(err, "hamtdb.Update")
+	}
+	db.root.Store(next)
+	return nil
+}
+
+// logCommit appends the delta from root to next to the log and fsyncs it.
+func (db *DB) logCommit(root, next *hamt64.HamtFunctional) error {
+	db.buf.Reset()
+	db.buf.Write(make([]byte, wal.HeaderSize))
+	if err := hamt64.WriteDelta(root, next, &db.buf); err != nil {
+		return err
+	}
+
+	if err := db.log.Append(db.buf.Bytes()); err != nil {
+		return err
+	}
+	return db.log.Sync()
+}
+
+// Checkpoint writes a snapshot of the current root and starts a new log, then
+// removes the old snapshot and logs, so Open has less to replay. Updates are
+// held up only while the new log is created; the snapshot is written from the
+// root, which does not change, while they go on.
+//
+// A crash in the middle of a Checkpoint leaves the old snapshot and the logs
+// after it, or the new snapshot and the new log, to recover from.
+func (db *DB) Checkpoint() error {
+	db.ckptMu.Lock()
+	defer db.ckptMu.Unlock()
+
+	db.mu.Lock()
+	var root = db.root.Load()
+	if root == nil {
+		db.mu.Unlock()
+		return ErrClosed
+	}
+	// A failed log may end in part of a commit, which only the last log
+	// may do.
+	if err := db.log.Err(); err != nil {
+		db.mu.Unlock()
+		return errors.Wrap(err, "hamtdb.Checkpoint")
+	}
+	var seq = db.seq + 1
+	var log, err = db.dir.OpenLog(seq)
+	if err != nil {
+		db.mu.Unlock()
+		return errors.Wrap(err, "hamtdb.Checkpoint")
+	}
+	db.log.Close()
+	db.log, db.seq = log, seq
+	db.mu.Unlock()
+
+	err = db.dir.WriteSnapshot(seq, func(w io.Writer) error {
+		return hamt64.Encode(w, root, nil)
+	})
+	if err != nil {
+		return errors.Wrap(err, "hamtdb.Checkpoint")
+	}
+
+	// Everything in the older files is in the snapshot now.
+	return errors.Wrap(db.dir.RemoveBefore(seq), "hamtdb.Checkpoint")
+}
+
+// Close closes the log. Every committed Update is already fsynced. View,
+// Update and Checkpoint fail with ErrClosed afterwards.
+func (db *DB) Close() error {
+	db.ckptMu.Lock()
+	defer db.ckptMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.root.Load() == nil {
+		return nil
+	}
+	db.root.Store(nil)
+	return errors.Wrap(db.log.Close(), "hamtdb.Close")
+}
@@ -0,0 +1,334 @@
+package hamtdb_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/pstuifzand/go-hamt/hamt64"
+	"github.com/pstuifzand/go-hamt/hamtdb"
+)
+
+func key(i int) hamt64.StringKey {
+	return hamt64.StringKey(fmt.Sprintf("key%06d", i))
+}
+
+func openDB(t *testing.T, dir string) *hamtdb.DB {
+	var db, err = hamtdb.Open(dir)
+	if err != nil {
+		t.Fatalf("failed hamtdb.Open() => %s", err)
+	}
+	return db
+}
+
+// putRange commits one Update that puts the keys from..to-1 with val.
+func putRange(t *testing.T, db *hamtdb.DB, from, to, val int) {
+	var err = db.Update(func(tx *hamtdb.Tx) error {
+		for i := from; i < to; i++ {
+			tx.Put(key(i), val)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed Update() => %s", err)
+	}
+}
+
+// checkDB checks that db holds exactly the expected pairs.
+func checkDB(t *testing.T, db *hamtdb.DB, expected map[hamt64.KeyI]int) {
+	var err = db.View(func(h hamt64.Hamt) error {
+		if h.Nentries() != uint(len(expected)) {
+			return errors.Errorf("Nentries() %d != %d",
+				h.Nentries(), len(expected))
+		}
+		for k, v := range expected {
+			if val, found := h.Get(k); !found || val != v {
+				return errors.Errorf("Get(%s) => %v, %t; expected %d",
+					k, val, found, v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View() => %s", err)
+	}
+}
+
+func logFiles(t *testing.T, dir string) []string {
+	var names, err = filepath.Glob(filepath.Join(dir, "log-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestUpdateView(t *testing.T) {
+	var dir = t.TempDir()
+	var db = openDB(t, dir)
+
+	var expected = make(map[hamt64.KeyI]int)
+	for i := 0; i < 10; i++ {
+		putRange(t, db, i*100, (i+1)*100, i)
+		for j := i * 100; j < (i+1)*100; j++ {
+			expected[key(j)] = i
+		}
+	}
+	var err = db.Update(func(tx *hamtdb.Tx) error {
+		for i := 0; i < 50; i++ {
+			if _, deleted := tx.Del(key(i)); !deleted {
+				return errors.Errorf("tx.Del(%s) => false", key(i))
+			}
+			delete(expected, key(i))
+		}
+		if val, found := tx.Get(key(100)); !found || val != 1 {
+			return errors.Errorf("tx.Get(%s) => %v, %t", key(100), val, found)
+		}
+		if _, found := tx.Get(key(0)); found {
+			return errors.New("tx.Get() of a key the Tx deleted => true")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed Update() => %s", err)
+	}
+	checkDB(t, db, expected)
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed Close() => %s", err)
+	}
+	if err = db.View(func(hamt64.Hamt) error { return nil }); err !=
+		hamtdb.ErrClosed {
+		t.Fatalf("View() after Close() => %v", err)
+	}
+	if err = db.Update(func(*hamtdb.Tx) error { return nil }); err !=
+		hamtdb.ErrClosed {
+		t.Fatalf("Update() after Close() => %v", err)
+	}
+
+	db = openDB(t, dir)
+	checkDB(t, db, expected)
+	db.Close()
+}
+
+func TestRollback(t *testing.T) {
+	var dir = t.TempDir()
+	var db = openDB(t, dir)
+	defer db.Close()
+
+	putRange(t, db, 0, 100, 1)
+	var expected = make(map[hamt64.KeyI]int)
+	for i := 0; i < 100; i++ {
+		expected[key(i)] = 1
+	}
+	var fi, _ = os.Stat(logFiles(t, dir)[0])
+
+	var failed = errors.New("failed")
+	var err = db.Update(func(tx *hamtdb.Tx) error {
+		tx.Put(key(0), -1)
+		tx.Del(key(1))
+		tx.Put(key(1000), -1)
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("Update() => %v; expected the error of fn", err)
+	}
+	checkDB(t, db, expected)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Update() did not pass on the panic of fn")
+			}
+		}()
+		db.Update(func(tx *hamtdb.Tx) error {
+			tx.Put(key(0), -1)
+			panic("failed")
+		})
+	}()
+	checkDB(t, db, expected)
+
+	if fi2, _ := os.Stat(logFiles(t, dir)[0]); fi2.Size() != fi.Size() {
+		t.Fatalf("rolled back Updates grew the log %d => %d",
+			fi.Size(), fi2.Size())
+	}
+
+	// the Tx is dead once its Update returned
+	var leaked *hamtdb.Tx
+	db.Update(func(tx *hamtdb.Tx) error { leaked = tx; return nil })
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Put() on a Tx after its Update did not panic")
+			}
+		}()
+		leaked.Put(key(0), -1)
+	}()
+	checkDB(t, db, expected)
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	var db = openDB(t, t.TempDir())
+	defer db.Close()
+
+	putRange(t, db, 0, 100, 1)
+
+	var started = make(chan struct{})
+	var committed = make(chan struct{})
+	var done = make(chan error)
+	go func() {
+		done <- db.View(func(h hamt64.Hamt) error {
+			close(started)
+			<-committed
+			for i := 0; i < 100; i++ {
+				if val, _ := h.Get(key(i)); val != 1 {
+					return errors.Errorf("Get(%s) => %v after a commit",
+						key(i), val)
+				}
+			}
+			if h.Nentries() != 100 {
+				return errors.Errorf("Nentries() => %d after a commit",
+					h.Nentries())
+			}
+			return nil
+		})
+	}()
+
+	<-started
+	putRange(t, db, 0, 200, 2)
+	close(committed)
+	if err := <-done; err != nil {
+		t.Fatalf("View() => %s", err)
+	}
+
+	// concurrent Views of a busy writer see whole transactions only
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				var err = db.View(func(h hamt64.Hamt) error {
+					var first, _ = h.Get(key(0))
+					for i := 1; i < 200; i++ {
+						if val, _ := h.Get(key(i)); val != first {
+							return errors.Errorf("Get(%s) => %v; Get(%s) => %v",
+								key(i), val, key(0), first)
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					t.Errorf("View() => %s", err)
+					return
+				}
+			}
+		}()
+	}
+	for v := 3; v < 20; v++ {
+		putRange(t, db, 0, 200, v)
+	}
+	wg.Wait()
+}
+
+func TestCheckpoint(t *testing.T) {
+	var dir = t.TempDir()
+	var db = openDB(t, dir)
+
+	var expected = make(map[hamt64.KeyI]int)
+	for i := 0; i < 5; i++ {
+		putRange(t, db, i*100, (i+1)*100, i)
+		for j := i * 100; j < (i+1)*100; j++ {
+			expected[key(j)] = i
+		}
+	}
+
+	var before = logFiles(t, dir)
+	var oldLog, _ = os.ReadFile(before[0])
+	if err := db.Checkpoint(); err != nil {
+		t.Fatalf("failed Checkpoint() => %s", err)
+	}
+	var after = logFiles(t, dir)
+	if len(after) != 1 || after[0] == before[0] {
+		t.Fatalf("logs %v after Checkpoint(); were %v", after, before)
+	}
+	var snaps, _ = filepath.Glob(filepath.Join(dir, "snapshot-*"))
+	if len(snaps) != 1 {
+		t.Fatalf("%d snapshots after Checkpoint()", len(snaps))
+	}
+
+	putRange(t, db, 0, 10, -1)
+	for i := 0; i < 10; i++ {
+		expected[key(i)] = -1
+	}
+
+	// no Close; a crash after the last commit
+	db = openDB(t, dir)
+	checkDB(t, db, expected)
+	db.Close()
+
+	// a crash before the snapshot was renamed into place leaves the old
+	// log, the new log and a temporary file
+	os.Rename(snaps[0], snaps[0]+".tmp")
+	os.WriteFile(before[0], oldLog, 0644)
+	db = openDB(t, dir)
+	checkDB(t, db, expected)
+	db.Close()
+}
+
+func TestTornCommit(t *testing.T) {
+	var dir = t.TempDir()
+	var db = openDB(t, dir)
+
+	putRange(t, db, 0, 100, 1)
+	putRange(t, db, 0, 100, 2)
+	db.Close()
+
+	var logs = logFiles(t, dir)
+	var data, _ = os.ReadFile(logs[0])
+	os.WriteFile(logs[0], data[:len(data)-3], 0644)
+
+	db = openDB(t, dir)
+	var expected = make(map[hamt64.KeyI]int)
+	for i := 0; i < 100; i++ {
+		expected[key(i)] = 1
+	}
+	checkDB(t, db, expected)
+
+	// the torn commit was truncated, so later ones are readable
+	putRange(t, db, 0, 100, 3)
+	db.Close()
+	db = openDB(t, dir)
+	for i := 0; i < 100; i++ {
+		expected[key(i)] = 3
+	}
+	checkDB(t, db, expected)
+	db.Close()
+}
+
+func TestCorruptCommit(t *testing.T) {
+	var dir = t.TempDir()
+	var db = openDB(t, dir)
+
+	putRange(t, db, 0, 100, 1)
+	putRange(t, db, 0, 100, 2)
+	db.Close()
+
+	// a bad crc in the first commit is not a torn write
+	var logs = logFiles(t, dir)
+	var data, _ = os.ReadFile(logs[0])
+	var size = int(binary.LittleEndian.Uint32(data))
+	data[8+size-1] ^= 1
+	os.WriteFile(logs[0], data, 0644)
+
+	if db, err := hamtdb.Open(dir); err == nil {
+		db.Close()
+		t.Fatalf("hamtdb.Open() with a corrupt commit succeeded")
+	}
+	if fi, _ := os.Stat(logs[0]); fi.Size() != int64(len(data)) {
+		t.Fatalf("hamtdb.Open() truncated the log to %d", fi.Size())
+	}
+}
@@ -0,0 +1,57 @@
+package hamtdb
+
+import (
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+// Tx is a read-write transaction, made by DB.Update. It sees the root the
+// Update started from plus its own changes, which no View sees until the
+// Update commits. The changes are made in place on a HamtTransient that
+// shares every table it has not modified with the root, so a Tx costs in
+// proportion to what it changes.
+//
+// A Tx is only valid inside the function passed to Update, and only on the
+// goroutine running it; using it afterwards panics.
+type Tx struct {
+	h       *hamt64.HamtTransient
+	changed bool
+}
+
+func (tx *Tx) hamt() *hamt64.HamtTransient {
+	if tx.h == nil {
+		panic("hamtdb: Tx used after its Update returned")
+	}
+	return tx.h
+}
+
+// Get retrieves the value of the key.
+func (tx *Tx) Get(key hamt64.KeyI) (interface{}, bool) {
+	return tx.hamt().Get(key)
+}
+
+// Put stores the key and value. It returns if the key was added, as opposed
+// to replaced.
+func (tx *Tx) Put(key hamt64.KeyI, val interface{}) bool {
+	var _, added = tx.hamt().Put(key, val)
+	tx.changed = true
+	return added
+}
+
+// Del deletes the key, if it exists. It returns the value that was deleted
+// and if it was.
+func (tx *Tx) Del(key hamt64.KeyI) (interface{}, bool) {
+	var _, val, deleted = tx.hamt().Del(key)
+	tx.changed = tx.changed || deleted
+	return val, deleted
+}
+
+// Nentries returns the number of (key,value) pairs.
+func (tx *Tx) Nentries() uint {
+	return tx.hamt().Nentries()
+}
+
+// Range calls fn for every KeyVal pair, until fn returns false. fn must not
+// call Put or Del.
+func (tx *Tx) Range(fn func(hamt64.KeyI, interface{}) bool) {
+	tx.hamt().Range(fn)
+}
@@ -0,0 +1,446 @@
+/*
+Package hamthttp exposes a hamt64.HamtFunctional over HTTP, with JSON bodies,
+for inspection and controlled edits. A Handler serves:
+
+	GET    /keys/{key}  the value of the key
+	PUT    /keys/{key}  put the JSON value in the body
+	DELETE /keys/{key}  delete the key
+	GET    /keys        a page of entries; ?cursor=c&limit=n
+	GET    /stats       the hamt64.Stats of the Hamt
+	GET    /snapshot    a dump of the Hamt; ?format=json (default) or binary
+
+Keys in the path are hamt64.StringKeys. Values are decoded by
+hamt64.DecodeJSONValue, so JSON objects become nested HamtFunctionals, and
+encoded with encoding/json, which writes nested Hamts as objects. The binary
+snapshots encode nested HamtFunctionals and arrays with the codecs of
+RegisterCodecs.
+
+Every write makes a new root, which replaces the current one by an atomic
+compare-and-swap of the root and its version number, retried until it
+succeeds; so concurrent writers never lose an update. Every response
+carries the version it was made from in its ETag header, and a write with
+an If-Match header only succeeds if the root is still at that version,
+otherwise it fails with 412 Precondition Failed. A client can so
+read-modify-write a key without losing a concurrent update either.
+
+Mount a Handler under a prefix with http.StripPrefix.
+*/
+package hamthttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/pstuifzand/go-hamt/hamt64"
+)
+
+// maxValueSize bounds the body of a PUT.
+const maxValueSize = 1 << 20
+
+// DefaultLimit is the number of entries in a page of GET /keys without a
+// limit; MaxLimit is the largest limit accepted.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 10000
+)
+
+// root is a version of the Hamt.
+type root struct {
+	h       *hamt64.HamtFunctional
+	version uint64
+}
+
+// errVersion is returned by an update whose If-Match version is not the
+// current one.
+var errVersion = errors.New("version mismatch")
+
+// Handler is an http.Handler serving one HamtFunctional. It is safe to use
+// from several goroutines.
+type Handler struct {
+	root atomic.Pointer[root]
+	reg  *hamt64.Registry
+	mux  *http.ServeMux
+}
+
+// NewHandler constructs a Handler serving h, at version 1. The binary
+// snapshots are written with the codecs of reg, and NewHandler calls
+// RegisterCodecs on it. If reg is nil the Handler uses a Registry of its own,
+// from hamt64.NewRegistry, so hamt64.DefaultRegistry is never modified; decode
+// its snapshots with a Registry that RegisterCodecs was called on, too.
+func NewHandler(h *hamt64.HamtFunctional, reg *hamt64.Registry) *Handler {
+	if reg == nil {
+		reg = hamt64.NewRegistry()
+	}
+	RegisterCodecs(reg)
+
+	var hh = &Handler{reg: reg, mux: http.NewServeMux()}
+	hh.root.Store(&root{h: h, version: 1})
+
+	hh.mux.HandleFunc("GET /keys/{key...}", hh.getKey)
+	hh.mux.HandleFunc("PUT /keys/{key...}", hh.putKey)
+	hh.mux.HandleFunc("DELETE /keys/{key...}", hh.deleteKey)
+	hh.mux.HandleFunc("GET /keys", hh.listKeys)
+	hh.mux.HandleFunc("GET /stats", hh.stats)
+	hh.mux.HandleFunc("GET /snapshot", hh.snapshot)
+
+	return hh
+}
+
+// The names of the codecs registered by RegisterCodecs.
+const (
+	ObjectCodecName = "hamthttp.object"
+	ArrayCodecName  = "hamthttp.array"
+)
+
+// RegisterCodecs registers in reg the codecs for the values a PUT body
+// decodes to that have none built in: *hamt64.HamtFunctional, for JSON
+// objects, under ObjectCodecName, and []interface{}, for JSON arrays, under
+// ArrayCodecName. Both write the value as JSON, and read it back with
+// hamt64.DecodeJSONValue. A codec reg already has, for either name or type,
+// is left as it is.
+//
+// Register them in the Registry that decodes a binary snapshot, too.
+func RegisterCodecs(reg *hamt64.Registry) {
+	var enc = func(v interface{}) ([]byte, error) {
+		return json.Marshal(v)
+	}
+	var dec = func(bs []byte) (interface{}, error) {
+		return hamt64.DecodeJSONValue(bytes.NewReader(bs), true)
+	}
+
+	// Register only fails for a name or type that is registered already.
+	reg.Register(ObjectCodecName, (*hamt64.HamtFunctional)(nil), enc, dec)
+	reg.Register(ArrayCodecName, []interface{}(nil), enc, dec)
+}
+
+// Root returns the current root and its version.
+func (hh *Handler) Root() (*hamt64.HamtFunctional, uint64) {
+	var r = hh.root.Load()
+	return r.h, r.version
+}
+
+// Update replaces the root with the one fn returns for it, by a
+// compare-and-swap, and returns the new version. If another write replaced
+// the root in the meantime, fn is called again on the new root; so fn may be
+// called more than once, and must have no other effects. Use it for writes
+// that do not come through the Handler.
+func (hh *Handler) Update(
+	fn func(h *hamt64.HamtFunctional) *hamt64.HamtFunctional,
+) uint64 {
+	var version, _ = hh.update(0, func(h *hamt64.HamtFunctional) (
+		*hamt64.HamtFunctional,
+		error,
+	) {
+		return fn(h), nil
+	})
+	return version
+}
+
+// update is Update with an If-Match version, 0 for none, and an fn that can
+// fail. If fn returns nil the root is left as it is.
+func (hh *Handler) update(
+	ifMatch uint64,
+	fn func(h *hamt64.HamtFunctional) (*hamt64.HamtFunctional, error),
+) (uint64, error) {
+	for {
+		var old = hh.root.Load()
+		if ifMatch != 0 && ifMatch != old.version {
+			return old.version, errVersion
+		}
+
+		var h, err = fn(old.h)
+		if err != nil || h == nil {
+			return old.version, err
+		}
+
+		var next = &root{h: h, version: old.version + 1}
+		if hh.root.CompareAndSwap(old, next) {
+			return next.version, nil
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (hh *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hh.mux.ServeHTTP(w, r)
+}
+
+func setETag(w http.ResponseWriter, version uint64) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(version, 10)))
+}
+
+// ifMatch returns the version in the If-Match header of r, or 0 if there is
+// none or it is "*".
+func ifMatch(r *http.Request) (uint64, error) {
+	var s = strings.TrimSpace(r.Header.Get("If-Match"))
+	if s == "" || s == "*" {
+		return 0, nil
+	}
+	var unq, err = strconv.Unquote(s)
+	if err == nil {
+		var v uint64
+		v, err = strconv.ParseUint(unq, 10, 64)
+		if err == nil && v != 0 {
+			return v, nil
+		}
+	}
+	return 0, errors.Errorf("bad If-Match %s", s)
+}
+
+// writeJSON writes v as the JSON body of a response with the status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	var bs, err = json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(bs, '\n'))
+}
+
+// writeError writes err as a JSON {"error": message} body.
+func writeError(w http.ResponseWriter, code int, err error) {
+	var bs, _ = json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(append(bs, '\n'))
+}
+
+func (hh *Handler) getKey(w http.ResponseWriter, r *http.Request) {
+	var rt = hh.root.Load()
+	var key = hamt64.StringKey(r.PathValue("key"))
+	setETag(w, rt.version)
+
+	var val, found = rt.h.Get(key)
+	if !found {
+		writeError(w, http.StatusNotFound,
+			errors.Errorf("key %q not found", string(key)))
+		return
+	}
+	writeJSON(w, http.StatusOK, val)
+}
+
+// writeResult is the body of the response to a PUT or DELETE.
+type writeResult struct {
+	Version uint64 `json:"version"`
+	Added   bool   `json:"added,omitempty"`
+}
+
+// writeUpdateError writes the response to an update that failed with err.
+func writeUpdateError(w http.ResponseWriter, version uint64, err error) {
+	setETag(w, version)
+	if err == errVersion {
+		writeError(w, http.StatusPreconditionFailed, errors.Errorf(
+			"the version is %d", version))
+		return
+	}
+	writeError(w, http.StatusNotFound, err)
+}
+
+func (hh *Handler) putKey(w http.ResponseWriter, r *http.Request) {
+	var key = hamt64.StringKey(r.PathValue("key"))
+	var match, err = ifMatch(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if !json.Valid(body) {
+		writeError(w, http.StatusBadRequest,
+			errors.New("the body is not one JSON value"))
+		return
+	}
+	val, err := hamt64.DecodeJSONValue(bytes.NewReader(body), true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var added bool
+	version, err := hh.update(match, func(h *hamt64.HamtFunctional) (
+		*hamt64.HamtFunctional,
+		error,
+	) {
+		var nh hamt64.Hamt
+		nh, added = h.Put(key, val)
+		return nh.(*hamt64.HamtFunctional), nil
+	})
+	if err != nil {
+		writeUpdateError(w, version, err)
+		return
+	}
+
+	setETag(w, version)
+	var code = http.StatusOK
+	if added {
+		code = http.StatusCreated
+	}
+	writeJSON(w, code, writeResult{Version: version, Added: added})
+}
+
+func (hh *Handler) deleteKey(w http.ResponseWriter, r *http.Request) {
+	var key = hamt64.StringKey(r.PathValue("key"))
+	var match, err = ifMatch(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	version, err := hh.update(match, func(h *hamt64.HamtFunctional) (
+		*hamt64.HamtFunctional,
+		error,
+	) {
+		var nh, _, deleted = h.Del(key)
+		if !deleted {
+			return nil, errors.Errorf("key %q not found", string(key))
+		}
+		return nh.(*hamt64.HamtFunctional), nil
+	})
+	if err != nil {
+		writeUpdateError(w, version, err)
+		return
+	}
+
+	setETag(w, version)
+	writeJSON(w, http.StatusOK, writeResult{Version: version})
+}
+
+// entry is one element of a page of GET /keys.
+type entry struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// page is the body of the response to GET /keys. Cursor is the cursor of
+// the next page, and empty on the last page.
+type page struct {
+	Entries []entry `json:"entries"`
+	Cursor  string  `json:"cursor,omitempty"`
+}
+
+// listKeys serves a page of entries in trie order, by hamt64.Scan. The cursor
+// is a position in the trie, so paging on while the Hamt is written returns
+// every key present throughout exactly once. A page may hold a few more than
+// limit entries.
+func (hh *Handler) listKeys(w http.ResponseWriter, r *http.Request) {
+	var q = r.URL.Query()
+
+	var cursor uint64
+	if s := q.Get("cursor"); s != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(s, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest,
+				errors.Errorf("bad cursor %q", s))
+			return
+		}
+	}
+	var limit = DefaultLimit
+	if s := q.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			writeError(w, http.StatusBadRequest,
+				errors.Errorf("bad limit %q", s))
+			return
+		}
+	}
+
+	var rt = hh.root.Load()
+	var p = page{Entries: make([]entry, 0, limit)}
+	cursor = hamt64.Scan(rt.h, cursor, limit,
+		func(k hamt64.KeyI, v interface{}) {
+			p.Entries = append(p.Entries, entry{keyString(k), v})
+		})
+	if cursor != 0 {
+		p.Cursor = strconv.FormatUint(cursor, 10)
+	}
+
+	setETag(w, rt.version)
+	writeJSON(w, http.StatusOK, p)
+}
+
+// keyString returns the key as it is shown in a listing.
+func keyString(k hamt64.KeyI) string {
+	if sk, isString := k.(hamt64.StringKey); isString {
+		return string(sk)
+	}
+	return fmt.Sprint(k)
+}
+
+// statsResult is the body of the response to GET /stats.
+type statsResult struct {
+	Version  uint64        `json:"version"`
+	Nentries uint          `json:"nentries"`
+	Stats    *hamt64.Stats `json:"stats"`
+}
+
+func (hh *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	var rt = hh.root.Load()
+	setETag(w, rt.version)
+	writeJSON(w, http.StatusOK, statsResult{
+		Version:  rt.version,
+		Nentries: rt.h.Nentries(),
+		Stats:    rt.h.Stats(),
+	})
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(bs []byte) (int, error) {
+	var n, err = cw.w.Write(bs)
+	cw.n += int64(n)
+	return n, err
+}
+
+// snapshot streams the root, written by hamt64.EncodeJSON with the pairs
+// option, or by hamt64.Encode for ?format=binary. The root does not change
+// while it is written, however long that takes. An error before anything was
+// written is a 500; after, the response can only be cut short.
+func (hh *Handler) snapshot(w http.ResponseWriter, r *http.Request) {
+	var rt = hh.root.Load()
+
+	var encode func(io.Writer) error
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		encode = func(w io.Writer) error {
+			return hamt64.EncodeJSON(w, rt.h, true)
+		}
+	case "binary":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		encode = func(w io.Writer) error {
+			return hamt64.Encode(w, rt.h, hh.reg)
+		}
+	default:
+		writeError(w, http.StatusBadRequest,
+			errors.Errorf("unknown format %q", format))
+		return
+	}
+
+	setETag(w, rt.version)
+	var cw = &countingWriter{w: w}
+	if err := encode(cw); err != nil {
+		if cw.n == 0 {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,313 @@
+package hamthttp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pstuifzand/go-hamt/hamt64"
+	"github.com/pstuifzand/go-hamt/hamthttp"
+)
+
+// do sends a request to the server and returns the response and its body.
+func do(
+	t *testing.T,
+	srv *httptest.Server,
+	method, path, body string,
+	hdr ...string,
+) (*http.Response, []byte) {
+	var req, err = http.NewRequest(method, srv.URL+path,
+		strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(hdr); i += 2 {
+		req.Header.Set(hdr[i], hdr[i+1])
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed => %s", method, path, err)
+	}
+	defer resp.Body.Close()
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s failed to read body => %s", method, path, err)
+	}
+	return resp, bs
+}
+
+// expect sends a request and checks its status code and, if body is not
+// empty, its body.
+func expect(
+	t *testing.T,
+	srv *httptest.Server,
+	code int,
+	body string,
+	method, path, reqBody string,
+	hdr ...string,
+) *http.Response {
+	t.Helper()
+	var resp, bs = do(t, srv, method, path, reqBody, hdr...)
+	if resp.StatusCode != code {
+		t.Fatalf("%s %s => %d %s; expected %d", method, path,
+			resp.StatusCode, bs, code)
+	}
+	if body != "" && strings.TrimSpace(string(bs)) != body {
+		t.Fatalf("%s %s => %s; expected %s", method, path, bs, body)
+	}
+	return resp
+}
+
+func newServer(t *testing.T, nkeys int) (*hamthttp.Handler, *httptest.Server) {
+	var h = hamt64.Hamt(hamt64.NewFunctional(hamt64.HybridTables))
+	for i := 0; i < nkeys; i++ {
+		h, _ = h.Put(hamt64.StringKey(fmt.Sprintf("key%d", i)), i)
+	}
+	var hh = hamthttp.NewHandler(h.(*hamt64.HamtFunctional), nil)
+	var srv = httptest.NewServer(hh)
+	t.Cleanup(srv.Close)
+	return hh, srv
+}
+
+func TestKeys(t *testing.T) {
+	var hh, srv = newServer(t, 10)
+
+	expect(t, srv, 200, `3`, "GET", "/keys/key3", "")
+	expect(t, srv, 404, `{"error":"key \"nope\" not found"}`,
+		"GET", "/keys/nope", "")
+
+	var resp = expect(t, srv, 201, `{"version":2,"added":true}`,
+		"PUT", "/keys/a/b c", `{"x": [1, "y"], "z": null}`)
+	if etag := resp.Header.Get("ETag"); etag != `"2"` {
+		t.Fatalf("ETag of PUT => %s", etag)
+	}
+	expect(t, srv, 200, `{"x":[1,"y"],"z":null}`, "GET", "/keys/a/b%20c", "")
+	expect(t, srv, 200, `{"version":3}`, "PUT", "/keys/key3", `"three"`)
+	expect(t, srv, 200, `"three"`, "GET", "/keys/key3", "")
+
+	expect(t, srv, 400, "", "PUT", "/keys/bad", `{"x": `)
+	expect(t, srv, 400, "", "PUT", "/keys/bad", `1 2`)
+	expect(t, srv, 413, "", "PUT", "/keys/big",
+		`"`+strings.Repeat("x", 2<<20)+`"`)
+
+	expect(t, srv, 200, `{"version":4}`, "DELETE", "/keys/key3", "")
+	expect(t, srv, 404, "", "DELETE", "/keys/key3", "")
+	expect(t, srv, 404, "", "GET", "/keys/key3", "")
+	expect(t, srv, 405, "", "POST", "/keys/key3", "")
+
+	var h, version = hh.Root()
+	if version != 4 || h.Nentries() != 10 {
+		t.Fatalf("Root() => %d entries at version %d", h.Nentries(), version)
+	}
+	var v, _ = h.Get(hamt64.StringKey("a/b c"))
+	if _, isHamt := v.(*hamt64.HamtFunctional); !isHamt {
+		t.Fatalf("a PUT JSON object is not stored as a HamtFunctional")
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	var _, srv = newServer(t, 10)
+
+	var resp = expect(t, srv, 200, "", "GET", "/keys/key1", "")
+	var etag = resp.Header.Get("ETag")
+
+	// another write gets in between
+	expect(t, srv, 200, "", "PUT", "/keys/key2", `-2`)
+
+	expect(t, srv, 412, "", "PUT", "/keys/key1", `-1`, "If-Match", etag)
+	expect(t, srv, 412, "", "DELETE", "/keys/key1", "", "If-Match", etag)
+	expect(t, srv, 200, `1`, "GET", "/keys/key1", "")
+	expect(t, srv, 400, "", "PUT", "/keys/key1", `-1`, "If-Match", "x")
+
+	resp = expect(t, srv, 200, "", "GET", "/keys/key1", "")
+	expect(t, srv, 200, "", "PUT", "/keys/key1", `-1`,
+		"If-Match", resp.Header.Get("ETag"))
+	expect(t, srv, 200, "", "PUT", "/keys/key1", `-11`, "If-Match", "*")
+	expect(t, srv, 200, `-11`, "GET", "/keys/key1", "")
+}
+
+// TestConcurrentWrites checks that no update is lost between writers: each
+// one PUTs its own keys, and reads and increments a shared counter with
+// If-Match retries.
+func TestConcurrentWrites(t *testing.T) {
+	var hh, srv = newServer(t, 0)
+	var nwriters, nputs = 8, 25
+	expect(t, srv, 201, "", "PUT", "/keys/counter", `0`)
+
+	var wg sync.WaitGroup
+	for w := 0; w < nwriters; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < nputs; i++ {
+				var path = fmt.Sprintf("/keys/w%d-%d", w, i)
+				var resp, _ = do(t, srv, "PUT", path, `1`)
+				if resp.StatusCode != 201 {
+					t.Errorf("PUT %s => %d", path, resp.StatusCode)
+					return
+				}
+
+				for {
+					var resp, bs = do(t, srv, "GET", "/keys/counter", "")
+					var n int
+					json.Unmarshal(bs, &n)
+					resp, _ = do(t, srv, "PUT", "/keys/counter",
+						fmt.Sprint(n+1), "If-Match", resp.Header.Get("ETag"))
+					if resp.StatusCode == 200 {
+						break
+					}
+					if resp.StatusCode != 412 {
+						t.Errorf("PUT /keys/counter => %d", resp.StatusCode)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	// writes that do not come through HTTP are not lost either
+	for i := 0; i < nputs; i++ {
+		var key = hamt64.StringKey(fmt.Sprintf("direct%d", i))
+		hh.Update(func(h *hamt64.HamtFunctional) *hamt64.HamtFunctional {
+			var nh, _ = h.Put(key, i)
+			return nh.(*hamt64.HamtFunctional)
+		})
+	}
+	wg.Wait()
+
+	var h, _ = hh.Root()
+	if h.Nentries() != uint(1+nwriters*nputs+nputs) {
+		t.Fatalf("%d entries after %d PUTs and %d Updates", h.Nentries(),
+			nwriters*nputs, nputs)
+	}
+	var n, _ = h.Get(hamt64.StringKey("counter"))
+	if n != float64(nwriters*nputs) {
+		t.Fatalf("counter => %v after %d increments", n, nwriters*nputs)
+	}
+}
+
+func TestListKeys(t *testing.T) {
+	var _, srv = newServer(t, 250)
+
+	type page struct {
+		Entries []struct {
+			Key   string
+			Value int
+		}
+		Cursor string
+	}
+
+	var seen = make(map[string]int)
+	var path = "/keys?limit=40"
+	for {
+		var _, bs = do(t, srv, "GET", path, "")
+		var p page
+		if err := json.Unmarshal(bs, &p); err != nil {
+			t.Fatalf("GET %s => %s; %s", path, bs, err)
+		}
+		for _, e := range p.Entries {
+			if e.Key != fmt.Sprintf("key%d", e.Value) {
+				t.Fatalf("GET %s => %s: %d", path, e.Key, e.Value)
+			}
+			seen[e.Key]++
+		}
+		if p.Cursor == "" {
+			break
+		}
+		if len(p.Entries) < 40 {
+			t.Fatalf("GET %s => %d entries", path, len(p.Entries))
+		}
+		path = "/keys?limit=40&cursor=" + p.Cursor
+	}
+	if len(seen) != 250 {
+		t.Fatalf("listing returned %d keys of 250", len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("listing returned %s %d times", k, n)
+		}
+	}
+
+	expect(t, srv, 400, "", "GET", "/keys?limit=0", "")
+	expect(t, srv, 400, "", "GET", "/keys?cursor=x", "")
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	var hh, srv = newServer(t, 1000)
+	var h, _ = hh.Root()
+
+	var _, bs = do(t, srv, "GET", "/stats", "")
+	var stats struct {
+		Version  uint64
+		Nentries uint
+		Stats    hamt64.Stats
+	}
+	if err := json.Unmarshal(bs, &stats); err != nil {
+		t.Fatalf("GET /stats => %s; %s", bs, err)
+	}
+	if stats.Version != 1 || stats.Nentries != 1000 ||
+		stats.Stats != *h.Stats() {
+		t.Fatalf("GET /stats => %s", bs)
+	}
+
+	_, bs = do(t, srv, "GET", "/snapshot", "")
+	var jh, err = hamt64.DecodeJSON(bytes.NewReader(bs), true)
+	if err != nil {
+		t.Fatalf("GET /snapshot => invalid JSON %s", err)
+	}
+	var valEq = func(a, b interface{}) bool {
+		return float64(a.(int)) == b.(float64)
+	}
+	if !hamt64.Equal(h, jh, valEq) {
+		t.Fatalf("GET /snapshot is not the Hamt")
+	}
+
+	var reg = hamt64.NewRegistry()
+	hamthttp.RegisterCodecs(reg)
+
+	_, bs = do(t, srv, "GET", "/snapshot?format=binary", "")
+	bh, err := hamt64.Decode(bytes.NewReader(bs), true, reg)
+	if err != nil {
+		t.Fatalf("GET /snapshot?format=binary => %s", err)
+	}
+	if !hamt64.Equal(h, bh, nil) {
+		t.Fatalf("GET /snapshot?format=binary is not the Hamt")
+	}
+
+	expect(t, srv, 400, "", "GET", "/snapshot?format=xml", "")
+
+	// every kind of JSON value has a binary codec
+	hh, srv = newServer(t, 0)
+	for k, v := range map[string]string{
+		"obj":  `{"a": [1, {"b": null}], "c": {}}`,
+		"arr":  `[true, "x", 2.5, []]`,
+		"null": `null`,
+	} {
+		expect(t, srv, 201, "", "PUT", "/keys/"+k, v)
+	}
+	h, _ = hh.Root()
+	_, bs = do(t, srv, "GET", "/snapshot?format=binary", "")
+	bh, err = hamt64.Decode(bytes.NewReader(bs), true, reg)
+	if err != nil {
+		t.Fatalf("GET /snapshot?format=binary of JSON values => %s", err)
+	}
+
+	// NewHandler(h, nil) left DefaultRegistry as it was
+	if _, err = hamt64.Decode(bytes.NewReader(bs), true, nil); err == nil {
+		t.Fatalf("DefaultRegistry decodes the codecs of RegisterCodecs")
+	}
+	var jsonEq = func(a, b interface{}) bool {
+		var abs, _ = json.Marshal(a)
+		var bbs, _ = json.Marshal(b)
+		return bytes.Equal(abs, bbs)
+	}
+	if !hamt64.Equal(h, bh, jsonEq) {
+		t.Fatalf("GET /snapshot?format=binary of JSON values is not the Hamt")
+	}
+}
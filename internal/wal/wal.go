@@ -0,0 +1,340 @@
+/*
+Package wal keeps the snapshots and write-ahead logs of hamt64.Durable and
+hamtdb.DB, in a directory of files named
+
+	snapshot-<seq>  the state as it was before the first record of log <seq>
+	<log>-<seq>     the records since the snapshot, in order
+
+where <seq> is 16 hex digits and <log> is the log prefix of the Dir. What
+the snapshots and records hold is up to the caller. Every log record is:
+
+	len:uint32 crc:uint32 payload:[len]byte
+
+with the CRC-32C of the payload, and the integers little-endian.
+
+A record that runs to the end of the last log, but is cut short or does not
+match its crc, is taken to be left by a crash in the middle of a write, and
+Replay truncates the log before it. A bad record anywhere else is corruption;
+Replay fails, and leaves the log as it is.
+*/
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HeaderSize is the size of the header of a log record.
+const HeaderSize = 8
+
+// maxRecordLen bounds the length of a log record, so a corrupt length can not
+// make Replay allocate an arbitrary amount of memory.
+const maxRecordLen = 1 << 30
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTornRecord is returned by readRecord for a bad record that runs to the
+// end of the log, as a write torn by a crash does.
+var errTornRecord = errors.New("torn record")
+
+// Dir is a directory of snapshots and logs.
+type Dir struct {
+	Path      string
+	LogPrefix string
+}
+
+func (d Dir) path(prefix string, seq uint64) string {
+	return filepath.Join(d.Path, fmt.Sprintf("%s-%016x", prefix, seq))
+}
+
+// SnapshotPath returns the path of the snapshot seq.
+func (d Dir) SnapshotPath(seq uint64) string {
+	return d.path("snapshot", seq)
+}
+
+// LogPath returns the path of the log seq.
+func (d Dir) LogPath(seq uint64) string {
+	return d.path(d.LogPrefix, seq)
+}
+
+// listFiles returns the seqs of the snapshots and logs in the directory, in
+// increasing order, and removes any temporary files left by a crash.
+func (d Dir) listFiles() (snaps, logs []uint64, err error) {
+	var ents, rerr = os.ReadDir(d.Path)
+	if rerr != nil {
+		return nil, nil, rerr
+	}
+
+	for _, ent := range ents {
+		var name = ent.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(d.Path, name))
+			continue
+		}
+		var prefix, hex, found = strings.Cut(name, "-")
+		if !found || len(hex) != 16 {
+			continue
+		}
+		var seq, perr = strconv.ParseUint(hex, 16, 64)
+		if perr != nil {
+			continue
+		}
+		switch prefix {
+		case "snapshot":
+			snaps = append(snaps, seq)
+		case d.LogPrefix:
+			logs = append(logs, seq)
+		}
+	}
+
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i] < snaps[j] })
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+
+	return snaps, logs, nil
+}
+
+// Recover returns the seq of the latest snapshot, or 0 if there is none, and
+// the seqs of the logs to replay after it, in order. It removes what an
+// interrupted checkpoint left over: temporary files, and the snapshots and
+// logs before the latest snapshot.
+func (d Dir) Recover() (snap uint64, logs []uint64, err error) {
+	snaps, all, err := d.listFiles()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(snaps) > 0 {
+		snap = snaps[len(snaps)-1]
+	}
+	d.removeBefore(snap, snaps, all)
+	for _, seq := range all {
+		if seq >= snap {
+			logs = append(logs, seq)
+		}
+	}
+	return snap, logs, nil
+}
+
+// RemoveBefore removes the snapshots and logs before seq.
+func (d Dir) RemoveBefore(seq uint64) error {
+	var snaps, logs, err = d.listFiles()
+	if err != nil {
+		return err
+	}
+	d.removeBefore(seq, snaps, logs)
+	return nil
+}
+
+func (d Dir) removeBefore(seq uint64, snaps, logs []uint64) {
+	for _, s := range snaps {
+		if s < seq {
+			os.Remove(d.SnapshotPath(s))
+		}
+	}
+	for _, s := range logs {
+		if s < seq {
+			os.Remove(d.LogPath(s))
+		}
+	}
+}
+
+// Replay calls apply with the payload of every record of the log seq, in
+// order. If last is true a torn record at the end of the log is truncated;
+// any other bad record, or an error from apply, is an error.
+func (d Dir) Replay(seq uint64, last bool, apply func([]byte) error) error {
+	var f, err = os.OpenFile(d.LogPath(seq), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var r = bufio.NewReader(f)
+	var off int64
+	for {
+		var payload, rerr = readRecord(r, fi.Size()-off)
+		if rerr == io.EOF {
+			return nil
+		}
+		if rerr != nil {
+			if !last || errors.Cause(rerr) != errTornRecord {
+				return errors.Wrapf(rerr, "log %016x at offset %d", seq, off)
+			}
+			if err = f.Truncate(off); err != nil {
+				return err
+			}
+			return f.Sync()
+		}
+
+		// A record that is whole but can not be applied is not a torn
+		// write.
+		if err = apply(payload); err != nil {
+			return errors.Wrapf(err, "log %016x at offset %d", seq, off)
+		}
+
+		off += int64(HeaderSize + len(payload))
+	}
+}
+
+// readRecord reads one record, with remain bytes left in the log, and checks
+// its crc. It returns io.EOF only at the clean end of the log, and
+// errTornRecord for a record that is cut short, or fails its crc and ends the
+// log.
+func readRecord(r *bufio.Reader, remain int64) ([]byte, error) {
+	if remain == 0 {
+		return nil, io.EOF
+	}
+	if remain < HeaderSize {
+		return nil, errors.Wrap(errTornRecord, "header cut short")
+	}
+
+	var hdr [HeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, noEOF(err)
+	}
+
+	var size = binary.LittleEndian.Uint32(hdr[:4])
+	var crc = binary.LittleEndian.Uint32(hdr[4:])
+	if size > maxRecordLen {
+		return nil, errors.Errorf("record length %d too long", size)
+	}
+	var end = HeaderSize + int64(size)
+	if end > remain {
+		return nil, errors.Wrap(errTornRecord, "record cut short")
+	}
+
+	var payload = make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, noEOF(err)
+	}
+	if crc32.Checksum(payload, crcTable) != crc {
+		if end == remain {
+			return nil, errors.Wrap(errTornRecord, "record crc mismatch")
+		}
+		return nil, errors.New("record crc mismatch")
+	}
+
+	return payload, nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// WriteSnapshot calls write to write the snapshot seq, under a temporary
+// name until it is complete and fsynced.
+func (d Dir) WriteSnapshot(seq uint64, write func(io.Writer) error) error {
+	var path = d.SnapshotPath(seq)
+	var f, err = os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	syncDir(d.Path)
+	return nil
+}
+
+// Log is a log open for appending.
+//
+// If writing or fsyncing it fails, the log may hold part of a record, or may
+// have lost records written before, so every later Append and Sync fails
+// too. The log must be closed and replayed, which recovers what it holds.
+type Log struct {
+	f      *os.File
+	failed error // of the write or fsync that failed
+}
+
+// OpenLog opens the log seq for appending, creating it if need be.
+func (d Dir) OpenLog(seq uint64) (*Log, error) {
+	var f, err = os.OpenFile(d.LogPath(seq),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	syncDir(d.Path)
+	return &Log{f: f}, nil
+}
+
+// Err returns the error that failed the log, or nil.
+func (l *Log) Err() error {
+	if l.failed != nil {
+		return errors.WithMessage(l.failed, "log failed")
+	}
+	return nil
+}
+
+// Append fills in the header of the record rec, whose first HeaderSize bytes
+// are left for it, and appends it to the log.
+func (l *Log) Append(rec []byte) error {
+	if err := l.Err(); err != nil {
+		return err
+	}
+
+	var payload = rec[HeaderSize:]
+	binary.LittleEndian.PutUint32(rec[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(rec[4:], crc32.Checksum(payload, crcTable))
+
+	if _, err := l.f.Write(rec); err != nil {
+		l.failed = err
+		return err
+	}
+	return nil
+}
+
+// Sync fsyncs the log.
+func (l *Log) Sync() error {
+	if err := l.Err(); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		l.failed = err
+		return err
+	}
+	return nil
+}
+
+// Close closes the log.
+func (l *Log) Close() error {
+	return l.f.Close()
+}
+
+// syncDir fsyncs the directory, so the files created or renamed in it
+// survive a crash. Not every system can do that, so errors are ignored.
+func syncDir(dir string) {
+	if f, err := os.Open(dir); err == nil {
+		f.Sync()
+		f.Close()
+	}
+}